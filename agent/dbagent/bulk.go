@@ -0,0 +1,201 @@
+package dbagent
+
+//
+// Writing rows of dbWriter in transactions of bounded size, row by row,
+// or in bulk, by multi-row VALUES or LOAD DATA LOCAL INFILE.
+//
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Bulk write methods of dbWriter.
+const (
+	BulkNone   = ""       // one statement per row
+	BulkValues = "values" // one statement of many rows, multi-row VALUES
+	// BulkLoad loads a CSV with LOAD DATA LOCAL INFILE.  SQLite has no
+	// such statement, the sqlite3 shell imports CSV by inserting rows in
+	// one transaction, so it falls back to BulkValues.
+	BulkLoad = "load"
+
+	// DefaultBulkRows is the number of rows of a multi-row VALUES.
+	DefaultBulkRows = 500
+	// maxBulkParams bounds the parameters of a statement, sqlite allows
+	// 32766.
+	maxBulkParams = 30000
+)
+
+// checkBulk checks the bulk method works with the write mode.
+func checkBulk(bulk, mode, qtemplate string) error {
+	switch bulk {
+	case BulkNone:
+		return nil
+	case BulkValues:
+		if mode == WriteUpdate || mode == WriteDelete {
+			return fmt.Errorf("bulk %s cannot %s", bulk, mode)
+		}
+	case BulkLoad:
+		if mode != "" && mode != WriteInsert {
+			return fmt.Errorf("bulk %s can only insert", bulk)
+		}
+	default:
+		return fmt.Errorf("unknown bulk method: %s", bulk)
+	}
+	if qtemplate != "" {
+		return fmt.Errorf("bulk %s with qtemplate", bulk)
+	}
+	return nil
+}
+
+// valuesSQL repeats the VALUES row of an insert statement of ncol
+// columns nrow times.
+func valuesSQL(stmt string, ncol, nrow int) string {
+	one := "(" + strings.TrimSuffix(strings.Repeat("?, ", ncol), ", ") + ")"
+	rows := strings.TrimSuffix(strings.Repeat(one+", ", nrow), ", ")
+	return strings.Replace(stmt, "VALUES "+one, "VALUES "+rows, 1)
+}
+
+// writeRows writes params, rows of statement parameters, in transactions
+// of at most commit rows.  A failed transaction is rolled back, the rows
+// of earlier transactions stay written.
+func (c *dbWriter) writeRows(stmt string, columns []string, params [][]any) error {
+	commit := c.conf.CommitRows
+	if commit <= 0 {
+		commit = len(params)
+	}
+	for start := 0; start < len(params); start += commit {
+		end := min(start+commit, len(params))
+		if err := c.writeTx(stmt, columns, params[start:end]); err != nil {
+			return fmt.Errorf("write rows %d to %d: %w", start, end, err)
+		}
+	}
+	return nil
+}
+
+// writeTx writes params in one transaction.
+func (c *dbWriter) writeTx(stmt string, columns []string, params [][]any) (err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	switch {
+	case c.conf.Bulk == BulkLoad && c.db.Dialect() == DialectMySQL:
+		err = c.loadData(tx, columns, params)
+	case c.conf.Bulk == BulkValues || c.conf.Bulk == BulkLoad:
+		err = c.insertValues(tx, stmt, params)
+	default:
+		err = c.insertRows(tx, stmt, params)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertRows executes stmt once per row.
+func (c *dbWriter) insertRows(tx *sql.Tx, stmt string, params [][]any) error {
+	txStmt, err := tx.Prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer txStmt.Close()
+
+	for _, row := range params {
+		if _, err = txStmt.Exec(row...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertValues executes stmt with many rows in its VALUES.
+func (c *dbWriter) insertValues(tx *sql.Tx, stmt string, params [][]any) error {
+	ncol := len(params[0])
+	n := c.conf.BulkRows
+	if n <= 0 {
+		n = DefaultBulkRows
+	}
+	n = max(1, min(n, maxBulkParams/ncol))
+
+	args := make([]any, 0, n*ncol)
+	for start := 0; start < len(params); start += n {
+		end := min(start+n, len(params))
+		args = args[:0]
+		for _, row := range params[start:end] {
+			args = append(args, row...)
+		}
+		if _, err := tx.Exec(valuesSQL(stmt, ncol, end-start), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvField writes a value as a field of LOAD DATA, NULL is \N, others
+// are enclosed by " with " doubled and \ escaped.
+func csvField(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString(`\N`)
+		return
+	case bool:
+		if v {
+			buf.WriteString("1")
+		} else {
+			buf.WriteString("0")
+		}
+		return
+	}
+	s := fmt.Sprint(v)
+	if bs, ok := v.([]byte); ok {
+		s = string(bs)
+	}
+	buf.WriteByte('"')
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	buf.WriteString(strings.ReplaceAll(s, `"`, `""`))
+	buf.WriteByte('"')
+}
+
+var loadSeq atomic.Int64
+
+// loadData loads params with LOAD DATA LOCAL INFILE, from a CSV in memory.
+func (c *dbWriter) loadData(tx *sql.Tx, columns []string, params [][]any) error {
+	buf := &bytes.Buffer{}
+	for _, row := range params {
+		for i, v := range row {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			csvField(buf, v)
+		}
+		buf.WriteByte('\n')
+	}
+
+	name := fmt.Sprintf("dbwriter_%s_%d", c.conf.Table, loadSeq.Add(1))
+	mysql.RegisterReaderHandler(name, func() io.Reader { return buf })
+	defer mysql.DeregisterReaderHandler(name)
+
+	stmt := "LOAD DATA LOCAL INFILE 'Reader::" + name + "' INTO TABLE " + c.conf.Table +
+		` FIELDS TERMINATED BY ',' ENCLOSED BY '"' LINES TERMINATED BY '\n'`
+	if len(columns) > 0 {
+		qcols, err := quoteIdents(DialectMySQL, columns)
+		if err != nil {
+			return err
+		}
+		stmt += " (" + strings.Join(qcols, ", ") + ")"
+	}
+	_, err := tx.Exec(stmt)
+	return err
+}
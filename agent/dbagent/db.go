@@ -2,29 +2,102 @@ package dbagent
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"text/template"
+	"time"
 
-	"github.com/fengttt/gcl/dslite"
 	_ "github.com/go-sql-driver/mysql" // mysql driver
 	"github.com/olekukonko/tablewriter"
 )
 
+// SQL dialects of the supported drivers.
+const (
+	DialectMySQL  = "mysql" // MatrixOne, over the mysql protocol
+	DialectSqlite = "sqlite"
+)
+
 // MoDB is a wrapper for sql.DB
 type MoDB struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect string
+	// pool is the key of the shared pool of db, see openShared.
+	pool     string
+	replicas []*MoDB
+	next     atomic.Uint32
+}
+
+// Dialect returns the SQL dialect of the database.
+func (db *MoDB) Dialect() string {
+	return db.dialect
+}
+
+// QuoteIdent quotes a column name in dialect, with backticks for MySQL
+// and double quotes for sqlite, so that names like order or key work.
+// Names come from the input, only letters, digits and _ are allowed.
+func QuoteIdent(dialect, name string) (string, error) {
+	if !validField(name) {
+		return "", fmt.Errorf("invalid column name: %q", name)
+	}
+	if dialect == DialectSqlite {
+		return `"` + name + `"`, nil
+	}
+	return "`" + name + "`", nil
 }
 
-// Close closes the database connection.
+// quoteIdents quotes names, see QuoteIdent.
+func quoteIdents(dialect string, names []string) ([]string, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		q, err := QuoteIdent(dialect, name)
+		if err != nil {
+			return nil, err
+		}
+		quoted[i] = q
+	}
+	return quoted, nil
+}
+
+// IdDef returns the column definition of an auto increment primary key.
+func (db *MoDB) IdDef(col string) string {
+	if db.dialect == DialectSqlite {
+		return col + " integer primary key autoincrement"
+	}
+	return col + " int auto_increment not null primary key"
+}
+
+// Replica returns a read replica of the database, round robin, or the
+// database itself if it has no replica.   The replica is closed with the
+// database.
+func (db *MoDB) Replica() *MoDB {
+	if len(db.replicas) == 0 {
+		return db
+	}
+	n := db.next.Add(1)
+	return db.replicas[int(n)%len(db.replicas)]
+}
+
+// Close closes the database connection, the connection pool is closed
+// when no database uses it.
 func (db *MoDB) Close() error {
+	var err error
+	for _, r := range db.replicas {
+		if rerr := r.Close(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	db.replicas = nil
 	if db.db != nil {
-		err := db.db.Close()
+		if rerr := releaseShared(db.pool); rerr != nil && err == nil {
+			err = rerr
+		}
 		db.db = nil
-		return err
 	}
-	return nil
+	return err
 }
 
 // ConnStr returns a connection string for MySQL (MatrixOrigin).
@@ -39,20 +112,17 @@ func PyConnStr(host, port, user, passwd, dbname string) string {
 		user, passwd, host, port, dbname)
 }
 
-// OpenDB opens a database connection.
+// OpenDB opens a database connection, on the connection pool shared by
+// the databases opened with the same driver and connstr.
 func OpenDB(driver, connstr string) (*MoDB, error) {
-	var modb MoDB
-	var err error
-
 	switch driver {
 	case "", "mysql":
-		modb.db, err = sql.Open("mysql", connstr)
+		return openShared(DialectMySQL, connstr, nil)
 	case "sqlite", "dslite", "sqlite3", "dslite3":
-		modb.db, err = dslite.OpenDB(connstr)
+		return openShared(DialectSqlite, connstr, nil)
 	default:
 		return nil, fmt.Errorf("unsupported driver: %s", driver)
 	}
-	return &modb, err
 }
 
 // Exec executes a SQL statement.
@@ -82,38 +152,60 @@ func (db *MoDB) Begin() (*sql.Tx, error) {
 }
 
 // QueryVal queries a single value.
-func (db *MoDB) QueryVal(sql string, params ...any) (string, error) {
-	rows, err := db.db.Query(sql, params...)
+func (db *MoDB) QueryVal(query string, params ...any) (string, error) {
+	rows, err := db.db.Query(query, params...)
 	if err != nil {
 		return "", err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return "", nil
+		return "", rows.Err()
+	}
+	var ret sql.NullString
+	if err = rows.Scan(&ret); err != nil {
+		return "", err
 	}
-	var ret string
-	rows.Scan(&ret)
-	return ret, nil
+	return ret.String, nil
 }
 
-// QueryIVal queries a single integer value.:w
-func (db *MoDB) QueryIVal(sql string, params ...any) (int64, error) {
-	rows, err := db.db.Query(sql, params...)
+// QueryIVal queries a single integer value.
+func (db *MoDB) QueryIVal(query string, params ...any) (int64, error) {
+	rows, err := db.db.Query(query, params...)
 	if err != nil {
 		return 0, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return 0, nil
+		return 0, rows.Err()
+	}
+	var ret sql.NullInt64
+	if err = rows.Scan(&ret); err != nil {
+		return 0, err
+	}
+	return ret.Int64, nil
+}
+
+// scanStrings scans a row into strings, NULL is scanned as "".
+func scanStrings(rows *sql.Rows, ncol int) ([]string, error) {
+	row := make([]any, ncol)
+	ns := make([]sql.NullString, ncol)
+	for i := range ns {
+		row[i] = &ns[i]
+	}
+	if err := rows.Scan(row...); err != nil {
+		return nil, err
+	}
+	data := make([]string, ncol)
+	for i := range ns {
+		data[i] = ns[i].String
 	}
-	var ret int64
-	rows.Scan(&ret)
-	return ret, nil
+	return data, nil
 }
 
-// Query runs a query and returns the result as a 2D string array.
+// Query runs a query and returns the result as a 2D string array.  NULL
+// is returned as "", use QueryTyped to tell them apart.
 func (db *MoDB) Query(sql string, params ...any) ([][]string, error) {
 	rows, err := db.db.Query(sql, params...)
 	if err != nil {
@@ -131,16 +223,180 @@ func (db *MoDB) Query(sql string, params ...any) ([][]string, error) {
 	}
 
 	var ret [][]string
-	row := make([]any, ncol)
 	for rows.Next() {
-		data := make([]string, ncol)
-		for i := 0; i < ncol; i++ {
-			row[i] = &data[i]
+		data, err := scanStrings(rows, ncol)
+		if err != nil {
+			return nil, err
 		}
-		rows.Scan(row...)
 		ret = append(ret, data)
 	}
-	return ret, nil
+	return ret, rows.Err()
+}
+
+// Column describes a column of a query result.
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // database type name, for example BIGINT
+	Nullable bool   `json:"nullable"`
+}
+
+// Kinds of column values, by database type.
+const (
+	kindString = iota
+	kindInt
+	kindFloat
+	kindDecimal
+	kindBool
+	kindBytes
+)
+
+func columnKind(dbType string) int {
+	t := strings.ToUpper(dbType)
+	switch {
+	case strings.Contains(t, "BOOL"):
+		return kindBool
+	case strings.Contains(t, "INT") && !strings.Contains(t, "POINT") && !strings.Contains(t, "INTERVAL"):
+		return kindInt
+	case strings.Contains(t, "FLOAT") || strings.Contains(t, "DOUBLE") || strings.Contains(t, "REAL"):
+		return kindFloat
+	case strings.Contains(t, "DECIMAL") || strings.Contains(t, "NUMERIC"):
+		return kindDecimal
+	case strings.Contains(t, "BLOB") || strings.Contains(t, "BINARY") || t == "BIT":
+		return kindBytes
+	}
+	return kindString
+}
+
+// typedValue converts a scanned value to a value that marshals to the
+// proper json type.   Drivers may return numbers as text, for example
+// mysql without prepared statements, these are parsed by column kind.
+// Bytes marshal as base64.
+func typedValue(kind int, v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if kind == kindBytes {
+			return v
+		}
+		return typedValue(kind, string(v))
+	case string:
+		switch kind {
+		case kindInt:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+			if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return u
+			}
+		case kindFloat:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		case kindDecimal:
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				// keep the precision of the decimal.
+				return json.Number(v)
+			}
+		case kindBool:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		case kindBytes:
+			return []byte(v)
+		}
+		return v
+	case int64:
+		if kind == kindBool {
+			return v != 0
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// TypedRows reads typed rows of a query result one by one, so that a
+// large result is not loaded into memory.
+type TypedRows struct {
+	Columns []Column
+	rows    *sql.Rows
+	kinds   []int
+	ptrs    []any
+}
+
+// QueryRows runs a query and returns its rows, the caller must close it.
+func (db *MoDB) QueryRows(qry string, params ...any) (*TypedRows, error) {
+	rows, err := db.db.Query(qry, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	tr := &TypedRows{rows: rows}
+	tr.Columns = make([]Column, len(cts))
+	tr.kinds = make([]int, len(cts))
+	tr.ptrs = make([]any, len(cts))
+	for i, ct := range cts {
+		nullable, ok := ct.Nullable()
+		tr.Columns[i] = Column{Name: ct.Name(), Type: strings.ToUpper(ct.DatabaseTypeName()), Nullable: nullable || !ok}
+		tr.kinds[i] = columnKind(tr.Columns[i].Type)
+	}
+	return tr, nil
+}
+
+// Next returns the next row, values are typed, int64, float64, bool,
+// string, []byte or nil for NULL.  It returns nil after the last row.
+func (tr *TypedRows) Next() ([]any, error) {
+	if len(tr.Columns) == 0 || !tr.rows.Next() {
+		return nil, tr.rows.Err()
+	}
+	data := make([]any, len(tr.Columns))
+	for i := range data {
+		tr.ptrs[i] = &data[i]
+	}
+	if err := tr.rows.Scan(tr.ptrs...); err != nil {
+		return nil, err
+	}
+	for i := range data {
+		data[i] = typedValue(tr.kinds[i], data[i])
+	}
+	return data, nil
+}
+
+// Close closes the rows, it can be called before all rows are read.
+func (tr *TypedRows) Close() error {
+	return tr.rows.Close()
+}
+
+// QueryTyped runs a query and returns the columns and all the rows.
+func (db *MoDB) QueryTyped(qry string, params ...any) ([]Column, [][]any, error) {
+	tr, err := db.QueryRows(qry, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tr.Close()
+	if len(tr.Columns) == 0 {
+		return nil, nil, nil
+	}
+
+	var ret [][]any
+	for {
+		row, err := tr.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == nil {
+			return tr.Columns, ret, nil
+		}
+		ret = append(ret, row)
+	}
 }
 
 // QueryDump queries and returns the result pretty printed as a string.
@@ -168,14 +424,15 @@ func (db *MoDB) QueryDump(sql string, params ...any) (string, error) {
 	tw.SetCenterSeparator("|")
 
 	for rows.Next() {
-		row := make([]interface{}, ncol)
-		data := make([]string, ncol)
-		for i := 0; i < ncol; i++ {
-			row[i] = &data[i]
+		data, err := scanStrings(rows, ncol)
+		if err != nil {
+			return "", err
 		}
-		rows.Scan(row...)
 		tw.Append(data)
 	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
 
 	tw.Render()
 	return sb.String(), nil
@@ -209,7 +466,61 @@ func (db *MoDB) Token2Q(tokens []string, dict map[string]string) (string, []any)
 	return qry, params
 }
 
-// Template2Q expands a template string and binds parameters.
+func isNameRune(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// BindNamed replaces the :name placeholders of sql with ? and returns the
+// named values in order.   Quoted strings and identifiers, and :: casts,
+// are left alone.
+func BindNamed(sql string, named map[string]any) (string, []any, error) {
+	sb := &strings.Builder{}
+	var params []any
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// copy the quoted string, a doubled quote is an escaped quote.
+			j := i + 1
+			for ; j < len(sql); j++ {
+				if sql[j] == '\\' && c != '`' {
+					j++
+				} else if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						j++
+					} else {
+						break
+					}
+				}
+			}
+			j = min(j, len(sql)-1)
+			sb.WriteString(sql[i : j+1])
+			i = j
+		case c == ':' && i+1 < len(sql) && sql[i+1] == ':':
+			sb.WriteString("::")
+			i++
+		case c == ':' && i+1 < len(sql) && isNameRune(sql[i+1]):
+			j := i + 1
+			for j < len(sql) && isNameRune(sql[j]) {
+				j++
+			}
+			name := sql[i+1 : j]
+			v, ok := named[name]
+			if !ok {
+				return "", nil, fmt.Errorf("no value for named param :%s", name)
+			}
+			sb.WriteByte('?')
+			params = append(params, v)
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), params, nil
+}
+
+// Template2Q expands a template string with dict.  The values are written
+// in the query text as is, use TemplateQ to bind untrusted values.
 func (db *MoDB) Template2Q(tstr string, dict map[string]string) (string, error) {
 	t, err := template.New("query").Parse(tstr)
 	if err != nil {
@@ -224,6 +535,31 @@ func (db *MoDB) Template2Q(tstr string, dict map[string]string) (string, error)
 	return buf.String(), nil
 }
 
+// TemplateQ expands a template string with dict, {{param .k}} binds the
+// value of k as a :name parameter instead of writing it in the query.
+// It returns the query and its named parameters, see BindNamed.
+func (db *MoDB) TemplateQ(tstr string, dict map[string]string) (string, map[string]any, error) {
+	named := make(map[string]any)
+	funcs := template.FuncMap{
+		"param": func(v any) string {
+			name := fmt.Sprintf("tparam%d", len(named))
+			named[name] = v
+			return ":" + name
+		},
+	}
+	t, err := template.New("query").Funcs(funcs).Parse(tstr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	buf := &strings.Builder{}
+	err = t.Execute(buf, dict)
+	if err != nil {
+		return "", nil, err
+	}
+	return buf.String(), named, nil
+}
+
 func qSave(db *MoDB, sql string, params []any, f *os.File) error {
 	rows, err := db.db.Query(sql, params...)
 	if err != nil {
@@ -242,13 +578,11 @@ func qSave(db *MoDB, sql string, params []any, f *os.File) error {
 	}
 
 	for rows.Next() {
-		row := make([]interface{}, ncol)
-		data := make([]string, ncol)
-		for i := 0; i < ncol; i++ {
-			row[i] = &data[i]
+		data, err := scanStrings(rows, ncol)
+		if err != nil {
+			return err
 		}
-		rows.Scan(row...)
 		f.WriteString(strings.Join(data, ",") + "\n")
 	}
-	return nil
+	return rows.Err()
 }
@@ -1,7 +1,10 @@
 package dbagent
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/matrixorigin/monlp/agent"
@@ -102,3 +105,340 @@ func TestDBTemplate(t *testing.T) {
 	// go template does not allow arithmetic operations.
 	common.Assert(t, err != nil, "Expected error, got nil")
 }
+
+func TestDbQueryTyped(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+
+	db.MustExec("drop table if exists testtyped")
+	db.MustExec("create table testtyped (a int, b text, c double, d blob)")
+	db.MustExec("insert into testtyped values (1, 'x', 1.5, x'0102'), (2, '', null, null), (null, null, 2.0, null)")
+
+	qa := NewDbQuery()
+	err = qa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer qa.Close()
+
+	var output DbQueryOutput
+	err = qa.ExecuteOne([]byte(`{"data": "select a, b, c, d from testtyped order by a"}`), nil, func(data []byte, err error) bool {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		t.Logf("Query Result: %s", string(data))
+		err = json.Unmarshal(data, &output)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		return true
+	})
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	common.Assert(t, len(output.Columns) == 4 && output.Columns[0].Name == "a", "Unexpected columns %v", output.Columns)
+	common.Assert(t, output.Columns[2].Type == "DOUBLE", "Unexpected column type %v", output.Columns[2])
+	common.PanicAssert(t, len(output.Data) == 3, "Expected 3 rows, got %v", output.Data)
+	// NULL sorts first in sqlite.
+	common.Assert(t, output.Data[0][0] == nil && output.Data[0][1] == nil && output.Data[0][2] == 2.0, "Unexpected row %v", output.Data[0])
+	common.Assert(t, output.Data[1][0] == 1.0 && output.Data[1][1] == "x" && output.Data[1][3] == "AQI=", "Unexpected row %v", output.Data[1])
+	common.Assert(t, output.Data[2][1] == "" && output.Data[2][2] == nil, "Unexpected row %v", output.Data[2])
+
+	// typed rows can be written back.
+	db.MustExec("create table if not exists testtyped2 (a int, b text, c double, d blob)")
+	db.MustExec("delete from testtyped2")
+	wa := NewDbWriter()
+	err = wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testtyped2"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer wa.Close()
+	input, _ := json.Marshal(map[string]any{"data": output.Data})
+	err = wa.ExecuteOne(input, nil, func(data []byte, err error) bool { return true })
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	n, err := db.QueryIVal("select count(*) from testtyped2 where a is null and c = 2.0")
+	common.Assert(t, err == nil && n == 1, "Expected 1, got %v, %v", n, err)
+	n, err = db.QueryIVal("select typeof(a) = 'integer' from testtyped2 where a = 1")
+	common.Assert(t, err == nil && n == 1, "Expected integer, got %v, %v", n, err)
+}
+
+func TestBindNamed(t *testing.T) {
+	q, params, err := BindNamed("select * from t where k = :k and a > :a and b = ':k' and c::text = \"x:y\" and d = :k", map[string]any{"k": "key", "a": 1})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, q == "select * from t where k = ? and a > ? and b = ':k' and c::text = \"x:y\" and d = ?", "Unexpected query %s", q)
+	common.Assert(t, len(params) == 3 && params[0] == "key" && params[1] == 1 && params[2] == "key", "Unexpected params %v", params)
+
+	q, _, err = BindNamed("select 'it''s :a' || :b", map[string]any{"b": 2})
+	common.Assert(t, err == nil && q == "select 'it''s :a' || ?", "Unexpected query %s, %v", q, err)
+	_, _, err = BindNamed("select :missing", nil)
+	common.Assert(t, err != nil, "Expected missing param error")
+}
+
+func TestDbQueryParams(t *testing.T) {
+	qa := NewDbQuery()
+	err := qa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "qtemplate": "select b from {{.table}} where a = ?"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer qa.Close()
+
+	var outputs []DbQueryOutput
+	run := func(input string, dict map[string]string) error {
+		return qa.ExecuteOne([]byte(input), dict, func(data []byte, err error) bool {
+			common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+			var output DbQueryOutput
+			err = json.Unmarshal(data, &output)
+			common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+			outputs = append(outputs, output)
+			return true
+		})
+	}
+
+	for _, input := range []string{
+		`{"mode": "exec", "data": "drop table if exists testparams"}`,
+		`{"mode": "exec", "data": "create table testparams (a int, b text)"}`,
+		`{"mode": "exec", "data": "insert into testparams values (?, ?), (?, ?)", "params": [1, "a'; drop table testparams; --", 2, "b"]}`,
+	} {
+		err = run(input, nil)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	}
+
+	outputs = nil
+	err = run(`{"data": "select b from testparams where a = ?", "params": [1]}`, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	err = run(`{"data": "select a from testparams where b = :b", "named": {"b": "b"}}`, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	err = run(`{"mode": "template", "params": [2]}`, map[string]string{"table": "testparams"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.PanicAssert(t, len(outputs) == 3, "Expected 3 outputs, got %d", len(outputs))
+	common.Assert(t, outputs[0].Data[0][0] == "a'; drop table testparams; --", "Unexpected row %v", outputs[0].Data)
+	common.Assert(t, outputs[1].Data[0][0] == 2.0, "Unexpected row %v", outputs[1].Data)
+	common.Assert(t, outputs[2].Data[0][0] == "b", "Unexpected row %v", outputs[2].Data)
+
+	err = run(`{"data": "select 1", "params": [1], "named": {"a": 1}}`, nil)
+	common.Assert(t, err != nil, "Expected error of both params and named")
+
+	// template params are bound, not written in the query.
+	qa = NewDbQuery()
+	err = qa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "qtemplate": "select a from testparams where b = {{param .b}}"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer qa.Close()
+	outputs = nil
+	err = run(`{"mode": "template"}`, map[string]string{"b": "a' or 1=1 --"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.PanicAssert(t, len(outputs) == 1, "Expected 1 output, got %d", len(outputs))
+	common.Assert(t, len(outputs[0].Data) == 0, "Unexpected injected rows %v", outputs[0].Data)
+	err = run(`{"mode": "template"}`, map[string]string{"b": "b"})
+	common.Assert(t, err == nil && len(outputs) == 2 && len(outputs[1].Data) == 1, "Unexpected rows %v, %v", outputs, err)
+	err = run(`{"mode": "template", "params": [1]}`, map[string]string{"b": "b"})
+	common.Assert(t, err != nil, "Expected error of both params and template params")
+}
+
+func TestDbQueryStream(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	db.MustExec("drop table if exists teststream")
+	db.MustExec("create table teststream (a int)")
+	for i := 0; i < 10; i++ {
+		db.MustExec("insert into teststream values (?)", i)
+	}
+
+	qa := NewDbQuery()
+	err = qa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "batch": 3}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer qa.Close()
+
+	var sizes []int
+	err = qa.ExecuteOne([]byte(`{"data": "select a from teststream order by a"}`), nil, func(data []byte, err error) bool {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		var output DbQueryOutput
+		err = json.Unmarshal(data, &output)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, len(output.Columns) == 1, "Expected columns in every batch")
+		sizes = append(sizes, len(output.Data))
+		return true
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, fmt.Sprint(sizes) == "[3 3 3 1]", "Unexpected batches %v", sizes)
+
+	// one row per record, the consumer stops after two rows.
+	nrec := 0
+	err = qa.ExecuteOne([]byte(`{"data": "select a from teststream", "batch": 1}`), nil, func(data []byte, err error) bool {
+		nrec++
+		return nrec < 2
+	})
+	common.Assert(t, err == agent.ErrYieldDone, "Expected ErrYieldDone, got %v", err)
+	common.Assert(t, nrec == 2, "Expected 2 records, got %d", nrec)
+
+	// rows are closed, the table can be dropped.
+	err = db.Exec("drop table teststream")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+}
+
+func TestWriteSQL(t *testing.T) {
+	cols := []string{"id", "a", "b"}
+	keys := []string{"id"}
+	for _, tc := range []struct {
+		dialect, mode, sql string
+		binds              string
+	}{
+		{DialectMySQL, WriteInsert, "INSERT INTO t (`id`, `a`, `b`) VALUES (?, ?, ?)", "[0 1 2]"},
+		{DialectMySQL, WriteInsertIgnore, "INSERT IGNORE INTO t (`id`, `a`, `b`) VALUES (?, ?, ?)", "[0 1 2]"},
+		{DialectSqlite, WriteInsertIgnore, `INSERT OR IGNORE INTO t ("id", "a", "b") VALUES (?, ?, ?)`, "[0 1 2]"},
+		{DialectMySQL, WriteUpsert, "INSERT INTO t (`id`, `a`, `b`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `a` = VALUES(`a`), `b` = VALUES(`b`)", "[0 1 2]"},
+		{DialectSqlite, WriteUpsert, `INSERT INTO t ("id", "a", "b") VALUES (?, ?, ?) ON CONFLICT ("id") DO UPDATE SET "a" = excluded."a", "b" = excluded."b"`, "[0 1 2]"},
+		{DialectSqlite, WriteUpdate, `UPDATE t SET "a" = ?, "b" = ? WHERE "id" = ?`, "[1 2 0]"},
+		{DialectMySQL, WriteDelete, "DELETE FROM t WHERE `id` = ?", "[0]"},
+	} {
+		sql, binds, err := WriteSQL(tc.dialect, tc.mode, "t", 3, cols, keys)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, sql == tc.sql, "Unexpected %s %s sql: %s", tc.dialect, tc.mode, sql)
+		common.Assert(t, fmt.Sprint(binds) == tc.binds, "Unexpected %s %s binds: %v", tc.dialect, tc.mode, binds)
+	}
+
+	sql, _, err := WriteSQL(DialectMySQL, "", "t", 2, nil, nil)
+	common.Assert(t, err == nil && sql == "INSERT INTO t VALUES (?, ?)", "Unexpected sql %s, %v", sql, err)
+	_, _, err = WriteSQL(DialectMySQL, WriteDelete, "t", 3, cols, nil)
+	common.Assert(t, err != nil, "Expected delete without keys error")
+	_, _, err = WriteSQL(DialectMySQL, WriteUpdate, "t", 3, cols, []string{"c"})
+	common.Assert(t, err != nil, "Expected unknown key error")
+	_, _, err = WriteSQL(DialectMySQL, WriteUpsert, "t", 2, cols, keys)
+	common.Assert(t, err != nil, "Expected column count error")
+	_, _, err = WriteSQL(DialectSqlite, WriteInsert, "t", 2, []string{"a", `b") values (1, 2); drop table t; --`}, nil)
+	common.Assert(t, err != nil, "Expected invalid column error")
+}
+
+func TestDbWriterModes(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	db.MustExec("drop table if exists testmodes")
+	db.MustExec("create table testmodes (id int primary key, a text)")
+
+	write := func(mode, rows string) {
+		wa := NewDbWriter()
+		err := wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testmodes",
+			"write_mode": "` + mode + `", "columns": ["id", "a"], "keys": ["id"]}`))
+		common.PanicAssert(t, err == nil, "Config %s failed: %v", mode, err)
+		defer wa.Close()
+		err = wa.ExecuteOne([]byte(`{"data": `+rows+`}`), nil, func([]byte, error) bool { return true })
+		common.PanicAssert(t, err == nil, "Write %s failed: %v", mode, err)
+	}
+	dump := func() string {
+		rows, err := db.Query("select id, a from testmodes order by id")
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		return fmt.Sprint(rows)
+	}
+
+	write(WriteInsert, `[[1, "a"], [2, "b"]]`)
+	write(WriteInsertIgnore, `[[1, "x"], [3, "c"]]`)
+	common.Assert(t, dump() == "[[1 a] [2 b] [3 c]]", "Unexpected rows %s", dump())
+	write(WriteUpsert, `[[2, "B"], [4, "d"]]`)
+	common.Assert(t, dump() == "[[1 a] [2 B] [3 c] [4 d]]", "Unexpected rows %s", dump())
+	write(WriteUpdate, `[[3, "C"], [5, "e"]]`)
+	common.Assert(t, dump() == "[[1 a] [2 B] [3 C] [4 d]]", "Unexpected rows %s", dump())
+	write(WriteDelete, `[[1, ""], [4, ""]]`)
+	common.Assert(t, dump() == "[[2 B] [3 C]]", "Unexpected rows %s", dump())
+
+	wa := NewDbWriter()
+	err = wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testmodes", "write_mode": "delete", "columns": ["id", "a"]}`))
+	common.Assert(t, err != nil, "Expected delete without keys error")
+	wa.Close()
+}
+
+func TestDbWriterAutoSchema(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	db.MustExec("drop table if exists testauto")
+
+	wa := NewDbWriter()
+	err = wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testauto",
+		"auto_schema": true, "id_column": "id", "write_mode": "upsert", "keys": ["k"],
+		"schema": [{"name": "k", "type": "varchar(100)"}, {"name": "note", "type": "text"}],
+		"indexes": [{"columns": ["n"]}]}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer wa.Close()
+
+	write := func(rows string) {
+		err := wa.ExecuteOne([]byte(`{"data": `+rows+`}`), nil, func([]byte, error) bool { return true })
+		common.PanicAssert(t, err == nil, "Write %s failed: %v", rows, err)
+	}
+
+	write(`[{"k": "a", "n": 1, "x": 1.5}, {"k": "b", "n": 2}]`)
+	cols, err := db.TableColumns("testauto")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, fmt.Sprint(cols) == "[id k note n x]", "Unexpected columns %v", cols)
+	typ, _ := db.QueryVal("select type from pragma_table_info('testauto') where name = 'x'")
+	common.Assert(t, strings.EqualFold(typ, "real"), "Unexpected type %s", typ)
+	n, _ := db.QueryIVal("select count(*) from sqlite_master where type = 'index' and name = 'testauto_n_idx'")
+	common.Assert(t, n == 1, "Expected index testauto_n_idx")
+
+	// a new column is added, b is updated.
+	write(`[{"k": "b", "n": 3, "flag": true}]`)
+	cols, _ = db.TableColumns("testauto")
+	common.Assert(t, fmt.Sprint(cols) == "[id k note n x flag]", "Unexpected columns %v", cols)
+	rows, err := db.Query("select k, n, x, flag is null from testauto order by k")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, fmt.Sprint(rows) == "[[a 1 1.5 1] [b 3  0]]", "Unexpected rows %v", rows)
+
+	common.Assert(t, wa.ExecuteOne([]byte(`{"data": [{"k": "c"}, ["d"]]}`), nil, nil) != nil, "Expected mixed rows error")
+
+	// keywords are quoted, other keys are rejected.
+	write(`[{"k": "c", "order": 1, "key": "x", "desc": "y"}]`)
+	cols, _ = db.TableColumns("testauto")
+	common.Assert(t, fmt.Sprint(cols) == "[id k note n x flag desc key order]", "Unexpected columns %v", cols)
+	val, _ := db.QueryVal(`select "desc" from testauto where k = 'c'`)
+	common.Assert(t, val == "y", "Unexpected desc %s", val)
+	err = wa.ExecuteOne([]byte(`{"data": [{"k": "d", "n) values (1); drop table testauto; --": 1}]}`), nil, nil)
+	common.Assert(t, err != nil, "Expected invalid column error")
+	n, _ = db.QueryIVal("select count(*) from testauto")
+	common.Assert(t, n == 3, "Expected 3 rows, got %d", n)
+}
+
+func TestDbWriterBulk(t *testing.T) {
+	q := valuesSQL("INSERT INTO t (a, b) VALUES (?, ?) ON CONFLICT (a) DO NOTHING", 2, 3)
+	common.Assert(t, q == "INSERT INTO t (a, b) VALUES (?, ?), (?, ?), (?, ?) ON CONFLICT (a) DO NOTHING", "Unexpected sql %s", q)
+	buf := &bytes.Buffer{}
+	for _, v := range []any{nil, true, int64(3), `say "hi" \o/`} {
+		csvField(buf, v)
+		buf.WriteByte(',')
+	}
+	common.Assert(t, buf.String() == `\N,1,"3","say ""hi"" \\o/",`, "Unexpected csv %s", buf.String())
+
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+
+	newWriter := func(extra string) DbAgent {
+		db.MustExec("drop table if exists testbulk")
+		db.MustExec("create table testbulk (a int primary key, b text)")
+		wa := NewDbWriter()
+		err := wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testbulk", "columns": ["a", "b"]` + extra + `}`))
+		common.PanicAssert(t, err == nil, "Config failed: %v", err)
+		return wa
+	}
+	write := func(wa DbAgent, rows [][]any) error {
+		input, _ := json.Marshal(map[string]any{"data": rows})
+		return wa.ExecuteOne(input, nil, func([]byte, error) bool { return true })
+	}
+
+	// the second transaction fails on a duplicate key, and is rolled back.
+	wa := newWriter(`, "commit_rows": 2`)
+	err = write(wa, [][]any{{1, "a"}, {2, "b"}, {3, "c"}, {1, "dup"}})
+	common.Assert(t, err != nil, "Expected duplicate key error")
+	n, _ := db.QueryIVal("select count(*) from testbulk")
+	common.Assert(t, n == 2, "Expected 2 rows committed, got %d", n)
+	wa.Close()
+
+	for _, bulk := range []string{BulkValues, BulkLoad} {
+		wa = newWriter(`, "bulk": "` + bulk + `", "bulk_rows": 500, "commit_rows": 1000`)
+		var rows [][]any
+		for i := 0; i < 1234; i++ {
+			rows = append(rows, []any{i, fmt.Sprint("row ", i)})
+		}
+		rows[7][1] = nil
+		err = write(wa, rows)
+		common.Assert(t, err == nil, "Bulk %s failed: %v", bulk, err)
+		n, _ = db.QueryIVal("select count(*) from testbulk where b is not null")
+		common.Assert(t, n == 1233, "Expected 1233 rows, got %d", n)
+		wa.Close()
+	}
+
+	wa = NewDbWriter()
+	err = wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testbulk", "bulk": "load", "write_mode": "upsert"}`))
+	common.Assert(t, err != nil, "Expected bulk load upsert error")
+	wa.Close()
+}
@@ -2,7 +2,9 @@
 package dbagent
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 
 	"github.com/matrixorigin/monlp/agent"
 )
@@ -17,21 +19,76 @@ type DbAgent interface {
 type Config struct {
 	Driver    string `json:"driver"`    // database driver
 	ConnStr   string `json:"connstr"`   // connection string
+	Profile   string `json:"profile"`   // connection profile, used if no connstr
 	Table     string `json:"table"`     // table name
 	QTemplate string `json:"qtemplate"` // query template
+	Batch     int    `json:"batch"`     // rows per output record, 0 is all rows
+	// ReadReplica makes dbQuery run queries, not exec, on a read replica
+	// of the profile.
+	ReadReplica bool `json:"read_replica"`
+
+	// Write mode of dbWriter, see WriteSQL, and the columns of the input
+	// rows and the key columns it needs.
+	WriteMode string   `json:"write_mode"`
+	Columns   []string `json:"columns"`
+	Keys      []string `json:"keys"`
+
+	// AutoSchema makes dbWriter create the table if it does not exist,
+	// with the declared Schema, an IdColumn, Keys as the primary key and
+	// Indexes, and add new columns to it.
+	AutoSchema bool        `json:"auto_schema"`
+	Schema     []ColumnDef `json:"schema"`
+	IdColumn   string      `json:"id_column"`
+	Indexes    []IndexDef  `json:"indexes"`
+
+	// CommitRows is the number of rows dbWriter commits in a transaction,
+	// 0 commits each input record in one transaction.   Bulk is the bulk
+	// write method, BulkRows the rows of one multi-row VALUES.
+	CommitRows int    `json:"commit_rows"`
+	Bulk       string `json:"bulk"`
+	BulkRows   int    `json:"bulk_rows"`
+
+	// Dim is the vector dimension of a vector store, Metric its distance
+	// metric, l2 (default) or cosine.
+	Dim    int    `json:"dim"`
+	Metric string `json:"metric"`
+}
+
+// Open opens the database of the config, by Driver and ConnStr, or by the
+// connection Profile if ConnStr is not set.
+func (conf *Config) Open() (*MoDB, error) {
+	if conf.ConnStr == "" {
+		return OpenProfile(conf.Profile)
+	}
+	if conf.Profile != "" {
+		return nil, fmt.Errorf("both connstr and profile %s", conf.Profile)
+	}
+	return OpenDB(conf.Driver, conf.ConnStr)
 }
 
 // DbQueryInput is the input for db query.
 type DbQueryInput struct {
-	// mode: exec or query (defuault "" means query)
+	// mode: exec, query or template (defuault "" means query).  Template
+	// mode queries Config.QTemplate, expanded with dict, instead of Data.
+	// Use {{param .k}} in the template to bind a dict value rather than
+	// write it in the query.
 	Mode string `json:"mode"`
 	Data string `json:"data"`
+	// Params are bound to the ? placeholders, Named to the :name
+	// placeholders, of the statement.  Only one of them can be used.
+	Params []any          `json:"params,omitempty"`
+	Named  map[string]any `json:"named,omitempty"`
+	// Batch, if set, overrides Config.Batch.   A query with batch > 0
+	// streams its rows, in records of at most batch rows.
+	Batch int `json:"batch,omitempty"`
 }
 
-// DbQueryOutput is the output for db query, for now it is a 2D string array.
-// TODO: support typed columns
+// DbQueryOutput is the output for db query, the result columns and rows.
+// Values are json numbers, booleans, strings, null for NULL, or base64
+// strings for binary columns.
 type DbQueryOutput struct {
-	Data [][]string `json:"data"`
+	Columns []Column `json:"columns,omitempty"`
+	Data    [][]any  `json:"data"`
 }
 
 type dbQuery struct {
@@ -51,7 +108,7 @@ func (c *dbQuery) Config(bs []byte) error {
 		return err
 	}
 
-	c.db, err = OpenDB(c.conf.Driver, c.conf.ConnStr)
+	c.db, err = c.conf.Open()
 	if err != nil {
 		return err
 	}
@@ -69,29 +126,127 @@ func NewDbQuery() DbAgent {
 	return ca
 }
 
+// bind returns the statement and its parameters.
+func (c *dbQuery) bind(qry string, in *DbQueryInput) (string, []any, error) {
+	if len(in.Params) > 0 && len(in.Named) > 0 {
+		return "", nil, fmt.Errorf("both params and named params")
+	}
+
+	var params []any
+	var err error
+	if len(in.Named) > 0 {
+		qry, params, err = BindNamed(qry, in.Named)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		params = in.Params
+	}
+	for i, p := range params {
+		if params[i], err = paramValue(p); err != nil {
+			return "", nil, err
+		}
+	}
+	return qry, params, nil
+}
+
+// stream yields the rows of a query in batches, as they are read.  It
+// stops reading, and closes the rows, when yield returns false.
+func (c *dbQuery) stream(db *MoDB, qry string, params []any, batch int, yield func([]byte, error) bool) error {
+	rows, err := db.QueryRows(qry, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	output := DbQueryOutput{Columns: rows.Columns}
+	flush := func() bool {
+		bs, err := json.Marshal(output)
+		output.Data = output.Data[:0]
+		return yield(bs, err)
+	}
+
+	for {
+		row, err := rows.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		output.Data = append(output.Data, row)
+		if len(output.Data) == batch && !flush() {
+			return agent.ErrYieldDone
+		}
+	}
+	if len(output.Data) > 0 && !flush() {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
+
 func (c *dbQuery) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
 	if len(input) == 0 {
 		return nil
 	}
 
-	// unmarshal input to DbQueryInput
+	// unmarshal input to DbQueryInput, numbers are bound as is.
 	var dbQueryInput DbQueryInput
-	err := json.Unmarshal(input, &dbQueryInput)
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	err := dec.Decode(&dbQueryInput)
+	if err != nil {
+		return err
+	}
+
+	qry := dbQueryInput.Data
+	if dbQueryInput.Mode == "template" {
+		if c.conf.QTemplate == "" {
+			return fmt.Errorf("template mode without qtemplate")
+		}
+		var named map[string]any
+		qry, named, err = c.db.TemplateQ(c.conf.QTemplate, dict)
+		if err != nil {
+			return err
+		}
+		// values of {{param .k}} are bound as named params.
+		for k, v := range named {
+			if dbQueryInput.Named == nil {
+				dbQueryInput.Named = make(map[string]any)
+			}
+			if _, ok := dbQueryInput.Named[k]; ok {
+				return fmt.Errorf("named param %s is also a template param", k)
+			}
+			dbQueryInput.Named[k] = v
+		}
+	}
+	qry, params, err := c.bind(qry, &dbQueryInput)
 	if err != nil {
 		return err
 	}
 
-	var rows [][]string
+	batch := c.conf.Batch
+	if dbQueryInput.Batch > 0 {
+		batch = dbQueryInput.Batch
+	}
+
+	rdb := c.db
+	if c.conf.ReadReplica {
+		rdb = c.db.Replica()
+	}
+
+	var output DbQueryOutput
 	if dbQueryInput.Mode == "exec" {
-		err = c.db.Exec(dbQueryInput.Data)
+		err = c.db.Exec(qry, params...)
+	} else if batch > 0 {
+		return c.stream(rdb, qry, params, batch, yield)
 	} else {
-		rows, err = c.db.Query(dbQueryInput.Data)
+		output.Columns, output.Data, err = rdb.QueryTyped(qry, params...)
 	}
 	if err != nil {
 		return err
 	}
 
-	output := DbQueryOutput{Data: rows}
 	bs, err := json.Marshal(output)
 	if !yield(bs, err) {
 		return agent.ErrYieldDone
@@ -1,16 +1,22 @@
 package dbagent
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/matrixorigin/monlp/agent"
 )
 
-// DbWriterInput is the rows for db writer.
+// DbWriterInput is the rows for db writer.  A row is an array of values,
+// or an object mapping column names to values.  Values are strings, or
+// typed values as in DbQueryOutput, so that query output can be written
+// back.
 type DbWriterInput struct {
-	Data [][]string `json:"data"`
+	Data []any `json:"data"`
 }
 
 // DbWriterOutput is the output for db writer, number of rows.
@@ -18,12 +24,125 @@ type DbWriterOutput struct {
 	Data int `json:"data"` // number of rows written
 }
 
+// Write modes of dbWriter.
+const (
+	WriteInsert       = "insert"
+	WriteInsertIgnore = "insert_ignore" // skip rows with an existing key
+	WriteUpsert       = "upsert"        // insert, or update the row of the key
+	WriteUpdate       = "update"        // update the row of the key
+	WriteDelete       = "delete"        // delete the row of the key
+)
+
+// WriteSQL returns the statement writing a row of ncol columns to table,
+// in the dialect of the database, and the indexes of the row values to
+// bind to its parameters, in order.  Columns are the names of the row
+// values, they are required except to insert, keys are the key columns,
+// they are required to update or delete, and to upsert in sqlite.
+func WriteSQL(dialect, mode, table string, ncol int, columns, keys []string) (string, []int, error) {
+	if len(columns) > 0 && len(columns) != ncol {
+		return "", nil, fmt.Errorf("row has %d columns, expected %d", ncol, len(columns))
+	}
+	keyIdx := make([]int, len(keys))
+	for i, k := range keys {
+		keyIdx[i] = slices.Index(columns, k)
+		if keyIdx[i] < 0 {
+			return "", nil, fmt.Errorf("key %s is not a column", k)
+		}
+	}
+	// column names may come from json keys, quote them, and keys
+	// are columns.
+	qcols, err := quoteIdents(dialect, columns)
+	if err != nil {
+		return "", nil, err
+	}
+	qkeys := make([]string, len(keys))
+	for i, k := range keyIdx {
+		qkeys[i] = qcols[k]
+	}
+
+	var binds []int
+	for i := 0; i < ncol; i++ {
+		binds = append(binds, i)
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", ncol), ", ")
+	colList := ""
+	if len(columns) > 0 {
+		colList = " (" + strings.Join(qcols, ", ") + ")"
+	}
+	insert := fmt.Sprintf("%s%s VALUES (%s)", table, colList, marks)
+
+	// the non key columns, set by upsert and update.
+	var sets []string
+	var setIdx []int
+	for i, col := range columns {
+		if !slices.Contains(keys, col) {
+			sets = append(sets, qcols[i])
+			setIdx = append(setIdx, i)
+		}
+	}
+	where := make([]string, len(keys))
+	for i, k := range qkeys {
+		where[i] = k + " = ?"
+	}
+
+	switch mode {
+	case "", WriteInsert:
+		return "INSERT INTO " + insert, binds, nil
+	case WriteInsertIgnore:
+		if dialect == DialectSqlite {
+			return "INSERT OR IGNORE INTO " + insert, binds, nil
+		}
+		return "INSERT IGNORE INTO " + insert, binds, nil
+	case WriteUpsert:
+		if len(columns) == 0 {
+			return "", nil, fmt.Errorf("upsert needs columns")
+		}
+		if dialect == DialectSqlite {
+			if len(keys) == 0 {
+				return "", nil, fmt.Errorf("upsert needs keys")
+			}
+			sql := "INSERT INTO " + insert + " ON CONFLICT (" + strings.Join(qkeys, ", ") + ") DO "
+			if len(sets) == 0 {
+				return sql + "NOTHING", binds, nil
+			}
+			for i, col := range sets {
+				sets[i] = col + " = excluded." + col
+			}
+			return sql + "UPDATE SET " + strings.Join(sets, ", "), binds, nil
+		}
+		if len(sets) == 0 {
+			return "INSERT IGNORE INTO " + insert, binds, nil
+		}
+		for i, col := range sets {
+			sets[i] = col + " = VALUES(" + col + ")"
+		}
+		return "INSERT INTO " + insert + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", "), binds, nil
+	case WriteUpdate:
+		if len(keys) == 0 || len(sets) == 0 {
+			return "", nil, fmt.Errorf("update needs keys and other columns")
+		}
+		for i, col := range sets {
+			sets[i] = col + " = ?"
+		}
+		sql := "UPDATE " + table + " SET " + strings.Join(sets, ", ") + " WHERE " + strings.Join(where, " AND ")
+		return sql, append(setIdx, keyIdx...), nil
+	case WriteDelete:
+		if len(keys) == 0 {
+			return "", nil, fmt.Errorf("delete needs keys")
+		}
+		return "DELETE FROM " + table + " WHERE " + strings.Join(where, " AND "), keyIdx, nil
+	}
+	return "", nil, fmt.Errorf("unknown write mode: %s", mode)
+}
+
 type dbWriter struct {
 	agent.NilKVAgent
 	agent.SimpleExecuteAgent
 	conf Config
 	db   *MoDB
 	proj func([]string) []string
+	// tableCols are the known columns of the table, for auto schema.
+	tableCols []string
 }
 
 func (c *dbWriter) DB() *MoDB {
@@ -43,7 +162,7 @@ func (c *dbWriter) Config(bs []byte) error {
 		return err
 	}
 
-	c.db, err = OpenDB(c.conf.Driver, c.conf.ConnStr)
+	c.db, err = c.conf.Open()
 	if err != nil {
 		return err
 	}
@@ -51,13 +170,90 @@ func (c *dbWriter) Config(bs []byte) error {
 	if c.conf.Table == "" {
 		return fmt.Errorf("Table name is empty")
 	}
-	return nil
+	if c.conf.QTemplate != "" && c.conf.WriteMode != "" && c.conf.WriteMode != WriteInsert {
+		return fmt.Errorf("write mode %s with qtemplate", c.conf.WriteMode)
+	}
+	if err = checkBulk(c.conf.Bulk, c.conf.WriteMode, c.conf.QTemplate); err != nil {
+		return err
+	}
+	if c.conf.QTemplate == "" && len(c.conf.Columns) > 0 {
+		// check the mode, columns and keys.
+		_, _, err = WriteSQL(c.db.Dialect(), c.conf.WriteMode, c.conf.Table, len(c.conf.Columns), c.conf.Columns, c.conf.Keys)
+	}
+	return err
 }
 
 func (c *dbWriter) SetProj(proj func([]string) []string) {
 	c.proj = proj
 }
 
+// projAny applies a string projection to a typed row.
+func projAny(proj func([]string) []string, row []any) []any {
+	strs := make([]string, len(row))
+	for i, v := range row {
+		if v != nil {
+			strs[i] = fmt.Sprint(v)
+		}
+	}
+	strs = proj(strs)
+	ret := make([]any, len(strs))
+	for i, s := range strs {
+		ret[i] = s
+	}
+	return ret
+}
+
+// paramValue converts a json decoded value to a statement parameter.
+// Numbers are int64 if integral, objects and arrays are stored as json.
+func paramValue(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]any, []any:
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	}
+	return v, nil
+}
+
+// columns returns the declared column names of positional rows.
+func (c *dbWriter) columns() []string {
+	if len(c.conf.Columns) > 0 {
+		return c.conf.Columns
+	}
+	var cols []string
+	for _, def := range c.conf.Schema {
+		cols = append(cols, def.Name)
+	}
+	return cols
+}
+
+// toRows returns the positional rows of data, and their column names.
+func (c *dbWriter) toRows(data []any) ([][]any, []string, error) {
+	var rows [][]any
+	var objs []map[string]any
+	for _, d := range data {
+		switch d := d.(type) {
+		case []any:
+			rows = append(rows, d)
+		case map[string]any:
+			objs = append(objs, d)
+		default:
+			return nil, nil, fmt.Errorf("Row is not an array or object: %v", d)
+		}
+	}
+	if len(rows) > 0 && len(objs) > 0 {
+		return nil, nil, fmt.Errorf("Rows mix arrays and objects")
+	}
+	if len(objs) > 0 {
+		return objectRows(objs, c.columns())
+	}
+	return rows, c.columns(), nil
+}
+
 func (c *dbWriter) Close() error {
 	return c.db.Close()
 }
@@ -68,7 +264,9 @@ func (c *dbWriter) ExecuteOne(input []byte, dict map[string]string, yield func([
 	}
 
 	var dbWriterInput DbWriterInput
-	err := json.Unmarshal(input, &dbWriterInput)
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	err := dec.Decode(&dbWriterInput)
 	if err != nil {
 		return err
 	}
@@ -78,65 +276,58 @@ func (c *dbWriter) ExecuteOne(input []byte, dict map[string]string, yield func([
 		return nil
 	}
 
-	nCols := len(dbWriterInput.Data[0])
+	rows, columns, err := c.toRows(dbWriterInput.Data)
+	if err != nil {
+		return err
+	}
+	nCols := len(rows[0])
 	if nCols == 0 {
 		return fmt.Errorf("No columns")
 	}
+	if c.conf.AutoSchema {
+		if err = c.ensureTable(columns, rows); err != nil {
+			return err
+		}
+	}
 
 	var sql string
+	var binds []int
 	if c.conf.QTemplate != "" {
 		sql, err = c.db.Template2Q(c.conf.QTemplate, dict)
 		if err != nil {
 			return err
 		}
-	} else {
-		sql = fmt.Sprintf("INSERT INTO %s VALUES (", c.conf.Table)
 		for i := 0; i < nCols; i++ {
-			if i != 0 {
-				sql += ","
-			}
-			sql += "?"
+			binds = append(binds, i)
+		}
+	} else {
+		sql, binds, err = WriteSQL(c.db.Dialect(), c.conf.WriteMode, c.conf.Table, nCols, columns, c.conf.Keys)
+		if err != nil {
+			return err
 		}
-		sql += ")"
-	}
-
-	stmt, err := c.db.Prepare(sql)
-	if err != nil {
-		return err
 	}
-	defer stmt.Close()
-
-	buf := make([]interface{}, nCols)
 
-	// Insert all the rows in one transaction.
-	// Should we limit batch size?
-	tx, err := c.db.Begin()
-	if err != nil {
-		return err
-	}
-	// txStmt will be closed by tx.Commit()
-	txStmt := tx.Stmt(stmt)
-	for _, row := range dbWriterInput.Data {
+	// statement parameters of the rows.
+	params := make([][]any, len(rows))
+	for r, row := range rows {
 		if c.proj != nil {
-			row = c.proj(row)
+			row = projAny(c.proj, row)
 		}
 
 		if len(row) != nCols {
 			return fmt.Errorf("Row has %d columns, expected %d", len(row), nCols)
 		}
-		// copy row to buf, maybe I should quit and use gorm.
-		for i, v := range row {
-			buf[i] = v
+		// copy row to params, maybe I should quit and use gorm.
+		params[r] = make([]any, len(binds))
+		for i, col := range binds {
+			if params[r][i], err = paramValue(row[col]); err != nil {
+				return err
+			}
 		}
-
 		slog.Debug("DbWritter write row", "row", row)
-
-		_, err = txStmt.Exec(buf...)
-		if err != nil {
-			return err
-		}
 	}
-	if err = tx.Commit(); err != nil {
+
+	if err = c.writeRows(sql, columns, params); err != nil {
 		return err
 	}
 
@@ -0,0 +1,429 @@
+package dbagent
+
+//
+// Full-text search.   Text is tokenized by tokenizer.SimpleTokenizer, into
+// Latin words and CJK trigrams, and an index name is two tables,
+// name_docs, a row per document, and name_terms, the inverted index, a
+// row per term and document with the positions of the term.  Queries are
+// ranked by BM25.
+//
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/matrixorigin/monlp/textu/chunk"
+	"github.com/matrixorigin/monlp/textu/tokenizer"
+)
+
+const (
+	// BM25 parameters.
+	DefaultBM25K1 = 1.2
+	DefaultBM25B  = 0.75
+	// DefaultSearchLimit is the number of hits of a search.
+	DefaultSearchLimit = 10
+)
+
+// FTSDoc is a document to index.  Key identifies the document, a chunk
+// without key is keyed by its path and numbers.
+type FTSDoc struct {
+	Key string `json:"key"`
+	chunk.Chunk
+}
+
+// DocKey returns the key of the document.
+func (d *FTSDoc) DocKey() string {
+	if d.Key != "" {
+		return d.Key
+	}
+	return fmt.Sprintf("%s#%d.%d", d.Path, d.Num1, d.Num2)
+}
+
+// FTSHit is a document found by a search.
+type FTSHit struct {
+	Key   string  `json:"key"`
+	Title string  `json:"title"`
+	Score float64 `json:"score"`
+}
+
+// FTSTerm is a term of a text, at token position Pos and byte Offset.
+// Prefix is set for a CJK run of less than 3 runes in a query, which
+// matches the trigrams starting with it.
+type FTSTerm struct {
+	Term   string
+	Pos    int
+	Offset int
+	Prefix bool
+}
+
+func isCJKTerm(term string) bool {
+	r, _ := utf8.DecodeRuneInString(term)
+	return r >= 0x7FF
+}
+
+// FTSTerms tokenizes text into terms.
+func FTSTerms(text string) []FTSTerm {
+	tknz, err := tokenizer.NewSimpleTokenizer([]byte(text))
+	if err != nil {
+		return nil
+	}
+	var terms []FTSTerm
+	for tk := range tknz.Tokenize() {
+		n := int(tk.TokenBytes[0])
+		terms = append(terms, FTSTerm{Term: string(tk.TokenBytes[1 : 1+n]), Pos: int(tk.TokenPos), Offset: int(tk.BytePos)})
+	}
+	return terms
+}
+
+// queryTerms tokenizes a query.  The tokenizer ends a CJK run with its
+// last 2 runes and last rune, which are not in the index where the run
+// goes on in a document, so they are dropped.   A run of less than 3
+// runes matches by prefix.
+func queryTerms(text string) []FTSTerm {
+	var terms []FTSTerm
+	all := FTSTerms(text)
+	for i, t := range all {
+		if isCJKTerm(t.Term) && utf8.RuneCountInString(t.Term) < 3 {
+			if i > 0 {
+				// the tail of the run of the previous term.
+				prev := all[i-1]
+				_, sz := utf8.DecodeRuneInString(prev.Term)
+				if isCJKTerm(prev.Term) && prev.Offset+sz == t.Offset {
+					continue
+				}
+			}
+			t.Prefix = true
+		}
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// FTSQuery is a parsed query, the terms ranked and the phrases, "quoted"
+// in the query, a document must contain.
+type FTSQuery struct {
+	Terms   []FTSTerm
+	Phrases [][]FTSTerm
+}
+
+// ParseFTSQuery parses a query, words and "quoted phrases".
+func ParseFTSQuery(q string) FTSQuery {
+	var fq FTSQuery
+	parts := strings.Split(q, `"`)
+	for i, part := range parts {
+		terms := queryTerms(part)
+		if len(terms) == 0 {
+			continue
+		}
+		fq.Terms = append(fq.Terms, terms...)
+		// odd parts are quoted, an unclosed quote is not a phrase.
+		if i%2 == 1 && i < len(parts)-1 {
+			fq.Phrases = append(fq.Phrases, terms)
+		}
+	}
+	return fq
+}
+
+// docId returns the id of a document key, the first 63 bits of its
+// sha256.
+func docId(key string) int64 {
+	h := sha256.Sum256([]byte(key))
+	return int64(binary.BigEndian.Uint64(h[:8]) >> 1)
+}
+
+// FTSIndex is a full-text index in a database.
+type FTSIndex struct {
+	db   *MoDB
+	Name string
+	K1   float64
+	B    float64
+}
+
+// OpenFTSIndex creates the tables of index name if they do not exist.
+func OpenFTSIndex(db *MoDB, name string) (*FTSIndex, error) {
+	ix := &FTSIndex{db: db, Name: name, K1: DefaultBM25K1, B: DefaultBM25B}
+	stmts := []string{
+		`create table if not exists ` + ix.docsTable() + ` (
+			doc_id bigint not null primary key,
+			doc_key varchar(1000) not null,
+			title text not null,
+			len int not null)`,
+		`create table if not exists ` + ix.termsTable() + ` (
+			term varchar(64) not null,
+			doc_id bigint not null,
+			tf int not null,
+			positions text not null,
+			primary key (term, doc_id))`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return ix, nil
+}
+
+func (ix *FTSIndex) docsTable() string {
+	return ix.Name + "_docs"
+}
+
+func (ix *FTSIndex) termsTable() string {
+	return ix.Name + "_terms"
+}
+
+// Add indexes docs in one transaction, a document already in the index
+// is replaced.
+func (ix *FTSIndex) Add(docs []FTSDoc) (err error) {
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, d := range docs {
+		id := docId(d.DocKey())
+		if _, err = tx.Exec("delete from "+ix.termsTable()+" where doc_id = ?", id); err != nil {
+			return err
+		}
+		if _, err = tx.Exec("delete from "+ix.docsTable()+" where doc_id = ?", id); err != nil {
+			return err
+		}
+
+		terms := FTSTerms(d.Text)
+		_, err = tx.Exec("insert into "+ix.docsTable()+" (doc_id, doc_key, title, len) values (?, ?, ?, ?)",
+			id, d.DocKey(), d.Title, len(terms))
+		if err != nil {
+			return err
+		}
+
+		postings := make(map[string][]string)
+		var order []string
+		for _, t := range terms {
+			if postings[t.Term] == nil {
+				order = append(order, t.Term)
+			}
+			postings[t.Term] = append(postings[t.Term], strconv.Itoa(t.Pos))
+		}
+		for _, term := range order {
+			pos := postings[term]
+			_, err = tx.Exec("insert into "+ix.termsTable()+" (term, doc_id, tf, positions) values (?, ?, ?, ?)",
+				term, id, len(pos), strings.Join(pos, ","))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes a document from the index.
+func (ix *FTSIndex) Delete(key string) error {
+	id := docId(key)
+	if err := ix.db.Exec("delete from "+ix.termsTable()+" where doc_id = ?", id); err != nil {
+		return err
+	}
+	return ix.db.Exec("delete from "+ix.docsTable()+" where doc_id = ?", id)
+}
+
+// posting is a row of the inverted index.
+type posting struct {
+	term      string
+	doc       int64
+	tf        int
+	positions []int
+}
+
+// postings reads the postings of a query term.
+func (ix *FTSIndex) postings(t FTSTerm) ([]posting, error) {
+	qry := "select term, doc_id, tf, positions from " + ix.termsTable() + " where term = ?"
+	arg := t.Term
+	if t.Prefix {
+		qry = "select term, doc_id, tf, positions from " + ix.termsTable() + " where term like ?"
+		arg = t.Term + "%"
+	}
+	rows, err := ix.db.Query(qry, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]posting, 0, len(rows))
+	for _, row := range rows {
+		p := posting{term: row[0]}
+		p.doc, _ = strconv.ParseInt(row[1], 10, 64)
+		p.tf, _ = strconv.Atoi(row[2])
+		for _, s := range strings.Split(row[3], ",") {
+			if n, err := strconv.Atoi(s); err == nil {
+				p.positions = append(p.positions, n)
+			}
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// docs reads the length, key and title of docs.
+func (ix *FTSIndex) docs(docs map[int64]bool) (map[int64]int, map[int64][2]string, error) {
+	var ids []any
+	for doc := range docs {
+		ids = append(ids, doc)
+	}
+	lens := make(map[int64]int)
+	keys := make(map[int64][2]string)
+	for start := 0; start < len(ids); start += DefaultBulkRows {
+		end := min(start+DefaultBulkRows, len(ids))
+		rows, err := ix.db.Query("select doc_id, doc_key, title, len from "+ix.docsTable()+
+			" where doc_id in ("+strings.TrimSuffix(strings.Repeat("?, ", end-start), ", ")+")", ids[start:end]...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range rows {
+			doc, _ := strconv.ParseInt(row[0], 10, 64)
+			keys[doc] = [2]string{row[1], row[2]}
+			lens[doc], _ = strconv.Atoi(row[3])
+		}
+	}
+	return lens, keys, nil
+}
+
+// hasPhrase checks that a document has the phrase, each term at its
+// offset in the phrase from a start position.  pos are the positions of
+// the phrase terms in the document.
+func hasPhrase(phrase []FTSTerm, pos [][]int) bool {
+	for _, start := range pos[0] {
+		found := true
+		for i := 1; i < len(phrase) && found; i++ {
+			_, found = slices.BinarySearch(pos[i], start+phrase[i].Pos-phrase[0].Pos)
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns at most limit documents matching query, best first.  A
+// document must have a term of the query, and all its phrases, and is
+// ranked by BM25 of the terms.
+func (ix *FTSIndex) Search(query string, limit int) ([]FTSHit, error) {
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	fq := ParseFTSQuery(query)
+	if len(fq.Terms) == 0 {
+		return nil, nil
+	}
+
+	// postings of each distinct query term, and the positions in each
+	// document.
+	byTerm := make(map[FTSTerm][]posting)
+	positions := make(map[FTSTerm]map[int64][]int)
+	for _, t := range fq.Terms {
+		key := FTSTerm{Term: t.Term, Prefix: t.Prefix}
+		if _, ok := byTerm[key]; ok {
+			continue
+		}
+		ps, err := ix.postings(t)
+		if err != nil {
+			return nil, err
+		}
+		byTerm[key] = ps
+		docPos := make(map[int64][]int)
+		for _, p := range ps {
+			docPos[p.doc] = append(docPos[p.doc], p.positions...)
+		}
+		for doc := range docPos {
+			slices.Sort(docPos[doc])
+		}
+		positions[key] = docPos
+	}
+
+	ndoc, err := ix.db.QueryIVal("select count(*) from " + ix.docsTable())
+	if err != nil || ndoc == 0 {
+		return nil, err
+	}
+	avg, err := ix.db.QueryVal("select avg(len) from " + ix.docsTable())
+	if err != nil {
+		return nil, err
+	}
+	avgLen, _ := strconv.ParseFloat(avg, 64)
+	if avgLen <= 0 {
+		avgLen = 1
+	}
+
+	// tf of each term of the index matched, per document.
+	tfs := make(map[string]map[int64]int)
+	for _, ps := range byTerm {
+		for _, p := range ps {
+			if tfs[p.term] == nil {
+				tfs[p.term] = make(map[int64]int)
+			}
+			tfs[p.term][p.doc] = p.tf
+		}
+	}
+
+	// documents with all the phrases.
+	candidates := make(map[int64]bool)
+	for _, m := range tfs {
+		for doc := range m {
+			candidates[doc] = true
+		}
+	}
+	for doc := range candidates {
+		for _, phrase := range fq.Phrases {
+			pos := make([][]int, len(phrase))
+			for i, t := range phrase {
+				pos[i] = positions[FTSTerm{Term: t.Term, Prefix: t.Prefix}][doc]
+			}
+			if len(pos[0]) == 0 || !hasPhrase(phrase, pos) {
+				delete(candidates, doc)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	lens, keys, err := ix.docs(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []FTSHit
+	for doc := range candidates {
+		var score float64
+		for _, m := range tfs {
+			tf, ok := m[doc]
+			if !ok {
+				continue
+			}
+			df := float64(len(m))
+			idf := math.Log(1 + (float64(ndoc)-df+0.5)/(df+0.5))
+			norm := ix.K1 * (1 - ix.B + ix.B*float64(lens[doc])/avgLen)
+			score += idf * float64(tf) * (ix.K1 + 1) / (float64(tf) + norm)
+		}
+		hits = append(hits, FTSHit{Key: keys[doc][0], Title: keys[doc][1], Score: score})
+	}
+	slices.SortFunc(hits, func(a, b FTSHit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
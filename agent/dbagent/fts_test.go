@@ -0,0 +1,108 @@
+package dbagent
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+	"github.com/matrixorigin/monlp/textu/chunk"
+)
+
+func TestFTSQuery(t *testing.T) {
+	fq := ParseFTSQuery(`quick "brown fox" 相见时难别亦难 "别亦" 难`)
+	var terms []string
+	for _, term := range fq.Terms {
+		s := term.Term
+		if term.Prefix {
+			s += "*"
+		}
+		terms = append(terms, s)
+	}
+	// the tails of the CJK runs are dropped, short runs are prefixes.
+	common.Assert(t, fmt.Sprint(terms) == "[quick brown fox 相见时 见时难 时难别 难别亦 别亦难 别亦* 难*]", "Unexpected terms %v", terms)
+	common.Assert(t, len(fq.Phrases) == 2 && len(fq.Phrases[0]) == 2 && fq.Phrases[1][0].Term == "别亦", "Unexpected phrases %v", fq.Phrases)
+
+	fq = ParseFTSQuery(`"unclosed phrase`)
+	common.Assert(t, len(fq.Terms) == 2 && len(fq.Phrases) == 0, "Unexpected query %v", fq)
+
+	// a long word ending in multi byte runes is truncated at a rune.
+	terms = nil
+	for _, term := range FTSTerms("aaaaaaaaaaaaaaaaaaaaaaééééééééééé rest") {
+		terms = append(terms, term.Term)
+	}
+	common.Assert(t, fmt.Sprint(terms) == "[aaaaaaaaaaaaaaaaaaaaaa rest]", "Unexpected terms %v", terms)
+}
+
+func TestFTS(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	db.MustExec("drop table if exists testfts_docs")
+	db.MustExec("drop table if exists testfts_terms")
+
+	ia := NewFTSIndexer()
+	err = ia.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testfts"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer ia.Close()
+
+	docs := []FTSDoc{
+		{Key: "fox", Chunk: chunk.Chunk{Title: "Fox", Text: "The quick brown fox jumps over the lazy dog."}},
+		{Key: "dogs", Chunk: chunk.Chunk{Title: "Dogs", Text: "A dog, a brown dog and a lazy dog.  Dogs are not foxes."}},
+		{Key: "brown", Chunk: chunk.Chunk{Title: "Brown", Text: "Fox brown, not brown fox."}},
+		{Chunk: chunk.Chunk{Path: "无题", Num1: 1, Num2: 2, Title: "无题", Text: "相见时难别亦难，东风无力百花残。"}},
+	}
+	input, _ := json.Marshal(map[string]any{"data": docs})
+	err = ia.ExecuteOne(input, nil, func(data []byte, err error) bool {
+		common.Assert(t, err == nil && string(data) == `{"data":4}`, "Unexpected output %s, %v", data, err)
+		return true
+	})
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	ix, err := OpenFTSIndex(db, "testfts")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	keys := func(q string) string {
+		hits, err := ix.Search(q, 0)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		var ks []string
+		for _, h := range hits {
+			ks = append(ks, h.Key)
+		}
+		return fmt.Sprint(ks)
+	}
+
+	// dogs has dog 3 times, fox is shorter than dogs.
+	common.Assert(t, keys("dog") == "[dogs fox]", "Unexpected hits %s", keys("dog"))
+	common.Assert(t, keys("Brown") == "[brown fox dogs]", "Unexpected hits %s", keys("Brown"))
+	common.Assert(t, keys(`"brown fox"`) == "[brown fox]", "Unexpected hits %s", keys(`"brown fox"`))
+	common.Assert(t, keys(`"fox brown"`) == "[brown]", "Unexpected hits %s", keys(`"fox brown"`))
+	common.Assert(t, keys(`"lazy fox"`) == "[]", "Unexpected hits %s", keys(`"lazy fox"`))
+	common.Assert(t, keys("cat") == "[]", "Unexpected hits %s", keys("cat"))
+	common.Assert(t, keys(`"别亦难"`) == "[无题#1.2]", "Unexpected hits %s", keys(`"别亦难"`))
+	common.Assert(t, keys(`"东风"`) == "[无题#1.2]", "Unexpected hits %s", keys(`"东风"`))
+	common.Assert(t, keys(`"难东风"`) == "[]", "Unexpected hits %s", keys(`"难东风"`))
+
+	// replace and delete documents.
+	err = ix.Add([]FTSDoc{{Key: "fox", Chunk: chunk.Chunk{Title: "Fox", Text: "A red fox."}}})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, keys("dog") == "[dogs]", "Unexpected hits %s", keys("dog"))
+	err = ix.Delete("dogs")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, keys("dog") == "[]", "Unexpected hits %s", keys("dog"))
+
+	sa := NewFTSSearch()
+	err = sa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testfts"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer sa.Close()
+	err = sa.ExecuteOne([]byte(`{"data": "red fox", "limit": 1}`), nil, func(data []byte, err error) bool {
+		var output FTSSearchOutput
+		err = json.Unmarshal(data, &output)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, len(output.Data) == 1 && output.Data[0].Key == "fox" && output.Data[0].Title == "Fox", "Unexpected output %s", data)
+		return true
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	db.MustExec("drop table testfts_docs")
+	db.MustExec("drop table testfts_terms")
+}
@@ -0,0 +1,122 @@
+package dbagent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/matrixorigin/monlp/agent"
+)
+
+// FTSIndexerInput is the input of the full-text indexer, documents or
+// chunks.
+type FTSIndexerInput struct {
+	Data []FTSDoc `json:"data"`
+}
+
+// FTSIndexerOutput is the output of the full-text indexer, the number
+// of documents indexed.
+type FTSIndexerOutput struct {
+	Data int `json:"data"`
+}
+
+// FTSSearchInput is the input of the full-text search, a query of words
+// and "quoted phrases", and the number of hits.
+type FTSSearchInput struct {
+	Data  string `json:"data"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// FTSSearchOutput is the output of the full-text search, best first.
+type FTSSearchOutput struct {
+	Data []FTSHit `json:"data"`
+}
+
+// ftsAgent indexes documents, or searches them, in the full-text index
+// named by Config.Table.
+type ftsAgent struct {
+	agent.NilKVAgent
+	agent.SimpleExecuteAgent
+	conf   Config
+	db     *MoDB
+	ix     *FTSIndex
+	search bool
+}
+
+func (c *ftsAgent) DB() *MoDB {
+	return c.db
+}
+
+func (c *ftsAgent) Config(bs []byte) error {
+	err := json.Unmarshal(bs, &c.conf)
+	if err != nil {
+		return err
+	}
+	if c.conf.Table == "" {
+		return fmt.Errorf("Table name is empty")
+	}
+
+	c.db, err = c.conf.Open()
+	if err != nil {
+		return err
+	}
+	c.ix, err = OpenFTSIndex(c.db, c.conf.Table)
+	return err
+}
+
+func (c *ftsAgent) Close() error {
+	return c.db.Close()
+}
+
+// NewFTSIndexer creates an agent that adds documents to a full-text index.
+func NewFTSIndexer() DbAgent {
+	ca := &ftsAgent{}
+	ca.Self = ca
+	return ca
+}
+
+// NewFTSSearch creates an agent that searches a full-text index.
+func NewFTSSearch() DbAgent {
+	ca := &ftsAgent{search: true}
+	ca.Self = ca
+	return ca
+}
+
+func (c *ftsAgent) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var bs []byte
+	if c.search {
+		var in FTSSearchInput
+		if err := json.Unmarshal(input, &in); err != nil {
+			return err
+		}
+		hits, err := c.ix.Search(in.Data, in.Limit)
+		if err != nil {
+			return err
+		}
+		bs, err = json.Marshal(FTSSearchOutput{Data: hits})
+		if err != nil {
+			return err
+		}
+	} else {
+		var in FTSIndexerInput
+		if err := json.Unmarshal(input, &in); err != nil {
+			return err
+		}
+		if err := c.ix.Add(in.Data); err != nil {
+			return err
+		}
+		var err error
+		bs, err = json.Marshal(FTSIndexerOutput{Data: len(in.Data)})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !yield(bs, nil) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
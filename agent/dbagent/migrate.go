@@ -0,0 +1,270 @@
+package dbagent
+
+//
+// Versioned schema migrations.  Migrations are embedded SQL scripts,
+// migrations/NNNN_name.up.sql and NNNN_name.down.sql, a script for one
+// dialect, NNNN_name.up.sqlite.sql for example, is used instead of the
+// generic one.   Applied versions are recorded in monlp_migrations.
+//
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MigrationTable = "monlp_migrations"
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// ErrSchemaOutdated is returned by Check if migrations are not applied.
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// Migration is a schema version, with the scripts to migrate to it from
+// the previous version and back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations loads the migrations in the root of fsys for dialect,
+// ordered by version.
+func LoadMigrations(fsys fs.FS, dialect string) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	// a dialect script wins over the generic one.
+	specific := make(map[string]bool)
+	for _, fn := range files {
+		parts := strings.Split(strings.TrimSuffix(fn, ".sql"), ".")
+		if len(parts) < 2 || len(parts) > 3 || (parts[1] != "up" && parts[1] != "down") {
+			return nil, fmt.Errorf("invalid migration file name: %s", fn)
+		}
+		if len(parts) == 3 && parts[2] != dialect {
+			continue
+		}
+		key := parts[0] + "." + parts[1]
+		if len(parts) == 2 && specific[key] {
+			continue
+		}
+
+		num, name, _ := strings.Cut(parts[0], "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version: %s", fn)
+		}
+		bs, err := fs.ReadFile(fsys, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, name)
+		}
+		if parts[1] == "up" {
+			m.Up = string(bs)
+		} else {
+			m.Down = string(bs)
+		}
+		if len(parts) == 3 {
+			specific[key] = true
+		}
+	}
+
+	var ret []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		ret = append(ret, *m)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret, nil
+}
+
+// splitStatements splits a script into statements, at a ; ending a line.
+// Lines starting with -- are comments.
+func splitStatements(script string) []string {
+	var stmts []string
+	var buf strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(buf.String()), ";"))
+			buf.Reset()
+		}
+	}
+	if s := strings.TrimSpace(buf.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+// Migrator migrates the schema of a database.
+type Migrator struct {
+	db         *MoDB
+	Migrations []Migration
+}
+
+// NewMigrator creates a migrator of the embedded migrations, and the
+// migration table if it does not exist.
+func NewMigrator(db *MoDB) (*Migrator, error) {
+	sub, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	ms, err := LoadMigrations(sub, db.Dialect())
+	if err != nil {
+		return nil, err
+	}
+	return NewMigratorWith(db, ms)
+}
+
+// NewMigratorWith creates a migrator of migrations.
+func NewMigratorWith(db *MoDB, ms []Migration) (*Migrator, error) {
+	err := db.Exec(`create table if not exists ` + MigrationTable + ` (
+		version int not null primary key,
+		name varchar(200) not null,
+		applied_ms bigint not null)`)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, Migrations: ms}, nil
+}
+
+// Latest returns the latest version of the migrations.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Version returns the version of the database schema, 0 if no migration
+// is applied.
+func (m *Migrator) Version() (int, error) {
+	v, err := m.db.QueryIVal("select max(version) from " + MigrationTable)
+	return int(v), err
+}
+
+// run executes script and the statement recording it in the migration
+// table, in one transaction on sqlite.   MySQL commits DDL implicitly,
+// the statements are executed one by one.
+func (m *Migrator) run(version int, script string, record string, args ...any) (err error) {
+	exec := func(stmt string, args ...any) error {
+		return m.db.Exec(stmt, args...)
+	}
+	if m.db.Dialect() == DialectSqlite {
+		tx, berr := m.db.Begin()
+		if berr != nil {
+			return berr
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+		exec = func(stmt string, args ...any) error {
+			_, err := tx.Exec(stmt, args...)
+			return err
+		}
+	}
+
+	for _, stmt := range splitStatements(script) {
+		if err = exec(stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return exec(record, args...)
+}
+
+// Up applies the migrations up to version target, 0 is the latest, and
+// returns the migrations applied.
+func (m *Migrator) Up(target int) ([]Migration, error) {
+	if target <= 0 {
+		target = m.Latest()
+	}
+	cur, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, mg := range m.Migrations {
+		if mg.Version <= cur || mg.Version > target {
+			continue
+		}
+		err = m.run(mg.Version, mg.Up, "insert into "+MigrationTable+" (version, name, applied_ms) values (?, ?, ?)",
+			mg.Version, mg.Name, time.Now().UnixMilli())
+		if err != nil {
+			return applied, err
+		}
+		slog.Info("Migrated up", "version", mg.Version, "name", mg.Name)
+		applied = append(applied, mg)
+	}
+	return applied, nil
+}
+
+// Down reverts the migrations after version target, and returns the
+// migrations reverted.
+func (m *Migrator) Down(target int) ([]Migration, error) {
+	cur, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		mg := m.Migrations[i]
+		if mg.Version > cur || mg.Version <= target {
+			continue
+		}
+		if mg.Down == "" {
+			return reverted, fmt.Errorf("migration %d has no down script", mg.Version)
+		}
+		err = m.run(mg.Version, mg.Down, "delete from "+MigrationTable+" where version = ?", mg.Version)
+		if err != nil {
+			return reverted, err
+		}
+		slog.Info("Migrated down", "version", mg.Version, "name", mg.Name)
+		reverted = append(reverted, mg)
+	}
+	return reverted, nil
+}
+
+// Check checks that the database schema is the latest version.
+func (m *Migrator) Check() error {
+	cur, err := m.Version()
+	if err != nil {
+		return err
+	}
+	switch {
+	case cur < m.Latest():
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaOutdated, cur, m.Latest())
+	case cur > m.Latest():
+		return fmt.Errorf("database schema version %d is newer than %d", cur, m.Latest())
+	}
+	return nil
+}
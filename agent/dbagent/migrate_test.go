@@ -0,0 +1,131 @@
+package dbagent
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_a.up.sql":        {Data: []byte("create table a (x int);")},
+		"0001_a.up.sqlite.sql": {Data: []byte("create table a (x integer);")},
+		"0001_a.down.sql":      {Data: []byte("drop table a;")},
+		"0002_b.up.mysql.sql":  {Data: []byte("create table b (x int);")},
+		"0002_b.up.sqlite.sql": {Data: []byte("create table b (x integer);")},
+		"0010_c.up.sql":        {Data: []byte("-- comment\ncreate table c (x int);\ncreate index c_x on c (x);")},
+		"0011_d.down.sql":      {Data: []byte("drop table d;")},
+	}
+	_, err := LoadMigrations(fsys, DialectSqlite)
+	common.Assert(t, err != nil, "Expected no up script error")
+	delete(fsys, "0011_d.down.sql")
+
+	ms, err := LoadMigrations(fsys, DialectSqlite)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.PanicAssert(t, len(ms) == 3, "Expected 3 migrations, got %d", len(ms))
+	common.Assert(t, ms[0].Up == "create table a (x integer);" && ms[0].Down == "drop table a;", "Unexpected migration %v", ms[0])
+	common.Assert(t, ms[1].Name == "b" && ms[1].Up == "create table b (x integer);", "Unexpected migration %v", ms[1])
+	common.Assert(t, ms[2].Version == 10, "Unexpected migration %v", ms[2])
+
+	ms, err = LoadMigrations(fsys, DialectMySQL)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, ms[0].Up == "create table a (x int);", "Unexpected migration %v", ms[0])
+
+	stmts := splitStatements(ms[2].Up)
+	common.Assert(t, len(stmts) == 2 && stmts[1] == "create index c_x on c (x)", "Unexpected statements %q", stmts)
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	for _, table := range []string{MigrationTable, "wikipages", "wikilinks", "testnovel", SessionTable, ExchangeTable} {
+		db.MustExec("drop table if exists " + table)
+	}
+
+	m, err := NewMigrator(db)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, m.Latest() >= 2, "Expected at least 2 migrations, got %d", m.Latest())
+	err = m.Check()
+	common.Assert(t, errors.Is(err, ErrSchemaOutdated), "Expected outdated schema, got %v", err)
+
+	applied, err := m.Up(1)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(applied) == 1 && applied[0].Name == "wiki", "Unexpected migrations %v", applied)
+	err = db.Exec("insert into wikipages (title, k, content) values ('A', 'a', 'text')")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	_, err = m.Up(0)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	err = m.Check()
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	cols, _ := db.TableColumns("testnovel")
+	common.Assert(t, len(cols) == 6, "Unexpected testnovel columns %v", cols)
+
+	// nothing more to apply.
+	applied, err = m.Up(0)
+	common.Assert(t, err == nil && len(applied) == 0, "Unexpected migrations %v, %v", applied, err)
+
+	reverted, err := m.Down(0)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(reverted) == m.Latest() && reverted[0].Version == m.Latest(), "Unexpected migrations %v", reverted)
+	v, _ := m.Version()
+	common.Assert(t, v == 0, "Expected version 0, got %d", v)
+	cols, _ = db.TableColumns("wikipages")
+	common.Assert(t, len(cols) == 0, "Expected wikipages dropped")
+}
+
+func TestMigrateExisting(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	for _, table := range []string{MigrationTable, "wikipages", "wikilinks", "testnovel", SessionTable, ExchangeTable} {
+		db.MustExec("drop table if exists " + table)
+	}
+
+	// the tables as made by wikiexplorer .load before migrations.
+	db.MustExec(`create table wikipages (` + db.IdDef("id") + `, title varchar(1000) not null,
+		k varchar(1000) not null, redirect varchar(1000), content text)`)
+	db.MustExec("create index wikipages_k_idx on wikipages (k)")
+	db.MustExec("create index wikipages_title_idx on wikipages (title)")
+	db.MustExec("create table wikilinks (tfrom varchar(1000) not null, idfrom int not null, tto varchar(1000) not null, offset int)")
+	db.MustExec("create index wikilinks_kfrom_idx on wikilinks (tfrom, tto)")
+	db.MustExec("insert into wikipages (title, k, content) values ('A', 'a', 'text')")
+
+	m, err := NewMigrator(db)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	_, err = m.Up(0)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	err = m.Check()
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	// the data is kept, and the missing index is created.
+	n, _ := db.QueryIVal("select count(*) from wikipages")
+	common.Assert(t, n == 1, "Expected 1 wikipage, got %d", n)
+	n, _ = db.QueryIVal("select count(*) from sqlite_master where type = 'index' and name = 'wikilinks_kto_idx'")
+	common.Assert(t, n == 1, "Expected index wikilinks_kto_idx")
+
+	_, err = m.Down(0)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+}
+
+func TestMigrateRollback(t *testing.T) {
+	db, err := OpenDB("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+
+	// a failed migration leaves neither its tables nor its version.
+	m, err := NewMigratorWith(db, []Migration{
+		{Version: 1, Name: "bad", Up: "create table mbad (x int);\ncreate tabel mbad2 (y int);", Down: "drop table mbad;"},
+	})
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	_, err = m.Up(0)
+	common.Assert(t, err != nil, "Expected migration error")
+	v, _ := m.Version()
+	common.Assert(t, v == 0, "Expected version 0, got %d", v)
+	cols, _ := db.TableColumns("mbad")
+	common.Assert(t, len(cols) == 0, "Expected mbad rolled back, got %v", cols)
+}
@@ -0,0 +1,2 @@
+drop table if exists wikilinks;
+drop table if exists wikipages;
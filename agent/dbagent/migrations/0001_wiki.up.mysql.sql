@@ -0,0 +1,20 @@
+-- wikipedia pages, loaded by wikiexplorer .load, and links between them.
+-- Tables made by .load before migrations have the same schema, they
+-- are adopted as is.  MySQL has no create index if not exists, the
+-- indexes are declared with the tables.
+create table if not exists wikipages (
+    id int auto_increment not null primary key,
+    title varchar(1000) not null,
+    k varchar(1000) not null,
+    redirect varchar(1000),
+    content text,
+    index wikipages_k_idx (k),
+    index wikipages_title_idx (title));
+
+create table if not exists wikilinks (
+    tfrom varchar(1000) not null,
+    idfrom int not null,
+    tto varchar(1000) not null,
+    offset int,
+    index wikilinks_kfrom_idx (tfrom, tto),
+    index wikilinks_kto_idx (tto, tfrom));
@@ -0,0 +1,19 @@
+-- wikipedia pages, loaded by wikiexplorer .load, and links between them.
+-- Tables made by .load before migrations have the same schema, they
+-- are adopted as is.
+create table if not exists wikipages (
+    id integer primary key autoincrement,
+    title varchar(1000) not null,
+    k varchar(1000) not null,
+    redirect varchar(1000),
+    content text);
+create index if not exists wikipages_k_idx on wikipages (k);
+create index if not exists wikipages_title_idx on wikipages (title);
+
+create table if not exists wikilinks (
+    tfrom varchar(1000) not null,
+    idfrom int not null,
+    tto varchar(1000) not null,
+    offset int);
+create index if not exists wikilinks_kfrom_idx on wikilinks (tfrom, tto);
+create index if not exists wikilinks_kto_idx on wikilinks (tto, tfrom);
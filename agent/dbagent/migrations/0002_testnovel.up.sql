@@ -0,0 +1,10 @@
+-- chunks of novels, loaded by novelChunker and dbWriter, a testnovel
+-- table made by the test before migrations is adopted.
+create table if not exists testnovel (
+    url varchar(200) not null,
+    num1 int not null,
+    num2 int not null,
+    path text,
+    title text,
+    content text,
+    primary key (url, num1, num2));
@@ -0,0 +1,2 @@
+drop table if exists mochat_exchanges;
+drop table if exists mochat_sessions;
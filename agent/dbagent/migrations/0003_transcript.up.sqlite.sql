@@ -0,0 +1,20 @@
+-- conversation transcripts of mochat and WikiX, a session and its
+-- exchanges, see dbagent.TranscriptStore.
+create table if not exists mochat_sessions (
+    id varchar(64) not null primary key,
+    app varchar(64) not null,
+    model varchar(200) not null,
+    started_ms bigint not null);
+
+create table if not exists mochat_exchanges (
+    id integer primary key autoincrement,
+    session_id varchar(64) not null,
+    seq int not null,
+    question text not null,
+    messages text not null,
+    tool_calls text not null,
+    answer text not null,
+    model varchar(200) not null,
+    latency_ms bigint not null,
+    created_ms bigint not null,
+    err text not null);
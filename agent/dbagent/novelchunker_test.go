@@ -16,18 +16,21 @@ func TestLoadNovelChunker(t *testing.T) {
 	config, err := json.Marshal(conf)
 	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
 
+	// testnovel is created by migrations, clear it.
+	db, err := OpenDB("", connstr)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	m, err := NewMigrator(db)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	_, err = m.Up(0)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	db.Close()
+
 	qa := NewDbQuery()
 	err = qa.Config(config)
 	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
 
 	stra := agent.NewStringArrayAgent([]string{
-		`{"data": "drop table if exists testnovel"}`,
-		`{"data": "create table testnovel (` +
-			`url varchar(200) not null, ` +
-			`num1 int not null, ` +
-			`num2 int not null, ` +
-			`path text, title text, content text, ` +
-			`primary key (url, num1, num2))"}`,
+		`{"mode": "exec", "data": "delete from testnovel"}`,
 	})
 
 	var pipe agent.AgentPipe
@@ -0,0 +1,458 @@
+package dbagent
+
+//
+// Connection profiles.  A profile names a database connection, with its
+// pool settings, timeouts, TLS and read replicas.  Profiles are read from
+// dbprofiles.json in the working directory, for example,
+//
+//	{
+//	  "default": "mo",
+//	  "profiles": {
+//	    "mo": {"host": "localhost", "port": "6001", "user": "dump",
+//	           "password": "111", "database": "monlp", "max_open_conns": 8,
+//	           "replicas": [{"host": "replica1"}]},
+//	    "local": {"driver": "sqlite", "dsn": "/tmp/monlp.db"}
+//	  }
+//	}
+//
+// and env MOCHAT_DB_* overrides the selected one.   Databases opened with
+// the same connection share one connection pool.
+//
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fengttt/gcl/dslite"
+	"github.com/go-sql-driver/mysql"
+	"github.com/matrixorigin/monlp/common"
+)
+
+const (
+	// ProfileFileName is the profile file in the working directory.
+	ProfileFileName = "dbprofiles.json"
+	// DefaultProfileName is the profile used if none is selected.
+	DefaultProfileName = "default"
+	// ProfileEnv selects a profile, MOCHAT_DB_* env below override it.
+	ProfileEnv = "MOCHAT_DB_PROFILE"
+)
+
+// Profile is a database connection profile.  Durations are strings like
+// "30s", zero values keep the defaults of database/sql and the driver.
+type Profile struct {
+	Driver string `json:"driver"` // mysql (default) or sqlite
+	// DSN is the connection string, or the file of sqlite.   For mysql,
+	// the fields below override the ones in it.
+	DSN      string `json:"dsn"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+
+	MaxOpenConns    int    `json:"max_open_conns"`
+	MaxIdleConns    int    `json:"max_idle_conns"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time"`
+
+	ConnectTimeout string `json:"connect_timeout"`
+	ReadTimeout    string `json:"read_timeout"`
+	WriteTimeout   string `json:"write_timeout"`
+
+	// TLS is true, skip-verify or preferred, or custom to verify the
+	// server with TLSCA and present the client cert TLSCert and TLSKey.
+	TLS     string `json:"tls"`
+	TLSCA   string `json:"tls_ca"`
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+
+	// Replicas are read only copies of the database.  A replica inherits
+	// the fields it does not set from the profile, the DSN and host only
+	// if it sets neither.
+	Replicas []Profile `json:"replicas"`
+}
+
+// ProfileFile is the content of a profile file.
+type ProfileFile struct {
+	Default  string             `json:"default"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// DefaultProfile is the profile used without a profile file, the local
+// MatrixOne, or monlp.db in the working directory if the sql driver is
+// sqlite.
+func DefaultProfile() Profile {
+	switch common.SqlDriver {
+	case "sqlite", "sqlite3", "dslite", "dslite3":
+		return Profile{Driver: DialectSqlite, DSN: path.Join(common.WorkingDir, "monlp.db")}
+	default:
+		return Profile{Driver: DialectMySQL, Host: "localhost", Port: "6001", User: "dump", Password: "111", Database: "monlp"}
+	}
+}
+
+// LoadProfiles reads a profile file.
+func LoadProfiles(fn string) (*ProfileFile, error) {
+	bs, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var pf ProfileFile
+	if err = json.Unmarshal(bs, &pf); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return &pf, nil
+}
+
+// Profile returns the profile name, "" selects the profile of flag
+// -dbprofile or env MOCHAT_DB_PROFILE, or the default of the file.  Env
+// overrides are applied.  A missing default profile is DefaultProfile.
+func (pf *ProfileFile) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = common.DbProfile
+	}
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" && pf != nil {
+		name = pf.Default
+	}
+	if name == "" {
+		name = DefaultProfileName
+	}
+
+	var p Profile
+	var ok bool
+	if pf != nil {
+		p, ok = pf.Profiles[name]
+	}
+	if !ok {
+		if name != DefaultProfileName {
+			return p, fmt.Errorf("unknown db profile: %s", name)
+		}
+		p = DefaultProfile()
+	}
+	p.applyEnv()
+	return p, nil
+}
+
+// LoadProfile returns the profile name of the profile file in the working
+// directory, see ProfileFile.Profile.
+func LoadProfile(name string) (Profile, error) {
+	pf, err := LoadProfiles(path.Join(common.WorkingDir, ProfileFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		pf, err = nil, nil
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+	return pf.Profile(name)
+}
+
+// applyEnv overrides the profile with env MOCHAT_DB_*.
+func (p *Profile) applyEnv() {
+	for env, field := range map[string]*string{
+		"MOCHAT_DB_DSN":      &p.DSN,
+		"MOCHAT_DB_HOST":     &p.Host,
+		"MOCHAT_DB_PORT":     &p.Port,
+		"MOCHAT_DB_USER":     &p.User,
+		"MOCHAT_DB_PASSWORD": &p.Password,
+		"MOCHAT_DB_NAME":     &p.Database,
+		"MOCHAT_DB_TLS":      &p.TLS,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("MOCHAT_DB_MAX_OPEN_CONNS")); err == nil {
+		p.MaxOpenConns = n
+	}
+}
+
+// replica returns the profile of replica r, with the unset fields of p.
+func (p *Profile) replica(r Profile) Profile {
+	inherit := func(f *string, v string) {
+		if *f == "" {
+			*f = v
+		}
+	}
+	inherit(&r.Driver, p.Driver)
+	if r.DSN == "" && r.Host == "" {
+		// a replica of sqlite is the same file.
+		inherit(&r.DSN, p.DSN)
+		inherit(&r.Host, p.Host)
+	}
+	inherit(&r.Port, p.Port)
+	inherit(&r.User, p.User)
+	inherit(&r.Password, p.Password)
+	inherit(&r.Database, p.Database)
+	inherit(&r.ConnMaxLifetime, p.ConnMaxLifetime)
+	inherit(&r.ConnMaxIdleTime, p.ConnMaxIdleTime)
+	inherit(&r.ConnectTimeout, p.ConnectTimeout)
+	inherit(&r.ReadTimeout, p.ReadTimeout)
+	inherit(&r.WriteTimeout, p.WriteTimeout)
+	inherit(&r.TLS, p.TLS)
+	inherit(&r.TLSCA, p.TLSCA)
+	inherit(&r.TLSCert, p.TLSCert)
+	inherit(&r.TLSKey, p.TLSKey)
+	if r.MaxOpenConns == 0 {
+		r.MaxOpenConns = p.MaxOpenConns
+	}
+	if r.MaxIdleConns == 0 {
+		r.MaxIdleConns = p.MaxIdleConns
+	}
+	r.Replicas = nil
+	return r
+}
+
+func parseDuration(name, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// tlsConfigName registers the custom TLS config of the profile with the
+// mysql driver, and returns its name.
+func (p *Profile) tlsConfigName() (string, error) {
+	if p.TLS != "custom" {
+		return p.TLS, nil
+	}
+	conf := &tls.Config{}
+	if p.TLSCA != "" {
+		pem, err := os.ReadFile(p.TLSCA)
+		if err != nil {
+			return "", err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificate in %s", p.TLSCA)
+		}
+	}
+	if p.TLSCert != "" || p.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLSCert, p.TLSKey)
+		if err != nil {
+			return "", err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	h := sha256.Sum256([]byte(p.TLSCA + "\x00" + p.TLSCert + "\x00" + p.TLSKey))
+	name := "monlp_" + hex.EncodeToString(h[:8])
+	if err := mysql.RegisterTLSConfig(name, conf); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ConnStr returns the driver and the connection string of the profile.
+func (p *Profile) ConnStr() (string, string, error) {
+	switch p.Driver {
+	case "sqlite", "dslite", "sqlite3", "dslite3":
+		if p.DSN == "" {
+			return "", "", fmt.Errorf("sqlite profile without dsn")
+		}
+		return DialectSqlite, p.DSN, nil
+	case "", "mysql":
+	default:
+		return "", "", fmt.Errorf("unsupported driver: %s", p.Driver)
+	}
+
+	cfg := mysql.NewConfig()
+	if p.DSN != "" {
+		var err error
+		if cfg, err = mysql.ParseDSN(p.DSN); err != nil {
+			return "", "", err
+		}
+	}
+	if p.Host != "" || p.Port != "" {
+		host, port := p.Host, p.Port
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = "3306"
+		}
+		cfg.Net = "tcp"
+		cfg.Addr = host + ":" + port
+	}
+	if p.User != "" {
+		cfg.User = p.User
+	}
+	if p.Password != "" {
+		cfg.Passwd = p.Password
+	}
+	if p.Database != "" {
+		cfg.DBName = p.Database
+	}
+
+	var err error
+	for _, d := range []struct {
+		name string
+		s    string
+		d    *time.Duration
+	}{
+		{"connect_timeout", p.ConnectTimeout, &cfg.Timeout},
+		{"read_timeout", p.ReadTimeout, &cfg.ReadTimeout},
+		{"write_timeout", p.WriteTimeout, &cfg.WriteTimeout},
+	} {
+		if d.s == "" {
+			continue
+		}
+		if *d.d, err = parseDuration(d.name, d.s); err != nil {
+			return "", "", err
+		}
+	}
+	if p.TLS != "" {
+		if cfg.TLSConfig, err = p.tlsConfigName(); err != nil {
+			return "", "", err
+		}
+	}
+	return DialectMySQL, cfg.FormatDSN(), nil
+}
+
+// Open opens the database of the profile, and its replicas.
+func (p *Profile) Open() (*MoDB, error) {
+	driver, connstr, err := p.ConnStr()
+	if err != nil {
+		return nil, err
+	}
+	lifetime, err := parseDuration("conn_max_lifetime", p.ConnMaxLifetime)
+	if err != nil {
+		return nil, err
+	}
+	idleTime, err := parseDuration("conn_max_idle_time", p.ConnMaxIdleTime)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openShared(driver, connstr, &poolConf{
+		maxOpen:  p.MaxOpenConns,
+		maxIdle:  p.MaxIdleConns,
+		lifetime: lifetime,
+		idleTime: idleTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range p.Replicas {
+		rp := p.replica(r)
+		rdb, err := rp.Open()
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("replica: %w", err)
+		}
+		db.replicas = append(db.replicas, rdb)
+	}
+	return db, nil
+}
+
+// OpenProfile opens the database of profile name, see LoadProfile.
+func OpenProfile(name string) (*MoDB, error) {
+	p, err := LoadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Open()
+}
+
+// poolConf is the pool settings of a profile, zero keeps the default.
+type poolConf struct {
+	maxOpen  int
+	maxIdle  int
+	lifetime time.Duration
+	idleTime time.Duration
+}
+
+func (pc *poolConf) setup(sdb *sql.DB) {
+	if pc.maxOpen > 0 {
+		sdb.SetMaxOpenConns(pc.maxOpen)
+	}
+	if pc.maxIdle > 0 {
+		sdb.SetMaxIdleConns(pc.maxIdle)
+	}
+	if pc.lifetime > 0 {
+		sdb.SetConnMaxLifetime(pc.lifetime)
+	}
+	if pc.idleTime > 0 {
+		sdb.SetConnMaxIdleTime(pc.idleTime)
+	}
+}
+
+// sharedPool is a connection pool shared by the databases opened with the
+// same connection.
+type sharedPool struct {
+	db   *sql.DB
+	conf poolConf
+	refs int
+}
+
+var pools = struct {
+	sync.Mutex
+	m map[string]*sharedPool
+}{m: make(map[string]*sharedPool)}
+
+// openShared opens a database on the shared pool of driver and connstr.
+// conf configures a new pool, the settings of the first open win, other
+// settings are ignored with a warning.
+func openShared(dialect, connstr string, conf *poolConf) (*MoDB, error) {
+	key := dialect + "\x00" + connstr
+	pools.Lock()
+	defer pools.Unlock()
+
+	sp := pools.m[key]
+	if sp == nil {
+		var sdb *sql.DB
+		var err error
+		if dialect == DialectSqlite {
+			sdb, err = dslite.OpenDB(connstr)
+		} else {
+			sdb, err = sql.Open("mysql", connstr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sp = &sharedPool{db: sdb}
+		if conf != nil {
+			sp.conf = *conf
+			conf.setup(sdb)
+		}
+		pools.m[key] = sp
+	} else if conf != nil && *conf != sp.conf {
+		slog.Warn("Database pool is shared, pool settings ignored", "dialect", dialect, "settings", fmt.Sprintf("%+v", *conf), "pool", fmt.Sprintf("%+v", sp.conf))
+	}
+	sp.refs++
+	return &MoDB{db: sp.db, dialect: dialect, pool: key}, nil
+}
+
+// releaseShared releases a reference to a shared pool, and closes it when
+// it is not used.
+func releaseShared(key string) error {
+	pools.Lock()
+	defer pools.Unlock()
+
+	sp := pools.m[key]
+	if sp == nil {
+		return nil
+	}
+	sp.refs--
+	if sp.refs > 0 {
+		return nil
+	}
+	delete(pools.m, key)
+	return sp.db.Close()
+}
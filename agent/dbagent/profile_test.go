@@ -0,0 +1,94 @@
+package dbagent
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestProfile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), ProfileFileName)
+	err := os.WriteFile(fn, []byte(`{
+		"default": "mo",
+		"profiles": {
+			"mo": {"host": "mohost", "port": "6001", "user": "dump", "password": "111",
+				"database": "monlp", "connect_timeout": "5s", "read_timeout": "30s",
+				"tls": "skip-verify", "replicas": [{"host": "replica1"}]},
+			"lite": {"driver": "sqlite", "dsn": "monlp.db", "max_open_conns": 1,
+				"replicas": [{}]},
+			"bad": {"host": "h", "read_timeout": "soon"},
+			"mo2": {"host": "mohost", "replicas": [{}]}
+		}}`), 0644)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	pf, err := LoadProfiles(fn)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	p, err := pf.Profile("")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	driver, connstr, err := p.ConnStr()
+	common.Assert(t, err == nil && driver == DialectMySQL, "Unexpected %s, %v", driver, err)
+	common.Assert(t, strings.HasPrefix(connstr, "dump:111@tcp(mohost:6001)/monlp?"), "Unexpected connstr %s", connstr)
+	for _, s := range []string{"timeout=5s", "readTimeout=30s", "tls=skip-verify"} {
+		common.Assert(t, strings.Contains(connstr, s), "Expected %s in %s", s, connstr)
+	}
+
+	r := p.replica(p.Replicas[0])
+	_, connstr, _ = r.ConnStr()
+	common.Assert(t, strings.HasPrefix(connstr, "dump:111@tcp(replica1:6001)/monlp?"), "Unexpected replica connstr %s", connstr)
+
+	// a replica without host and dsn is on the host of the profile.
+	p, _ = pf.Profile("mo2")
+	r = p.replica(p.Replicas[0])
+	_, connstr, _ = r.ConnStr()
+	common.Assert(t, strings.HasPrefix(connstr, "tcp(mohost:3306)/"), "Unexpected replica connstr %s", connstr)
+
+	// env overrides the profile.
+	t.Setenv("MOCHAT_DB_USER", "root")
+	t.Setenv("MOCHAT_DB_PORT", "3306")
+	p, _ = pf.Profile("mo")
+	_, connstr, _ = p.ConnStr()
+	common.Assert(t, strings.HasPrefix(connstr, "root:111@tcp(mohost:3306)/monlp?"), "Unexpected connstr %s", connstr)
+
+	_, err = pf.Profile("nosuch")
+	common.Assert(t, err != nil, "Expected unknown profile error")
+	p, _ = pf.Profile("bad")
+	_, err = p.Open()
+	common.Assert(t, err != nil, "Expected invalid duration error")
+
+	t.Setenv(ProfileEnv, "lite")
+	t.Setenv("MOCHAT_DB_DSN", filepath.Join(t.TempDir(), "lite.db"))
+	p, err = pf.Profile("")
+	common.PanicAssert(t, err == nil && p.Driver == "sqlite", "Unexpected profile %v, %v", p, err)
+	db, err := p.Open()
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(db.replicas) == 1 && db.Replica() == db.replicas[0], "Expected one replica")
+
+	// databases of the same connection share the pool.
+	db2, err := OpenDB("sqlite", p.DSN)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, db2.db == db.db && db.replicas[0].db == db.db, "Expected a shared pool")
+	common.Assert(t, db.db.Stats().MaxOpenConnections == 1, "Expected max open conns 1")
+	// other pool settings are ignored, with a warning.
+	logs := &strings.Builder{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	p2 := Profile{Driver: "sqlite", DSN: p.DSN, MaxOpenConns: 4}
+	db3, err := p2.Open()
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, db3.db == db.db && db.db.Stats().MaxOpenConnections == 1, "Expected the pool settings kept")
+	common.Assert(t, strings.Contains(logs.String(), "pool settings ignored"), "Expected a warning, got %s", logs.String())
+	db3.Close()
+
+	db.MustExec("create table if not exists testprofile (x int)")
+	db.Close()
+	db.Close()
+	n, err := db2.QueryIVal("select count(*) from testprofile")
+	common.Assert(t, err == nil && n == 0, "Expected the pool open, got %d, %v", n, err)
+	db2.MustExec("drop table testprofile")
+	db2.Close()
+	common.Assert(t, pools.m["sqlite\x00"+p.DSN] == nil, "Expected the pool closed")
+}
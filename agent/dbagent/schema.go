@@ -0,0 +1,274 @@
+package dbagent
+
+//
+// Table schema of dbWriter: create a table from declared or inferred
+// columns, and add columns to it as new ones show up.
+//
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ColumnDef declares a column of a table, an empty type is inferred from
+// the values written to it.
+type ColumnDef struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null"`
+}
+
+// IndexDef declares an index of a table, the default name is
+// table_col1_col2_idx.
+type IndexDef struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+// TableColumns returns the columns of table, or nil if it does not exist.
+func (db *MoDB) TableColumns(table string) ([]string, error) {
+	var rows [][]string
+	var err error
+	if db.dialect == DialectSqlite {
+		rows, err = db.Query("select name from pragma_table_info(?)", table)
+	} else {
+		rows, err = db.Query(`select column_name from information_schema.columns
+			where table_schema = database() and table_name = ? order by ordinal_position`, table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cols []string
+	for _, row := range rows {
+		cols = append(cols, row[0])
+	}
+	return cols, nil
+}
+
+// SQLType infers the column type of a json decoded value.  Key columns
+// are varchar, as MySQL cannot index text.
+func (db *MoDB) SQLType(v any, key bool) string {
+	sqlite := db.dialect == DialectSqlite
+	switch v := v.(type) {
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			if sqlite {
+				return "integer"
+			}
+			return "bigint"
+		}
+		if sqlite {
+			return "real"
+		}
+		return "double"
+	case float64:
+		if sqlite {
+			return "real"
+		}
+		return "double"
+	case int, int32, int64:
+		if sqlite {
+			return "integer"
+		}
+		return "bigint"
+	case bool:
+		return "boolean"
+	case map[string]any, []any:
+		if !sqlite {
+			return "json"
+		}
+	}
+	if key && !sqlite {
+		return "varchar(255)"
+	}
+	return "text"
+}
+
+// CreateTable creates table if it does not exist, with an auto increment
+// id column if id is not empty, keys as the primary key, and indexes.
+func (db *MoDB) CreateTable(table, id string, cols []ColumnDef, keys []string, indexes []IndexDef) error {
+	var defs []string
+	if id != "" {
+		qid, err := QuoteIdent(db.dialect, id)
+		if err != nil {
+			return err
+		}
+		defs = append(defs, db.IdDef(qid))
+	}
+	for _, col := range cols {
+		name, err := QuoteIdent(db.dialect, col.Name)
+		if err != nil {
+			return err
+		}
+		def := name + " " + col.Type
+		if col.NotNull || slices.Contains(keys, col.Name) {
+			def += " not null"
+		}
+		defs = append(defs, def)
+	}
+	if len(keys) > 0 {
+		if id != "" {
+			// the id is the primary key, keys are unique.
+			indexes = append([]IndexDef{{Columns: keys, Unique: true}}, indexes...)
+		} else {
+			qkeys, err := quoteIdents(db.dialect, keys)
+			if err != nil {
+				return err
+			}
+			defs = append(defs, "primary key ("+strings.Join(qkeys, ", ")+")")
+		}
+	}
+
+	err := db.Exec("create table if not exists " + table + " (" + strings.Join(defs, ", ") + ")")
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		qcols, err := quoteIdents(db.dialect, idx.Columns)
+		if err != nil {
+			return err
+		}
+		name := idx.Name
+		if name == "" {
+			name = table + "_" + strings.Join(idx.Columns, "_") + "_idx"
+		}
+		create := "create index "
+		if idx.Unique {
+			create = "create unique index "
+		}
+		if err = db.Exec(create + name + " on " + table + " (" + strings.Join(qcols, ", ") + ")"); err != nil {
+			return fmt.Errorf("create index %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// AddColumn adds a nullable column to table.
+func (db *MoDB) AddColumn(table string, col ColumnDef) error {
+	name, err := QuoteIdent(db.dialect, col.Name)
+	if err != nil {
+		return err
+	}
+	return db.Exec("alter table " + table + " add column " + name + " " + col.Type)
+}
+
+// objectRows converts object rows to positional rows.   The columns are
+// the declared columns present in some row, in order, then the others,
+// sorted.   A row without a column has NULL in it.   Keys must be valid
+// column names, see QuoteIdent.
+func objectRows(objs []map[string]any, declared []string) ([][]any, []string, error) {
+	present := make(map[string]bool)
+	for _, obj := range objs {
+		for k := range obj {
+			if !validField(k) {
+				return nil, nil, fmt.Errorf("invalid column name: %q", k)
+			}
+			present[k] = true
+		}
+	}
+
+	var cols []string
+	for _, col := range declared {
+		if present[col] && !slices.Contains(cols, col) {
+			cols = append(cols, col)
+		}
+	}
+	var others []string
+	for k := range present {
+		if !slices.Contains(cols, k) {
+			others = append(others, k)
+		}
+	}
+	slices.Sort(others)
+	cols = append(cols, others...)
+
+	rows := make([][]any, len(objs))
+	for i, obj := range objs {
+		rows[i] = make([]any, len(cols))
+		for j, col := range cols {
+			rows[i][j] = obj[col]
+		}
+	}
+	return rows, cols, nil
+}
+
+// ensureTable creates the table, or adds missing columns to it, so that
+// rows of columns can be written.
+func (c *dbWriter) ensureTable(columns []string, rows [][]any) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("auto schema needs column names")
+	}
+	if c.tableCols == nil {
+		cols, err := c.db.TableColumns(c.conf.Table)
+		if err != nil {
+			return err
+		}
+		c.tableCols = cols
+	}
+
+	colDef := func(name string, i int) ColumnDef {
+		for _, def := range c.conf.Schema {
+			if def.Name == name && def.Type != "" {
+				return def
+			}
+		}
+		// the type of the first non null value.
+		var v any
+		for _, row := range rows {
+			if i >= 0 && i < len(row) && row[i] != nil {
+				v = row[i]
+				break
+			}
+		}
+		return ColumnDef{Name: name, Type: c.db.SQLType(v, slices.Contains(c.conf.Keys, name))}
+	}
+
+	if len(c.tableCols) == 0 {
+		var defs []ColumnDef
+		for _, def := range c.conf.Schema {
+			if !slices.Contains(columns, def.Name) {
+				defs = append(defs, colDef(def.Name, -1))
+			}
+		}
+		for i, name := range columns {
+			defs = append(defs, colDef(name, i))
+		}
+		// declared columns first.
+		slices.SortStableFunc(defs, func(a, b ColumnDef) int {
+			ia := slices.IndexFunc(c.conf.Schema, func(d ColumnDef) bool { return d.Name == a.Name })
+			ib := slices.IndexFunc(c.conf.Schema, func(d ColumnDef) bool { return d.Name == b.Name })
+			if ia < 0 {
+				ia = len(c.conf.Schema)
+			}
+			if ib < 0 {
+				ib = len(c.conf.Schema)
+			}
+			return ia - ib
+		})
+
+		if err := c.db.CreateTable(c.conf.Table, c.conf.IdColumn, defs, c.conf.Keys, c.conf.Indexes); err != nil {
+			return err
+		}
+		c.tableCols = nil
+		if c.conf.IdColumn != "" {
+			c.tableCols = append(c.tableCols, c.conf.IdColumn)
+		}
+		for _, def := range defs {
+			c.tableCols = append(c.tableCols, def.Name)
+		}
+		return nil
+	}
+
+	for i, name := range columns {
+		if slices.ContainsFunc(c.tableCols, func(col string) bool { return strings.EqualFold(col, name) }) {
+			continue
+		}
+		if err := c.db.AddColumn(c.conf.Table, colDef(name, i)); err != nil {
+			return err
+		}
+		c.tableCols = append(c.tableCols, name)
+	}
+	return nil
+}
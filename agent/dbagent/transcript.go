@@ -0,0 +1,206 @@
+package dbagent
+
+//
+// Conversation transcripts.   Each session of mochat or WikiX is a row
+// of mochat_sessions, each exchange, a question and its answer, is a
+// row of mochat_exchanges.
+//
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SessionTable  = "mochat_sessions"
+	ExchangeTable = "mochat_exchanges"
+)
+
+// Exchange is a question and its answer.  Messages and ToolCalls are
+// json, as sent to and returned by the model.
+type Exchange struct {
+	Session   string          `json:"session"`
+	Seq       int             `json:"seq"`
+	Question  string          `json:"question"`
+	Messages  json.RawMessage `json:"messages,omitempty"`
+	ToolCalls json.RawMessage `json:"tool_calls,omitempty"`
+	Answer    string          `json:"answer"`
+	Model     string          `json:"model"`
+	Latency   time.Duration   `json:"latency"`
+	Created   time.Time       `json:"created"`
+	Err       string          `json:"err,omitempty"`
+}
+
+// SessionInfo is a row of the session list.
+type SessionInfo struct {
+	Id        string    `json:"id"`
+	App       string    `json:"app"`
+	Model     string    `json:"model"`
+	Started   time.Time `json:"started"`
+	Exchanges int       `json:"exchanges"`
+}
+
+// TranscriptStore stores transcripts in a database.
+type TranscriptStore struct {
+	db *MoDB
+}
+
+// TranscriptVersion is the schema version, see Migrator, that creates the
+// transcript tables.
+const TranscriptVersion = 3
+
+// OpenTranscriptStore opens the transcript store of db, the transcript
+// tables must have been migrated.
+func OpenTranscriptStore(db *MoDB) (*TranscriptStore, error) {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	v, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	if v < TranscriptVersion {
+		return nil, fmt.Errorf("%w: version %d, transcripts need %d", ErrSchemaOutdated, v, TranscriptVersion)
+	}
+	return &TranscriptStore{db: db}, nil
+}
+
+// newSessionId returns a time ordered, random session id.
+func newSessionId() string {
+	var bs [4]byte
+	rand.Read(bs[:])
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(bs[:])
+}
+
+// NewSession starts a session of app, for example mochat or wikix.
+func (ts *TranscriptStore) NewSession(app, model string) (*Session, error) {
+	ss := &Session{store: ts, Id: newSessionId(), App: app, Model: model}
+	err := ts.db.Exec("insert into "+SessionTable+" (id, app, model, started_ms) values (?, ?, ?, ?)",
+		ss.Id, app, model, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Sessions lists the latest sessions, at most limit.
+func (ts *TranscriptStore) Sessions(limit int) ([]SessionInfo, error) {
+	rows, err := ts.db.Query(`select s.id, s.app, s.model, s.started_ms, count(e.id)
+		from `+SessionTable+` s left join `+ExchangeTable+` e on e.session_id = s.id
+		group by s.id, s.app, s.model, s.started_ms
+		order by s.started_ms desc, s.id desc limit ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []SessionInfo
+	for _, row := range rows {
+		ms, _ := strconv.ParseInt(row[3], 10, 64)
+		n, _ := strconv.Atoi(row[4])
+		ret = append(ret, SessionInfo{Id: row[0], App: row[1], Model: row[2], Started: time.UnixMilli(ms), Exchanges: n})
+	}
+	return ret, nil
+}
+
+// Exchanges returns the exchanges of a session, in order.
+func (ts *TranscriptStore) Exchanges(session string) ([]Exchange, error) {
+	rows, err := ts.db.Query(`select seq, question, messages, tool_calls, answer, model, latency_ms, created_ms, err
+		from `+ExchangeTable+` where session_id = ? order by seq`, session)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Exchange
+	for _, row := range rows {
+		ex := Exchange{Session: session, Question: row[1], Answer: row[4], Model: row[5], Err: row[8]}
+		ex.Seq, _ = strconv.Atoi(row[0])
+		if row[2] != "" {
+			ex.Messages = json.RawMessage(row[2])
+		}
+		if row[3] != "" {
+			ex.ToolCalls = json.RawMessage(row[3])
+		}
+		latency, _ := strconv.ParseInt(row[6], 10, 64)
+		ex.Latency = time.Duration(latency) * time.Millisecond
+		created, _ := strconv.ParseInt(row[7], 10, 64)
+		ex.Created = time.UnixMilli(created)
+		ret = append(ret, ex)
+	}
+	return ret, nil
+}
+
+// Export writes the exchanges of a session as json lines.
+func (ts *TranscriptStore) Export(session string, w io.Writer) error {
+	exs, err := ts.Exchanges(session)
+	if err != nil {
+		return err
+	}
+	if len(exs) == 0 {
+		return fmt.Errorf("no exchange in session %s", session)
+	}
+
+	enc := json.NewEncoder(w)
+	for i := range exs {
+		if err = enc.Encode(&exs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Session records the exchanges of one conversation.
+type Session struct {
+	store *TranscriptStore
+	Id    string
+	App   string
+	Model string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// Record stores an exchange, filling in its session, sequence number
+// and creation time.
+func (ss *Session) Record(ex *Exchange) error {
+	ss.mu.Lock()
+	ex.Session = ss.Id
+	ex.Seq = ss.seq
+	ss.seq++
+	ss.mu.Unlock()
+
+	if ex.Created.IsZero() {
+		ex.Created = time.Now()
+	}
+	if ex.Model == "" {
+		ex.Model = ss.Model
+	}
+
+	return ss.store.db.Exec("insert into "+ExchangeTable+
+		" (session_id, seq, question, messages, tool_calls, answer, model, latency_ms, created_ms, err)"+
+		" values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		ex.Session, ex.Seq, ex.Question, string(ex.Messages), string(ex.ToolCalls),
+		ex.Answer, ex.Model, ex.Latency.Milliseconds(), ex.Created.UnixMilli(), ex.Err)
+}
+
+// RecordChat records an exchange of a chat, it makes Session a
+// llm.Recorder.
+func (ss *Session) RecordChat(question, answer, model string, messages, toolCalls []byte, latency time.Duration, chatErr error) error {
+	ex := &Exchange{
+		Question:  question,
+		Messages:  messages,
+		ToolCalls: toolCalls,
+		Answer:    answer,
+		Model:     model,
+		Latency:   latency,
+	}
+	if chatErr != nil {
+		ex.Err = chatErr.Error()
+	}
+	return ss.Record(ex)
+}
@@ -0,0 +1,82 @@
+package dbagent
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestTranscript(t *testing.T) {
+	db, err := OpenDB("sqlite", filepath.Join(t.TempDir(), "transcript.db"))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	common.Assert(t, db.Dialect() == DialectSqlite, "Unexpected dialect %s", db.Dialect())
+
+	// the tables are created by migrations.
+	_, err = OpenTranscriptStore(db)
+	common.PanicAssert(t, errors.Is(err, ErrSchemaOutdated), "Expected ErrSchemaOutdated, got %v", err)
+	m, err := NewMigrator(db)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	_, err = m.Up(TranscriptVersion)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	ts, err := OpenTranscriptStore(db)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	ss, err := ts.NewSession("mochat", "qwen2.5:14b")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	err = ss.Record(&Exchange{
+		Question: "1+2=",
+		Messages: json.RawMessage(`[{"role":"user","content":"1+2="}]`),
+		Answer:   "3",
+		Latency:  1500 * time.Millisecond,
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	err = ss.Record(&Exchange{
+		Question:  "opHash(2, 3) =",
+		ToolCalls: json.RawMessage(`[{"function":{"name":"opHash"}}]`),
+		Model:     "phi4",
+		Err:       "model failed",
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	_, err = ts.NewSession("wikix", "qwen2.5:14b")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	sessions, err := ts.Sessions(10)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(sessions) == 2, "Expected 2 sessions, got %v", sessions)
+	var found bool
+	for _, s := range sessions {
+		if s.Id == ss.Id {
+			found = true
+			common.Assert(t, s.App == "mochat" && s.Exchanges == 2, "Unexpected session %v", s)
+		}
+	}
+	common.Assert(t, found, "Session %s not listed", ss.Id)
+
+	exs, err := ts.Exchanges(ss.Id)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(exs) == 2, "Expected 2 exchanges, got %v", exs)
+	common.Assert(t, exs[0].Seq == 0 && exs[0].Answer == "3" && exs[0].Model == "qwen2.5:14b", "Unexpected exchange %v", exs[0])
+	common.Assert(t, exs[0].Latency == 1500*time.Millisecond && len(exs[0].ToolCalls) == 0, "Unexpected exchange %v", exs[0])
+	common.Assert(t, exs[1].Model == "phi4" && exs[1].Err == "model failed" && string(exs[1].ToolCalls) != "", "Unexpected exchange %v", exs[1])
+
+	sb := &strings.Builder{}
+	err = ts.Export(ss.Id, sb)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	common.Assert(t, len(lines) == 2, "Expected 2 lines, got %d", len(lines))
+	var ex Exchange
+	err = json.Unmarshal([]byte(lines[1]), &ex)
+	common.Assert(t, err == nil && ex.Question == "opHash(2, 3) =", "Unexpected export %s, %v", lines[1], err)
+
+	err = ts.Export("nosuchsession", sb)
+	common.Assert(t, err != nil, "Expected no exchange error")
+}
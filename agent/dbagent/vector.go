@@ -0,0 +1,392 @@
+package dbagent
+
+//
+// Vector store.   Embedded chunks are stored in a table with their
+// vector, and queried for the k nearest neighbors of a vector.
+//
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/matrixorigin/monlp/textu/chunk"
+)
+
+// FormatVecf32 formats a vector as a vecf32 literal, [1,2,3], which
+// can be written to a MatrixOne vecf32 column by dbWriter.
+func FormatVecf32(v []float32) string {
+	sb := &strings.Builder{}
+	sb.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+// ParseVecf32 parses a vecf32 literal.
+func ParseVecf32(s string) ([]float32, error) {
+	var v []float32
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+// Distance metrics of a vector store.
+const (
+	MetricL2     = "l2"     // euclidean distance
+	MetricCosine = "cosine" // 1 - cosine similarity
+)
+
+// VectorRecord is an embedded chunk to store, the output of the embedder
+// with optional metadata.   Key identifies the record, a chunk without
+// key is keyed by its path and numbers.
+type VectorRecord struct {
+	Key string `json:"key"`
+	chunk.Chunk
+	Vector []float32      `json:"vector"`
+	Meta   map[string]any `json:"meta,omitempty"`
+}
+
+// RecordKey returns the key of the record.
+func (r *VectorRecord) RecordKey() string {
+	if r.Key != "" {
+		return r.Key
+	}
+	return fmt.Sprintf("%s#%d.%d", r.Path, r.Num1, r.Num2)
+}
+
+// VectorHit is a record found by a kNN query, nearest first.
+type VectorHit struct {
+	Key string `json:"key"`
+	chunk.Chunk
+	Meta     map[string]any `json:"meta,omitempty"`
+	Distance float64        `json:"distance"`
+}
+
+// VectorStore stores vectors of chunks in a table.  On MatrixOne the
+// vector is a vecf32 column, and kNN queries are computed by the
+// database.  Sqlite has no vector type, the vector is a blob of little
+// endian float32 and queries scan the table.
+type VectorStore struct {
+	db     *MoDB
+	Table  string
+	Dim    int
+	Metric string
+}
+
+// vectorColumns are the columns of a vector store table, but the vector.
+var vectorColumns = []string{"doc_key", "num1", "num2", "path", "title", "content", "meta"}
+
+// vectorFilterColumns are the columns a query can filter by, other filter
+// names are metadata fields.
+var vectorFilterColumns = map[string]string{
+	"key": "doc_key", "num1": "num1", "num2": "num2", "path": "path", "title": "title",
+}
+
+// OpenVectorStore creates the table of a vector store of dim dimensions
+// if it does not exist.  dim can be 0 on sqlite, or if the table exists.
+func OpenVectorStore(db *MoDB, table string, dim int, metric string) (*VectorStore, error) {
+	switch metric {
+	case "":
+		metric = MetricL2
+	case MetricL2, MetricCosine:
+	default:
+		return nil, fmt.Errorf("unknown vector metric: %s", metric)
+	}
+	vs := &VectorStore{db: db, Table: table, Dim: dim, Metric: metric}
+
+	cols, err := db.TableColumns(table)
+	if err != nil || len(cols) > 0 {
+		return vs, err
+	}
+	vecType := "blob"
+	if db.Dialect() == DialectMySQL {
+		if dim <= 0 {
+			return nil, fmt.Errorf("vector store %s needs the dimension", table)
+		}
+		vecType = fmt.Sprintf("vecf32(%d)", dim)
+	}
+	err = db.Exec(`create table if not exists ` + table + ` (
+		id bigint not null primary key,
+		doc_key varchar(255) not null,
+		num1 int,
+		num2 int,
+		path text,
+		title text,
+		content text,
+		meta text,
+		vec ` + vecType + ` not null)`)
+	if err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// encodeVector encodes a vector as the value of the vector column.
+func (vs *VectorStore) encodeVector(v []float32) any {
+	if vs.db.Dialect() == DialectMySQL {
+		return FormatVecf32(v)
+	}
+	bs := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(bs[4*i:], math.Float32bits(f))
+	}
+	return bs
+}
+
+func decodeVector(bs []byte) []float32 {
+	v := make([]float32, len(bs)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(bs[4*i:]))
+	}
+	return v
+}
+
+// Add stores records in one transaction, a record already in the store
+// is replaced.
+func (vs *VectorStore) Add(recs []VectorRecord) (err error) {
+	for i := range recs {
+		if len(recs[i].Vector) == 0 {
+			return fmt.Errorf("record %s has no vector", recs[i].RecordKey())
+		}
+		if vs.Dim > 0 && len(recs[i].Vector) != vs.Dim {
+			return fmt.Errorf("record %s has %d dimensions, expected %d", recs[i].RecordKey(), len(recs[i].Vector), vs.Dim)
+		}
+	}
+
+	tx, err := vs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	insert := "insert into " + vs.Table + " (id, " + strings.Join(vectorColumns, ", ") + ", vec) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for _, r := range recs {
+		key := r.RecordKey()
+		id := docId(key)
+		if _, err = tx.Exec("delete from "+vs.Table+" where id = ?", id); err != nil {
+			return err
+		}
+		var meta any
+		if len(r.Meta) > 0 {
+			bs, err := json.Marshal(r.Meta)
+			if err != nil {
+				return err
+			}
+			meta = string(bs)
+		}
+		_, err = tx.Exec(insert, id, key, r.Num1, r.Num2, r.Path, r.Title, r.Text, meta, vs.encodeVector(r.Vector))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes a record from the store.
+func (vs *VectorStore) Delete(key string) error {
+	return vs.db.Exec("delete from "+vs.Table+" where id = ?", docId(key))
+}
+
+// filterSQL returns the where clause of filters, equality of columns or
+// metadata fields, and its parameters.
+func (vs *VectorStore) filterSQL(filters map[string]any) (string, []any, error) {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var conds []string
+	var params []any
+	for _, name := range names {
+		v, err := paramValue(filters[name])
+		if err != nil {
+			return "", nil, err
+		}
+		if col, ok := vectorFilterColumns[name]; ok {
+			conds = append(conds, col+" = ?")
+			params = append(params, v)
+			continue
+		}
+		field := strings.TrimPrefix(name, "meta.")
+		if !validField(field) {
+			return "", nil, fmt.Errorf("invalid filter: %s", name)
+		}
+		if vs.db.Dialect() == DialectMySQL {
+			conds = append(conds, "json_unquote(json_extract(meta, ?)) = ?")
+		} else {
+			conds = append(conds, "json_extract(meta, ?) = ?")
+		}
+		params = append(params, "$."+field, v)
+	}
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " where " + strings.Join(conds, " and "), params, nil
+}
+
+func validField(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// distance computes the distance of 2 vectors by metric.
+func distance(metric string, a, b []float32) float64 {
+	var dot, na, nb, sq float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		na += x * x
+		nb += y * y
+		sq += (x - y) * (x - y)
+	}
+	if metric == MetricCosine {
+		if na == 0 || nb == 0 {
+			return 1
+		}
+		return 1 - dot/math.Sqrt(na*nb)
+	}
+	return math.Sqrt(sq)
+}
+
+// Query returns the k records nearest to vector, that match filters.
+func (vs *VectorStore) Query(vector []float32, k int, filters map[string]any) ([]VectorHit, error) {
+	if k <= 0 {
+		k = DefaultSearchLimit
+	}
+	if vs.Dim > 0 && len(vector) != vs.Dim {
+		return nil, fmt.Errorf("query has %d dimensions, expected %d", len(vector), vs.Dim)
+	}
+	where, params, err := vs.filterSQL(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if vs.db.Dialect() == DialectMySQL {
+		fn := "l2_distance"
+		if vs.Metric == MetricCosine {
+			fn = "cosine_distance"
+		}
+		qry := "select " + strings.Join(vectorColumns, ", ") + ", " + fn + "(vec, ?) as dist from " + vs.Table +
+			where + " order by dist limit ?"
+		params = append([]any{FormatVecf32(vector)}, params...)
+		rows, err := vs.db.Query(qry, append(params, k)...)
+		if err != nil {
+			return nil, err
+		}
+		return vectorHits(rows, nil)
+	}
+
+	// brute force, scan the ids and vectors, then read the k nearest.
+	rows, err := vs.db.db.Query("select id, vec from "+vs.Table+where, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type near struct {
+		id   int64
+		dist float64
+	}
+	var nears []near
+	for rows.Next() {
+		var n near
+		var bs []byte
+		if err = rows.Scan(&n.id, &bs); err != nil {
+			return nil, err
+		}
+		v := decodeVector(bs)
+		if len(v) != len(vector) {
+			return nil, fmt.Errorf("query has %d dimensions, stored vector has %d", len(vector), len(v))
+		}
+		n.dist = distance(vs.Metric, vector, v)
+		nears = append(nears, n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	slices.SortFunc(nears, func(a, b near) int {
+		if a.dist < b.dist {
+			return -1
+		} else if a.dist > b.dist {
+			return 1
+		}
+		return 0
+	})
+	if len(nears) > k {
+		nears = nears[:k]
+	}
+	if len(nears) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]any, len(nears))
+	dists := make(map[string]float64)
+	for i, n := range nears {
+		ids[i] = n.id
+		dists[strconv.FormatInt(n.id, 10)] = n.dist
+	}
+	qry := "select " + strings.Join(vectorColumns, ", ") + ", id from " + vs.Table +
+		" where id in (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")"
+	drows, err := vs.db.Query(qry, ids...)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := vectorHits(drows, dists)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(hits, func(a, b VectorHit) int {
+		if a.Distance < b.Distance {
+			return -1
+		} else if a.Distance > b.Distance {
+			return 1
+		}
+		return 0
+	})
+	return hits, nil
+}
+
+// vectorHits converts rows of vectorColumns to hits.  The last column is
+// the distance, or the id of the distance in dists.
+func vectorHits(rows [][]string, dists map[string]float64) ([]VectorHit, error) {
+	hits := make([]VectorHit, 0, len(rows))
+	for _, row := range rows {
+		h := VectorHit{Key: row[0]}
+		n1, _ := strconv.Atoi(row[1])
+		n2, _ := strconv.Atoi(row[2])
+		h.Chunk = chunk.Chunk{Num1: int32(n1), Num2: int32(n2), Path: row[3], Title: row[4], Text: row[5]}
+		if row[6] != "" {
+			if err := json.Unmarshal([]byte(row[6]), &h.Meta); err != nil {
+				return nil, err
+			}
+		}
+		if dists != nil {
+			h.Distance = dists[row[7]]
+		} else {
+			h.Distance, _ = strconv.ParseFloat(row[7], 64)
+		}
+		hits = append(hits, h)
+	}
+	return hits, nil
+}
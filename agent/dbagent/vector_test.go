@@ -0,0 +1,105 @@
+package dbagent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+	"github.com/matrixorigin/monlp/textu/chunk"
+)
+
+func TestVecf32(t *testing.T) {
+	s := FormatVecf32([]float32{1, -0.5, 3.25})
+	common.Assert(t, s == "[1,-0.5,3.25]", "Unexpected vecf32 %s", s)
+	v, err := ParseVecf32(s)
+	common.Assert(t, err == nil && fmt.Sprint(v) == "[1 -0.5 3.25]", "Unexpected vector %v, %v", v, err)
+
+	bs := (&VectorStore{db: &MoDB{dialect: DialectSqlite}}).encodeVector(v)
+	common.Assert(t, fmt.Sprint(decodeVector(bs.([]byte))) == "[1 -0.5 3.25]", "Unexpected blob vector")
+
+	d := distance(MetricL2, []float32{0, 0}, []float32{3, 4})
+	common.Assert(t, d == 5, "Expected 5, got %v", d)
+	d = distance(MetricCosine, []float32{1, 0}, []float32{0, 2})
+	common.Assert(t, math.Abs(d-1) < 1e-9, "Expected 1, got %v", d)
+}
+
+func TestVectorStore(t *testing.T) {
+	db, err := OpenDB("sqlite", "monlp.db")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer db.Close()
+	db.MustExec("drop table if exists testvec")
+
+	wa := NewVectorWriter()
+	err = wa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testvec", "dim": 2}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer wa.Close()
+
+	recs := []VectorRecord{
+		{Chunk: chunk.Chunk{Path: "a", Num1: 1, Num2: 1, Title: "A1", Text: "east"}, Vector: []float32{1, 0}, Meta: map[string]any{"lang": "en", "page": 1}},
+		{Chunk: chunk.Chunk{Path: "a", Num1: 2, Num2: 1, Title: "A2", Text: "north east"}, Vector: []float32{1, 1}, Meta: map[string]any{"lang": "zh", "page": 2}},
+		{Chunk: chunk.Chunk{Path: "b", Num1: 1, Num2: 1, Title: "B1", Text: "north"}, Vector: []float32{0, 3}},
+		{Key: "west", Chunk: chunk.Chunk{Title: "W", Text: "west"}, Vector: []float32{-2, 0}, Meta: map[string]any{"lang": "en"}},
+	}
+	input, _ := json.Marshal(map[string]any{"data": recs})
+	err = wa.ExecuteOne(input, nil, func(data []byte, err error) bool {
+		common.Assert(t, err == nil && string(data) == `{"data":4}`, "Unexpected output %s, %v", data, err)
+		return true
+	})
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	err = wa.ExecuteOne([]byte(`{"data": [{"key": "bad", "vector": [1, 2, 3]}]}`), nil, func([]byte, error) bool { return true })
+	common.Assert(t, err != nil, "Expected dimension error")
+
+	qa := NewVectorQuery()
+	err = qa.Config([]byte(`{"driver": "sqlite", "connstr": "monlp.db", "table": "testvec"}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer qa.Close()
+	query := func(q string) []VectorHit {
+		var output VectorQueryOutput
+		err := qa.ExecuteOne([]byte(q), nil, func(data []byte, err error) bool {
+			common.Assert(t, err == nil, "Expected nil, got %v", err)
+			err = json.Unmarshal(data, &output)
+			common.Assert(t, err == nil, "Expected nil, got %v", err)
+			return true
+		})
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		return output.Data
+	}
+	keys := func(hits []VectorHit) string {
+		var ks []string
+		for _, h := range hits {
+			ks = append(ks, h.Key)
+		}
+		return fmt.Sprint(ks)
+	}
+
+	hits := query(`{"data": [1, 0.2], "k": 2}`)
+	common.Assert(t, keys(hits) == "[a#1.1 a#2.1]", "Unexpected hits %s", keys(hits))
+	common.Assert(t, math.Abs(hits[0].Distance-0.2) < 1e-6, "Unexpected distance %v", hits[0].Distance)
+	common.Assert(t, hits[1].Title == "A2" && hits[1].Text == "north east" && hits[1].Meta["lang"] == "zh", "Unexpected hit %v", hits[1])
+
+	hits = query(`{"data": [1, 0.2], "filter": {"lang": "en"}}`)
+	common.Assert(t, keys(hits) == "[a#1.1 west]", "Unexpected hits %s", keys(hits))
+	hits = query(`{"data": [1, 0.2], "filter": {"meta.page": 2, "path": "a"}}`)
+	common.Assert(t, keys(hits) == "[a#2.1]", "Unexpected hits %s", keys(hits))
+	hits = query(`{"data": [0, 1], "filter": {"path": "c"}}`)
+	common.Assert(t, len(hits) == 0, "Unexpected hits %s", keys(hits))
+
+	err = qa.ExecuteOne([]byte(`{"data": [0, 1], "filter": {"lang') or 1=1 --": "x"}}`), nil, func([]byte, error) bool { return true })
+	common.Assert(t, err != nil, "Expected invalid filter error")
+
+	// cosine ranks by direction only.
+	vs, err := OpenVectorStore(db, "testvec", 0, MetricCosine)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	cos, err := vs.Query([]float32{0, 0.1}, 3, nil)
+	common.Assert(t, err == nil && keys(cos) == "[b#1.1 a#2.1 a#1.1]", "Unexpected hits %s, %v", keys(cos), err)
+
+	err = vs.Delete("west")
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	hits = query(`{"data": [-1, 0], "k": 1}`)
+	common.Assert(t, keys(hits) == "[a#1.1]", "Unexpected hits %s", keys(hits))
+
+	db.MustExec("drop table testvec")
+}
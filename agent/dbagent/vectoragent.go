@@ -0,0 +1,129 @@
+package dbagent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/matrixorigin/monlp/agent"
+)
+
+// VectorWriterInput is the input of the vector writer, embedded chunks,
+// the output of the embedder.
+type VectorWriterInput struct {
+	Data []VectorRecord `json:"data"`
+}
+
+// VectorWriterOutput is the output of the vector writer, the number of
+// records stored.
+type VectorWriterOutput struct {
+	Data int `json:"data"`
+}
+
+// VectorQueryInput is the input of the kNN query, the query vector, the
+// number of neighbors and filters, equality of key, path, title, num1,
+// num2 or metadata fields.
+type VectorQueryInput struct {
+	Data   []float32      `json:"data"`
+	K      int            `json:"k,omitempty"`
+	Filter map[string]any `json:"filter,omitempty"`
+}
+
+// VectorQueryOutput is the output of the kNN query, nearest first.
+type VectorQueryOutput struct {
+	Data []VectorHit `json:"data"`
+}
+
+// vectorAgent stores embedded chunks, or queries them, in the vector
+// store of Config.Table.
+type vectorAgent struct {
+	agent.NilKVAgent
+	agent.SimpleExecuteAgent
+	conf  Config
+	db    *MoDB
+	vs    *VectorStore
+	query bool
+}
+
+func (c *vectorAgent) DB() *MoDB {
+	return c.db
+}
+
+func (c *vectorAgent) Config(bs []byte) error {
+	err := json.Unmarshal(bs, &c.conf)
+	if err != nil {
+		return err
+	}
+	if c.conf.Table == "" {
+		return fmt.Errorf("Table name is empty")
+	}
+
+	c.db, err = c.conf.Open()
+	if err != nil {
+		return err
+	}
+	c.vs, err = OpenVectorStore(c.db, c.conf.Table, c.conf.Dim, c.conf.Metric)
+	return err
+}
+
+func (c *vectorAgent) Close() error {
+	return c.db.Close()
+}
+
+// NewVectorWriter creates an agent that stores embedded chunks.
+func NewVectorWriter() DbAgent {
+	ca := &vectorAgent{}
+	ca.Self = ca
+	return ca
+}
+
+// NewVectorQuery creates an agent that queries the nearest chunks of a
+// vector.
+func NewVectorQuery() DbAgent {
+	ca := &vectorAgent{query: true}
+	ca.Self = ca
+	return ca
+}
+
+func (c *vectorAgent) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var bs []byte
+	var err error
+	if c.query {
+		var in VectorQueryInput
+		// filter numbers are bound as is.
+		dec := json.NewDecoder(bytes.NewReader(input))
+		dec.UseNumber()
+		if err = dec.Decode(&in); err != nil {
+			return err
+		}
+		hits, err := c.vs.Query(in.Data, in.K, in.Filter)
+		if err != nil {
+			return err
+		}
+		bs, err = json.Marshal(VectorQueryOutput{Data: hits})
+		if err != nil {
+			return err
+		}
+	} else {
+		var in VectorWriterInput
+		if err = json.Unmarshal(input, &in); err != nil {
+			return err
+		}
+		if err = c.vs.Add(in.Data); err != nil {
+			return err
+		}
+		bs, err = json.Marshal(VectorWriterOutput{Data: len(in.Data)})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !yield(bs, nil) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package eval evaluates question answering pipelines, the plain chat
+// agent and WikiX, against datasets of questions and reference answers.
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Question is a dataset entry.  Answers are the acceptable reference
+// answers, Facts are the supporting facts, used by the llm judge.
+type Question struct {
+	Id       string   `json:"id"`
+	Question string   `json:"question"`
+	Answers  []string `json:"answers,omitempty"`
+	Facts    []string `json:"facts,omitempty"`
+}
+
+// Dataset is a named list of questions.
+type Dataset struct {
+	Name      string
+	Questions []Question
+}
+
+// LoadDataset loads a dataset file.   A .jsonl file has one Question per
+// line.  Any other file, for example questions.txt, has one question per
+// line, without reference answers.
+func LoadDataset(fn string) (*Dataset, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn))
+	return ReadDataset(name, f, filepath.Ext(fn) == ".jsonl")
+}
+
+// ReadDataset reads a dataset, in jsonl format or one question per line.
+func ReadDataset(name string, r io.Reader, jsonl bool) (*Dataset, error) {
+	ds := &Dataset{Name: name}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var q Question
+		if jsonl {
+			if err := json.Unmarshal([]byte(line), &q); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, lineno, err)
+			}
+		} else {
+			q.Question = line
+		}
+		if q.Id == "" {
+			q.Id = name + "-" + strconv.Itoa(lineno)
+		}
+		ds.Questions = append(ds.Questions, q)
+	}
+	return ds, scanner.Err()
+}
+
+// Write writes the dataset in jsonl format.
+func (ds *Dataset) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, q := range ds.Questions {
+		if err := enc.Encode(&q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package eval
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestScore(t *testing.T) {
+	common.Assert(t, ExactMatch("The Paris.", []string{"paris"}) == 1, "Expected EM 1")
+	common.Assert(t, ExactMatch("Paris, France", []string{"paris"}) == 0, "Expected EM 0")
+	common.Assert(t, ExactMatch("曹雪芹。", []string{"Cao Xueqin", "曹雪芹"}) == 1, "Expected EM 1")
+
+	f1 := F1("Paris, France", []string{"Paris"})
+	common.Assert(t, f1 > 0.66 && f1 < 0.67, "Expected F1 2/3, got %v", f1)
+	f1 = F1("George Orwell wrote it", []string{"Orwell", "George Orwell"})
+	common.Assert(t, f1 > 0.66 && f1 < 0.67, "Expected F1 2/3, got %v", f1)
+	common.Assert(t, F1("London", []string{"Paris"}) == 0, "Expected F1 0")
+}
+
+func TestLoadDataset(t *testing.T) {
+	ds, err := LoadDataset(common.ProjectPath("agent", "wikix", "questions.txt"))
+	common.PanicAssert(t, err == nil, "LoadDataset failed: %v", err)
+	common.Assert(t, len(ds.Questions) == 6, "Expected 6 questions, got %d", len(ds.Questions))
+	common.Assert(t, ds.Questions[0].Id == "questions-1", "Unexpected id %s", ds.Questions[0].Id)
+
+	ds, err = LoadDataset(common.ProjectPath("agent", "eval", "sample.jsonl"))
+	common.PanicAssert(t, err == nil, "LoadDataset failed: %v", err)
+	common.Assert(t, len(ds.Questions) == 5, "Expected 5 questions, got %d", len(ds.Questions))
+	common.Assert(t, ds.Questions[2].Answers[0] == "Paris", "Unexpected answer %v", ds.Questions[2].Answers)
+}
+
+func TestJudgePrompt(t *testing.T) {
+	prompts, err := llm.LoadPrompts(os.DirFS(common.ProjectPath("agent", "eval")))
+	common.PanicAssert(t, err == nil, "LoadPrompts failed: %v", err)
+	err = prompts.CheckFixtures()
+	common.Assert(t, err == nil, "CheckFixtures failed: %v", err)
+}
+
+func TestReport(t *testing.T) {
+	rep := Report{Dataset: "sample"}
+	rep.Add(
+		Result{Runner: "chat", Model: "m1", HasRef: true, EM: 1, F1: 1, Latency: time.Second},
+		Result{Runner: "chat", Model: "m1", HasRef: true, EM: 0, F1: 0.5, Judged: true, Judge: 0.5, Latency: time.Second},
+		Result{Runner: "chat", Model: "m2", Err: "boom"},
+	)
+	sums := rep.Summaries()
+	common.Assert(t, len(sums) == 2, "Expected 2 summaries, got %d", len(sums))
+	common.Assert(t, sums[0].EM == 0.5 && sums[0].F1 == 0.75 && sums[0].Judge == 0.5, "Unexpected summary %v", sums[0])
+	common.Assert(t, sums[1].Errors == 1, "Unexpected summary %v", sums[1])
+	t.Logf("Report:\n%s", rep.String())
+}
+
+func TestEvalModels(t *testing.T) {
+	// pass in -llm qwen2.5:14b,phi4 to compare models.
+	common.ParseFlags([]string{"-vv"})
+	useFixtures(t)
+	judge, err := NewJudge(llm.DefaultModel)
+	common.PanicAssert(t, err == nil, "NewJudge failed: %v", err)
+
+	// questions.txt has no reference answers, answers are logged but
+	// not scored.
+	for _, fn := range []string{
+		common.ProjectPath("agent", "eval", "sample.jsonl"),
+		common.ProjectPath("agent", "wikix", "questions.txt"),
+	} {
+		ds, err := LoadDataset(fn)
+		common.PanicAssert(t, err == nil, "LoadDataset failed: %v", err)
+
+		rep := Report{Dataset: ds.Name}
+		for _, model := range Models(common.LLMModel) {
+			chat, err := NewChatRunner(model)
+			common.PanicAssert(t, err == nil, "NewChatRunner failed: %v", err)
+			rep.Add(Evaluate(ds, chat, judge)...)
+
+			wix, err := NewWikiXRunner(common.ProjectPath("agent", "wikix"), model)
+			common.PanicAssert(t, err == nil, "NewWikiXRunner failed: %v", err)
+			rep.Add(Evaluate(ds, wix, judge)...)
+		}
+
+		for _, res := range rep.Results {
+			t.Logf("%s/%s %s: %s (em %.0f, f1 %.2f, judge %.2f) %s", res.Runner, res.Model, res.Id, res.Answer, res.EM, res.F1, res.Judge, res.Err)
+		}
+		t.Logf("Report:\n%s", rep.String())
+	}
+}
+
+// useFixtures records or replays llm interactions in testdata/llm,
+// as set by MOCHAT_LLM_RECORD, the test is skipped without fixtures.
+func useFixtures(t *testing.T) {
+	err := llm.RecordingForTest(common.ProjectPath("agent", "eval", "testdata", "llm"))
+	if errors.Is(err, llm.ErrNoFixtures) {
+		t.Skip(err)
+	}
+	common.PanicAssert(t, err == nil, "RecordingForTest failed: %v", err)
+}
@@ -0,0 +1,82 @@
+package eval
+
+import (
+	"context"
+	"embed"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/ollama/ollama/api"
+)
+
+//go:embed prompts.json eval_*.txt
+var promptFS embed.FS
+
+// Verdict is the grade of an answer by the llm judge.
+type Verdict struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// Judge grades answers with a llm, against the reference answers and
+// supporting facts of a question.
+type Judge struct {
+	Model string
+	// Options are the generation options, temperature defaults to 0.
+	Options llm.ChatOptions
+	prompts *llm.PromptRegistry
+}
+
+// NewJudge creates a llm judge using model.
+func NewJudge(model string) (*Judge, error) {
+	prompts, err := llm.LoadPrompts(promptFS)
+	if err != nil {
+		return nil, err
+	}
+	j := &Judge{Model: model, prompts: prompts}
+	j.Options.Temperature = new(float64)
+	return j, nil
+}
+
+func bulletList(items []string) string {
+	buf := &strings.Builder{}
+	for _, item := range items {
+		buf.WriteString("- ")
+		buf.WriteString(item)
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// Grade grades answer to question q.
+func (j *Judge) Grade(q *Question, answer string) (Verdict, error) {
+	var v Verdict
+	content, err := j.prompts.Render("eval.judge", map[string]any{
+		"Question":   q.Question,
+		"References": bulletList(q.Answers),
+		"Facts":      bulletList(q.Facts),
+		"Answer":     answer,
+	})
+	if err != nil {
+		return v, err
+	}
+
+	sc, err := llm.NewStructuredChat(v)
+	if err != nil {
+		return v, err
+	}
+
+	req := api.ChatRequest{
+		Model:  j.Model,
+		Stream: new(bool),
+		Messages: []api.Message{
+			{Role: "user", Content: content},
+		},
+	}
+	if err = j.Options.Apply(&req); err != nil {
+		return v, err
+	}
+	_, err = sc.Chat(context.Background(), &req, &v)
+	v.Score = min(max(v.Score, 0), 1)
+	return v, err
+}
@@ -0,0 +1,337 @@
+package eval
+
+//
+// Question generation: ask the llm for question, answer and evidence
+// triples from corpus chunks, to build synthetic datasets.
+//
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/textu/normalize"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	DefaultQuestionsPerChunk = 2
+	// DefaultMinChunkChars skips short chunks, headings and the like.
+	DefaultMinChunkChars = 200
+	// dupJaccard is the token jaccard similarity above which two
+	// questions are duplicates.
+	dupJaccard = 0.8
+)
+
+type QGenConfig struct {
+	Model string `json:"model"`
+	// PerChunk is the number of questions asked per chunk.
+	PerChunk int `json:"per_chunk"`
+	MinChars int `json:"min_chars"`
+	// TitleColumn and TextColumn of string mode rows, by default the
+	// columns of novelChunker (3, 4) or wikiChunker (0, 3) rows.
+	TitleColumn *int `json:"title_column"`
+	TextColumn  *int `json:"text_column"`
+	// File, if set, is the jsonl dataset file the questions are
+	// appended to.
+	File string `json:"file"`
+	// Options are the generation options, temperature defaults to 0.
+	Options llm.ChatOptions `json:"options"`
+}
+
+// QGenOutput are the new, deduplicated questions of an input record.
+type QGenOutput struct {
+	Data []Question `json:"data"`
+}
+
+// qaTriple is the structured output of the llm.
+type qaTriple struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	Evidence string `json:"evidence"`
+}
+
+type qgen struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf    QGenConfig
+	prompts *llm.PromptRegistry
+	// seen are the token sets of generated questions, for dedup.
+	seen []map[string]bool
+}
+
+// NewQuestionGenerator creates a question generation agent.  It takes
+// chunks of novelChunker or rows of wikiChunker, and yields QGenOutput.
+func NewQuestionGenerator(model string) (agent.Agent, error) {
+	prompts, err := llm.LoadPrompts(promptFS)
+	if err != nil {
+		return nil, err
+	}
+	qa := &qgen{prompts: prompts}
+	qa.conf.Model = model
+	qa.conf.PerChunk = DefaultQuestionsPerChunk
+	qa.conf.MinChars = DefaultMinChunkChars
+	qa.conf.Options.Temperature = new(float64)
+	qa.Self = qa
+	return qa, nil
+}
+
+func (q *qgen) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &q.conf)
+	if err != nil {
+		return err
+	}
+	if q.conf.PerChunk <= 0 {
+		q.conf.PerChunk = DefaultQuestionsPerChunk
+	}
+	if err = q.conf.Options.Validate(); err != nil {
+		return err
+	}
+	return q.loadSeen()
+}
+
+func (q *qgen) SetValue(name string, value any) error {
+	switch name {
+	case "model":
+		q.conf.Model = value.(string)
+	case "per_chunk":
+		q.conf.PerChunk = value.(int)
+	case "file":
+		q.conf.File = value.(string)
+		return q.loadSeen()
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	return nil
+}
+
+// loadSeen loads the questions already in the dataset file as the seen
+// questions, so that appending to a dataset does not duplicate them.
+func (q *qgen) loadSeen() error {
+	q.seen = nil
+	if q.conf.File == "" {
+		return nil
+	}
+	f, err := os.Open(q.conf.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ds, err := ReadDataset(filepath.Base(q.conf.File), f, true)
+	if err != nil {
+		return err
+	}
+	for _, qq := range ds.Questions {
+		q.seen = append(q.seen, tokenSet(qq.Question))
+	}
+	return nil
+}
+
+// qgenChunk is the title and text of an input chunk.
+type qgenChunk struct {
+	title string
+	text  string
+}
+
+func column(col *int, dflt int) int {
+	if col == nil {
+		return dflt
+	}
+	return *col
+}
+
+// parseInput parses chunk objects, or string mode rows.
+func (q *qgen) parseInput(input []byte) ([]qgenChunk, error) {
+	var in struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return nil, err
+	}
+
+	var chunks []qgenChunk
+	for _, raw := range in.Data {
+		if len(raw) > 0 && raw[0] == '[' {
+			var row []string
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return nil, err
+			}
+			// wikiChunker rows have 4 columns, novelChunker rows 5.
+			titleCol, textCol := 3, 4
+			if len(row) == 4 {
+				titleCol, textCol = 0, 3
+			}
+			titleCol = column(q.conf.TitleColumn, titleCol)
+			textCol = column(q.conf.TextColumn, textCol)
+			if textCol >= len(row) || titleCol >= len(row) {
+				return nil, fmt.Errorf("row has %d columns, title %d, text %d", len(row), titleCol, textCol)
+			}
+			chunks = append(chunks, qgenChunk{title: row[titleCol], text: row[textCol]})
+		} else {
+			var c struct {
+				Title string `json:"title"`
+				Text  string `json:"text"`
+			}
+			if err := json.Unmarshal(raw, &c); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, qgenChunk{title: c.Title, text: c.Text})
+		}
+	}
+	return chunks, nil
+}
+
+// generate asks the llm for question triples of a chunk.
+func (q *qgen) generate(c qgenChunk) ([]qaTriple, error) {
+	content, err := q.prompts.Render("eval.qgen", map[string]any{
+		"Count": q.conf.PerChunk,
+		"Title": c.title,
+		"Text":  c.text,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Questions []qaTriple `json:"questions"`
+	}
+	sc, err := llm.NewStructuredChat(reply)
+	if err != nil {
+		return nil, err
+	}
+	req := api.ChatRequest{
+		Model:    q.conf.Model,
+		Stream:   new(bool),
+		Messages: []api.Message{{Role: "user", Content: content}},
+	}
+	if err = q.conf.Options.Apply(&req); err != nil {
+		return nil, err
+	}
+	_, err = sc.Chat(context.Background(), &req, &reply)
+	return reply.Questions, err
+}
+
+func tokenSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, tk := range normalize.Tokens(s) {
+		set[tk] = true
+	}
+	return set
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	inter := 0
+	for tk := range a {
+		if b[tk] {
+			inter++
+		}
+	}
+	union := len(a) + len(b) - inter
+	if union == 0 {
+		return 1
+	}
+	return float64(inter) / float64(union)
+}
+
+// accept checks a triple, it must be complete, its evidence must be in
+// the chunk and its question must not duplicate a seen question.
+func (q *qgen) accept(t qaTriple, text string) bool {
+	if strings.TrimSpace(t.Question) == "" || strings.TrimSpace(t.Answer) == "" {
+		return false
+	}
+	ev := normalize.Answer(t.Evidence)
+	if ev == "" || !strings.Contains(normalize.Answer(text), ev) {
+		slog.Debug("QGen evidence not in chunk", "question", t.Question)
+		return false
+	}
+
+	set := tokenSet(t.Question)
+	for _, s := range q.seen {
+		if jaccard(set, s) >= dupJaccard {
+			slog.Debug("QGen duplicate question", "question", t.Question)
+			return false
+		}
+	}
+	q.seen = append(q.seen, set)
+	return true
+}
+
+// questionId is a stable id of a question.
+func questionId(question string) string {
+	h := sha256.Sum256([]byte(normalize.Answer(question)))
+	return "qgen-" + hex.EncodeToString(h[:4])
+}
+
+func (q *qgen) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	chunks, err := q.parseInput(input)
+	if err != nil {
+		return err
+	}
+
+	var output QGenOutput
+	for _, c := range chunks {
+		if len([]rune(c.text)) < q.conf.MinChars {
+			continue
+		}
+		triples, err := q.generate(c)
+		if err != nil {
+			// a chunk the model fails on is skipped, not fatal.
+			slog.Warn("QGen generate failed", "title", c.title, "err", err)
+			continue
+		}
+		for _, t := range triples {
+			if q.accept(t, c.text) {
+				output.Data = append(output.Data, Question{
+					Id:       questionId(t.Question),
+					Question: t.Question,
+					Answers:  []string{t.Answer},
+					Facts:    []string{t.Evidence},
+				})
+			}
+		}
+	}
+
+	if q.conf.File != "" && len(output.Data) > 0 {
+		if err = appendDataset(q.conf.File, output.Data); err != nil {
+			return err
+		}
+	}
+
+	bs, err := json.Marshal(output)
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
+
+// appendDataset appends questions to a jsonl dataset file.
+func appendDataset(fn string, questions []Question) error {
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	ds := &Dataset{Questions: questions}
+	if err = ds.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
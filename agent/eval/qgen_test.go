@@ -0,0 +1,103 @@
+package eval
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/common"
+)
+
+const parisText = "Paris is the capital and largest city of France. The city had an estimated population of 2,102,650 residents in January 2023."
+
+func TestQGenAccept(t *testing.T) {
+	qa, err := NewQuestionGenerator(llm.DefaultModel)
+	common.PanicAssert(t, err == nil, "NewQuestionGenerator failed: %v", err)
+	q := qa.(*qgen)
+
+	chunks, err := q.parseInput([]byte(`{"data": [
+		["Paris", "0", "", "Paris is a city."],
+		["1", "2", "/1", "Chapter 1", "It was a dark night."],
+		{"title": "Paris", "text": "The city."}
+	]}`))
+	common.PanicAssert(t, err == nil, "parseInput failed: %v", err)
+	common.Assert(t, len(chunks) == 3, "Expected 3 chunks, got %d", len(chunks))
+	common.Assert(t, chunks[0].title == "Paris" && chunks[0].text == "Paris is a city.", "Unexpected wiki chunk %v", chunks[0])
+	common.Assert(t, chunks[1].title == "Chapter 1" && chunks[1].text == "It was a dark night.", "Unexpected novel chunk %v", chunks[1])
+	common.Assert(t, chunks[2].title == "Paris", "Unexpected chunk %v", chunks[2])
+
+	ok := q.accept(qaTriple{"What is the capital of France?", "Paris", "Paris is the capital and largest city of France."}, parisText)
+	common.Assert(t, ok, "Expected triple accepted")
+	ok = q.accept(qaTriple{"What is the capital city of France?", "Paris", "Paris is the capital and largest city of France."}, parisText)
+	common.Assert(t, !ok, "Expected duplicate question rejected")
+	ok = q.accept(qaTriple{"What river runs through Paris?", "Seine", "The Seine runs through Paris."}, parisText)
+	common.Assert(t, !ok, "Expected evidence not in chunk rejected")
+	ok = q.accept(qaTriple{"How many residents did Paris have in January 2023?", "2,102,650", "the city had an estimated population of 2,102,650 residents"}, parisText)
+	common.Assert(t, ok, "Expected triple accepted")
+
+	common.Assert(t, questionId("What is the capital of France?") == questionId("what is the capital of France"), "Expected stable id")
+}
+
+func TestQGenDatasetDedup(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "qgen.jsonl")
+	err := appendDataset(fn, []Question{{Id: "q1", Question: "What is the capital of France?", Answers: []string{"Paris"}}})
+	common.PanicAssert(t, err == nil, "appendDataset failed: %v", err)
+
+	// questions already in the dataset are duplicates.
+	qa, err := NewQuestionGenerator(llm.DefaultModel)
+	common.PanicAssert(t, err == nil, "NewQuestionGenerator failed: %v", err)
+	err = qa.Config([]byte(`{"file": "` + fn + `"}`))
+	common.PanicAssert(t, err == nil, "Config failed: %v", err)
+	ok := qa.(*qgen).accept(qaTriple{"What is the capital city of France?", "Paris", "Paris is the capital and largest city of France."}, parisText)
+	common.Assert(t, !ok, "Expected question in dataset rejected")
+	// loading the file again does not add it twice, another file
+	// replaces it.
+	err = qa.SetValue("file", fn)
+	common.PanicAssert(t, err == nil, "SetValue failed: %v", err)
+	common.Assert(t, len(qa.(*qgen).seen) == 1, "Expected 1 seen, got %d", len(qa.(*qgen).seen))
+	err = qa.SetValue("file", filepath.Join(t.TempDir(), "other.jsonl"))
+	common.PanicAssert(t, err == nil, "SetValue failed: %v", err)
+	common.Assert(t, len(qa.(*qgen).seen) == 0, "Expected nothing seen, got %d", len(qa.(*qgen).seen))
+
+	// a new dataset file has nothing seen.
+	qa, err = NewQuestionGenerator(llm.DefaultModel)
+	common.PanicAssert(t, err == nil, "NewQuestionGenerator failed: %v", err)
+	err = qa.SetValue("file", filepath.Join(t.TempDir(), "new.jsonl"))
+	common.PanicAssert(t, err == nil, "SetValue failed: %v", err)
+	ok = qa.(*qgen).accept(qaTriple{"What is the capital city of France?", "Paris", "Paris is the capital and largest city of France."}, parisText)
+	common.Assert(t, ok, "Expected question accepted")
+}
+
+func TestQGenParis(t *testing.T) {
+	common.ParseFlags([]string{"-vv"})
+	useFixtures(t)
+
+	qa, err := NewQuestionGenerator(llm.DefaultModel)
+	common.PanicAssert(t, err == nil, "NewQuestionGenerator failed: %v", err)
+	fn := filepath.Join(t.TempDir(), "qgen.jsonl")
+	err = qa.Config([]byte(`{"min_chars": 50, "file": "` + fn + `"}`))
+	common.PanicAssert(t, err == nil, "Config failed: %v", err)
+
+	input, _ := json.Marshal(map[string]any{"data": [][]string{{"Paris", "0", "", parisText}}})
+	var output QGenOutput
+	err = qa.ExecuteOne(input, nil, func(bs []byte, err error) bool {
+		common.PanicAssert(t, err == nil, "ExecuteOne failed: %v", err)
+		common.PanicAssert(t, json.Unmarshal(bs, &output) == nil, "Unmarshal failed")
+		return true
+	})
+	common.PanicAssert(t, err == nil, "ExecuteOne failed: %v", err)
+	for _, q := range output.Data {
+		t.Logf("%s: %s -> %v", q.Id, q.Question, q.Answers)
+	}
+
+	_, err = os.Stat(fn)
+	if len(output.Data) > 0 {
+		ds, err := LoadDataset(fn)
+		common.PanicAssert(t, err == nil, "LoadDataset failed: %v", err)
+		common.Assert(t, len(ds.Questions) == len(output.Data), "Expected %d questions, got %d", len(output.Data), len(ds.Questions))
+	} else {
+		common.Assert(t, os.IsNotExist(err), "Expected no dataset file")
+	}
+}
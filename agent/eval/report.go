@@ -0,0 +1,169 @@
+package eval
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Result is the result of one question, answered by one runner.
+type Result struct {
+	Runner   string        `json:"runner"`
+	Model    string        `json:"model"`
+	Id       string        `json:"id"`
+	Question string        `json:"question"`
+	Answer   string        `json:"answer"`
+	HasRef   bool          `json:"has_ref"` // EM and F1 are only meaningful with references
+	EM       float64       `json:"em"`
+	F1       float64       `json:"f1"`
+	Judged   bool          `json:"judged"`
+	Judge    float64       `json:"judge"`
+	Reason   string        `json:"reason"`
+	Err      string        `json:"err"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// Evaluate runs every question of the dataset through the runner and
+// scores the answers.   judge may be nil, then answers are not judged.
+func Evaluate(ds *Dataset, r Runner, judge *Judge) []Result {
+	var results []Result
+	for i := range ds.Questions {
+		q := &ds.Questions[i]
+		res := Result{
+			Runner:   r.Name(),
+			Model:    r.Model(),
+			Id:       q.Id,
+			Question: q.Question,
+		}
+
+		start := time.Now()
+		answer, err := r.Answer(q.Question)
+		res.Latency = time.Since(start)
+		res.Answer = answer
+		if err != nil {
+			res.Err = err.Error()
+			results = append(results, res)
+			continue
+		}
+
+		if len(q.Answers) > 0 {
+			res.HasRef = true
+			res.EM = ExactMatch(answer, q.Answers)
+			res.F1 = F1(answer, q.Answers)
+		}
+
+		if judge != nil && (len(q.Answers) > 0 || len(q.Facts) > 0) {
+			v, err := judge.Grade(q, answer)
+			if err != nil {
+				slog.Warn("eval judge failed", "id", q.Id, "err", err)
+			} else {
+				res.Judged = true
+				res.Judge = v.Score
+				res.Reason = v.Reason
+			}
+		}
+
+		slog.Info("eval", "runner", res.Runner, "model", res.Model, "id", res.Id, "em", res.EM, "f1", res.F1, "judge", res.Judge)
+		results = append(results, res)
+	}
+	return results
+}
+
+// Summary is the aggregated score of a runner and model.
+type Summary struct {
+	Runner  string
+	Model   string
+	N       int
+	Errors  int
+	EM      float64
+	F1      float64
+	Judge   float64
+	Latency time.Duration
+}
+
+// Report compares the results of runners and models on a dataset.
+type Report struct {
+	Dataset string
+	Results []Result
+}
+
+func (rep *Report) Add(results ...Result) {
+	rep.Results = append(rep.Results, results...)
+}
+
+// Summaries aggregates the results by runner and model, in the order
+// they were added.
+func (rep *Report) Summaries() []Summary {
+	var sums []Summary
+	idx := make(map[string]int)
+	nref := make(map[string]int)
+	njudged := make(map[string]int)
+
+	for _, res := range rep.Results {
+		key := res.Runner + "/" + res.Model
+		i, ok := idx[key]
+		if !ok {
+			i = len(sums)
+			idx[key] = i
+			sums = append(sums, Summary{Runner: res.Runner, Model: res.Model})
+		}
+
+		s := &sums[i]
+		s.N++
+		s.Latency += res.Latency
+		if res.Err != "" {
+			s.Errors++
+		}
+		if res.HasRef {
+			nref[key]++
+			s.EM += res.EM
+			s.F1 += res.F1
+		}
+		if res.Judged {
+			njudged[key]++
+			s.Judge += res.Judge
+		}
+	}
+
+	for key, i := range idx {
+		s := &sums[i]
+		if nref[key] > 0 {
+			s.EM /= float64(nref[key])
+			s.F1 /= float64(nref[key])
+		}
+		if njudged[key] > 0 {
+			s.Judge /= float64(njudged[key])
+		}
+		if s.N > 0 {
+			s.Latency /= time.Duration(s.N)
+		}
+	}
+	return sums
+}
+
+// String returns the summaries pretty printed as a table.
+func (rep *Report) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Dataset: %s\n", rep.Dataset)
+	tw := tablewriter.NewWriter(sb)
+	tw.SetHeader([]string{"runner", "model", "n", "errors", "em", "f1", "judge", "latency"})
+	tw.SetBorders(tablewriter.Border{Left: true, Right: true, Top: false, Bottom: false})
+	tw.SetCenterSeparator("|")
+	for _, s := range rep.Summaries() {
+		tw.Append([]string{
+			s.Runner,
+			s.Model,
+			fmt.Sprintf("%d", s.N),
+			fmt.Sprintf("%d", s.Errors),
+			fmt.Sprintf("%.3f", s.EM),
+			fmt.Sprintf("%.3f", s.F1),
+			fmt.Sprintf("%.3f", s.Judge),
+			s.Latency.Round(time.Millisecond).String(),
+		})
+	}
+	tw.Render()
+	return sb.String()
+}
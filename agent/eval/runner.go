@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/agent/wikix"
+	"github.com/ollama/ollama/api"
+)
+
+// Runner answers questions, with a model.
+type Runner interface {
+	Name() string
+	Model() string
+	Answer(question string) (string, error)
+}
+
+// Models splits a comma separated list of models, as given by -llm.
+func Models(list string) []string {
+	var models []string
+	for _, m := range strings.Split(list, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+type chatRunner struct {
+	model string
+	chat  agent.Agent
+}
+
+// NewChatRunner creates a runner that asks the plain llm chat agent.
+func NewChatRunner(model string) (Runner, error) {
+	chat, err := llm.NewChatWithTemplate(model, "chat.assistant", map[string]any{"Answer": "one sentence"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &chatRunner{model: model, chat: chat}, nil
+}
+
+func (r *chatRunner) Name() string {
+	return "chat"
+}
+
+func (r *chatRunner) Model() string {
+	return r.model
+}
+
+func (r *chatRunner) Answer(question string) (string, error) {
+	return askAgent(r.chat, question)
+}
+
+// askAgent sends question to an agent that takes llm.ChatInput and
+// returns llm.ChatOutput.
+func askAgent(a agent.Agent, question string) (string, error) {
+	input, err := json.Marshal(llm.ChatInput{
+		Messages: []api.Message{{Role: "user", Content: question}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var output llm.ChatOutput
+	var yieldErr error
+	err = a.ExecuteOne(input, nil, func(bs []byte, err error) bool {
+		if err != nil {
+			yieldErr = err
+			return false
+		}
+		yieldErr = json.Unmarshal(bs, &output)
+		return false
+	})
+	if err == agent.ErrYieldDone {
+		err = nil
+	}
+	if err == nil {
+		err = yieldErr
+	}
+	return llm.StripThink(output.Response.Message.Content), err
+}
+
+type wikixRunner struct {
+	model string
+	wix   *wikix.WikiX
+}
+
+// NewWikiXRunner creates a runner that explores wikipedia with WikiX,
+// dir is the WikiX prompt directory.
+func NewWikiXRunner(dir, model string) (Runner, error) {
+	wix, err := wikix.NewWikiX(dir, model, wikix.SystemPrompt)
+	if err != nil {
+		return nil, err
+	}
+	return &wikixRunner{model: model, wix: wix}, nil
+}
+
+func (r *wikixRunner) Name() string {
+	return "wikix"
+}
+
+func (r *wikixRunner) Model() string {
+	return r.model
+}
+
+func (r *wikixRunner) Answer(question string) (string, error) {
+	return r.wix.Answer(question)
+}
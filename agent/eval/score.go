@@ -0,0 +1,55 @@
+package eval
+
+import (
+	"github.com/matrixorigin/monlp/textu/normalize"
+)
+
+// ExactMatch is 1 if the normalized answer equals any of the normalized
+// references, 0 otherwise.
+func ExactMatch(answer string, refs []string) float64 {
+	na := normalize.Answer(answer)
+	for _, ref := range refs {
+		if na == normalize.Answer(ref) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// F1 is the best token level F1 score of the answer against references.
+func F1(answer string, refs []string) float64 {
+	best := 0.0
+	atks := normalize.Tokens(answer)
+	for _, ref := range refs {
+		best = max(best, tokenF1(atks, normalize.Tokens(ref)))
+	}
+	return best
+}
+
+func tokenF1(pred, ref []string) float64 {
+	if len(pred) == 0 || len(ref) == 0 {
+		if len(pred) == len(ref) {
+			return 1
+		}
+		return 0
+	}
+
+	counts := make(map[string]int)
+	for _, tk := range ref {
+		counts[tk]++
+	}
+	common := 0
+	for _, tk := range pred {
+		if counts[tk] > 0 {
+			counts[tk]--
+			common++
+		}
+	}
+	if common == 0 {
+		return 0
+	}
+
+	precision := float64(common) / float64(len(pred))
+	recall := float64(common) / float64(len(ref))
+	return 2 * precision * recall / (precision + recall)
+}
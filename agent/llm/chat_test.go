@@ -2,6 +2,7 @@ package llm
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"testing"
@@ -12,6 +13,7 @@ import (
 )
 
 func TestSimpleChat(t *testing.T) {
+	useFixtures(t)
 	qs := ChatInput{
 		Messages: []api.Message{
 			{Role: "user", Content: "1+2="},
@@ -27,7 +29,9 @@ func TestSimpleChat(t *testing.T) {
 		string(qss),
 	})
 
-	chat := NewChatWithPrompt("llama3.2-vision", "You are a helpful assistant.  You should answer each question in one word.", nil)
+	oneword := map[string]any{"Answer": "one word"}
+	chat, err := NewChatWithTemplate("llama3.2-vision", "chat.assistant", oneword, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
 
 	var pipe agent.AgentPipe
 	pipe.AddAgent(stra)
@@ -42,7 +46,8 @@ func TestSimpleChat(t *testing.T) {
 		t.Logf("Query Result: %s", string(data))
 	}
 
-	chat2 := NewChatWithPrompt("deepseek-r1:14b", "You are a helpful assistant.  You should answer each question in one word.", nil)
+	chat2, err := NewChatWithTemplate("deepseek-r1:14b", "chat.assistant", oneword, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
 	var pipe2 agent.AgentPipe
 	pipe2.AddAgent(stra)
 	pipe2.AddAgent(chat2)
@@ -105,6 +110,7 @@ type QA struct {
 }
 
 func TestJsonChat(t *testing.T) {
+	useFixtures(t)
 	// should use json format output.
 	// XXX: as of jan 2025, if we use json format output ollama will
 	// not call the tools.  so we disable json format output for now.
@@ -237,16 +243,13 @@ func TestJsonChat(t *testing.T) {
 	common.Assert(t, err == nil, "Expected nil, got %v", err)
 	jsonRaw := json.RawMessage(jsonSchema)
 
-	chat := NewChatWithPrompt(
+	chat, err := NewChatWithTemplate(
 		// As of Jan 2025, qwen2.5:14b is the best model for function calling.
 		// esp, llama3.2-vision and deepseek-r1 do not enable tool calling in ollama.
 		"qwen2.5:14b", // "llama3.1",
-		`You are a helpful assistant.  You may use a list of tools when appropriate.  Your final answer should be valid json like the following.
-		[
-			"answer": "answer in one word or phrase"},
-		]
-		`,
+		"chat.tools.json", nil,
 		toolCall)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
 	if jsonFormatOutput {
 		chat.SetValue("format", jsonRaw)
 	}
@@ -278,3 +281,13 @@ func TestJsonChat(t *testing.T) {
 		}
 	}
 }
+
+// useFixtures records or replays llm interactions in testdata/llm,
+// as set by MOCHAT_LLM_RECORD, the test is skipped without fixtures.
+func useFixtures(t *testing.T) {
+	err := RecordingForTest(common.ProjectPath("agent", "llm", "testdata", "llm"))
+	if errors.Is(err, ErrNoFixtures) {
+		t.Skip(err)
+	}
+	common.PanicAssert(t, err == nil, "RecordingForTest failed: %v", err)
+}
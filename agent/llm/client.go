@@ -0,0 +1,282 @@
+package llm
+
+//
+// The llm client boundary.   Besides the live ollama client, requests
+// and responses can be recorded to fixture files, and replayed later
+// without a model, so that tests can run hermetically.
+//
+//	MOCHAT_LLM_RECORD=record go test ./agent/llm   # record testdata/llm
+//	MOCHAT_LLM_RECORD=replay go test ./agent/llm   # replay, no model
+//	MOCHAT_LLM_RECORD=live go test ./agent/llm     # live, ignore fixtures
+//
+// Tests replay by default, and skip if their fixture directory does not
+// exist.
+//
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Client is the llm client used by agents, *api.Client implements it.
+type Client interface {
+	Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error
+	Embed(ctx context.Context, req *api.EmbedRequest) (*api.EmbedResponse, error)
+}
+
+const (
+	RecordOff    = ""       // talk to the live model
+	RecordMode   = "record" // talk to the live model, record interactions
+	ReplayMode   = "replay" // replay recorded interactions, no model
+	LiveMode     = "live"   // talk to the live model, same as off outside tests
+	RecordEnv    = "MOCHAT_LLM_RECORD"
+	FixturesEnv  = "MOCHAT_LLM_FIXTURES"
+	fixtureChat  = "chat"
+	fixtureEmbed = "embed"
+)
+
+var (
+	ErrNoRecording = errors.New("no recorded llm interaction")
+	ErrNoFixtures  = errors.New("no llm fixtures, record them with MOCHAT_LLM_RECORD=record")
+
+	recMu   sync.Mutex
+	recMode = envRecordMode()
+	recDir  = os.Getenv(FixturesEnv)
+	// recSeq counts requests by key, so that repeated identical requests,
+	// for example sampling at non zero temperature, are recorded and
+	// replayed in order.
+	recSeq = make(map[string]int)
+)
+
+// envRecordMode returns the record mode of the environment, live is the
+// same as off outside tests.
+func envRecordMode() string {
+	mode := os.Getenv(RecordEnv)
+	if mode == LiveMode {
+		return RecordOff
+	}
+	return mode
+}
+
+// SetRecording sets the record/replay mode and the fixture directory.
+func SetRecording(mode, dir string) error {
+	switch mode {
+	case RecordOff, RecordMode, ReplayMode:
+	default:
+		return fmt.Errorf("invalid llm record mode: %s", mode)
+	}
+	if mode != RecordOff && dir == "" {
+		return fmt.Errorf("llm %s mode needs a fixture directory", mode)
+	}
+
+	recMu.Lock()
+	defer recMu.Unlock()
+	recMode = mode
+	recDir = dir
+	recSeq = make(map[string]int)
+	return nil
+}
+
+// RecordingForTest uses dir as fixture directory, unless one is given in
+// the environment.   The mode is taken from the environment, if it is not
+// set, tests replay, and ErrNoFixtures is returned when the fixture
+// directory does not exist, tests skip then rather than go live.
+// MOCHAT_LLM_RECORD=live runs tests against the live model.
+func RecordingForTest(dir string) error {
+	if d := os.Getenv(FixturesEnv); d != "" {
+		dir = d
+	}
+	mode := os.Getenv(RecordEnv)
+	switch mode {
+	case LiveMode:
+		mode = RecordOff
+	case RecordOff:
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			return fmt.Errorf("%w: %s", ErrNoFixtures, dir)
+		}
+		mode = ReplayMode
+	}
+	return SetRecording(mode, dir)
+}
+
+// Recording returns the record/replay mode and the fixture directory.
+func Recording() (string, string) {
+	recMu.Lock()
+	defer recMu.Unlock()
+	return recMode, recDir
+}
+
+// NewClient returns a llm client, wrapped for record or replay if set.
+func NewClient() (Client, error) {
+	recMu.Lock()
+	mode, dir := recMode, recDir
+	recMu.Unlock()
+
+	switch mode {
+	case RecordOff:
+		return api.ClientFromEnvironment()
+	case RecordMode:
+		cli, err := api.ClientFromEnvironment()
+		if err != nil {
+			return nil, err
+		}
+		return &recorder{inner: cli, dir: dir}, nil
+	case ReplayMode:
+		return &replayer{dir: dir}, nil
+	}
+	return nil, fmt.Errorf("invalid llm record mode: %s", mode)
+}
+
+// fixture is a recorded request and its responses.
+type fixture struct {
+	Kind      string             `json:"kind"`
+	Request   json.RawMessage    `json:"request"`
+	Responses []api.ChatResponse `json:"responses,omitempty"`
+	Embed     *api.EmbedResponse `json:"embed,omitempty"`
+	Err       string             `json:"err,omitempty"`
+}
+
+// fixtureKey hashes the kind and request.  encoding/json sorts map keys
+// so the same request always has the same key.
+func fixtureKey(kind string, req any) (string, json.RawMessage, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return "", nil, err
+	}
+	h := sha256.Sum256(append([]byte(kind+":"), bs...))
+	return kind + "-" + hex.EncodeToString(h[:8]), bs, nil
+}
+
+// fixturePath returns the file of the seq-th occurrence of key.
+func fixturePath(dir, key string, seq int) string {
+	if seq == 0 {
+		return filepath.Join(dir, key+".json")
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%d.json", key, seq))
+}
+
+func nextSeq(key string) int {
+	recMu.Lock()
+	defer recMu.Unlock()
+	seq := recSeq[key]
+	recSeq[key]++
+	return seq
+}
+
+type recorder struct {
+	inner Client
+	dir   string
+}
+
+func (r *recorder) save(key string, fx *fixture) error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fixturePath(r.dir, key, nextSeq(key)), bs, 0644)
+}
+
+func (r *recorder) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	key, reqbs, err := fixtureKey(fixtureChat, req)
+	if err != nil {
+		return err
+	}
+
+	fx := &fixture{Kind: fixtureChat, Request: reqbs}
+	err = r.inner.Chat(ctx, req, func(resp api.ChatResponse) error {
+		fx.Responses = append(fx.Responses, resp)
+		return fn(resp)
+	})
+	if err != nil {
+		fx.Err = err.Error()
+	}
+	if serr := r.save(key, fx); serr != nil {
+		return serr
+	}
+	return err
+}
+
+func (r *recorder) Embed(ctx context.Context, req *api.EmbedRequest) (*api.EmbedResponse, error) {
+	key, reqbs, err := fixtureKey(fixtureEmbed, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.inner.Embed(ctx, req)
+	fx := &fixture{Kind: fixtureEmbed, Request: reqbs, Embed: resp}
+	if err != nil {
+		fx.Err = err.Error()
+	}
+	if serr := r.save(key, fx); serr != nil {
+		return nil, serr
+	}
+	return resp, err
+}
+
+type replayer struct {
+	dir string
+}
+
+// load loads the fixture of the next occurrence of key, if the request
+// was recorded fewer times, the last recording is replayed.
+func (r *replayer) load(key string) (*fixture, error) {
+	seq := nextSeq(key)
+	for ; seq >= 0; seq-- {
+		bs, err := os.ReadFile(fixturePath(r.dir, key, seq))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var fx fixture
+		if err = json.Unmarshal(bs, &fx); err != nil {
+			return nil, err
+		}
+		if fx.Err != "" {
+			return nil, errors.New(fx.Err)
+		}
+		return &fx, nil
+	}
+	return nil, fmt.Errorf("%w: %s in %s", ErrNoRecording, key, r.dir)
+}
+
+func (r *replayer) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	key, _, err := fixtureKey(fixtureChat, req)
+	if err != nil {
+		return err
+	}
+	fx, err := r.load(key)
+	if err != nil {
+		return err
+	}
+	for _, resp := range fx.Responses {
+		if err = fn(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *replayer) Embed(ctx context.Context, req *api.EmbedRequest) (*api.EmbedResponse, error) {
+	key, _, err := fixtureKey(fixtureEmbed, req)
+	if err != nil {
+		return nil, err
+	}
+	fx, err := r.load(key)
+	if err != nil {
+		return nil, err
+	}
+	return fx.Embed, nil
+}
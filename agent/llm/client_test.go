@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+// echoClient answers with the last message and a call counter.
+type echoClient struct {
+	n int
+}
+
+func (c *echoClient) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	c.n++
+	last := req.Messages[len(req.Messages)-1]
+	return fn(api.ChatResponse{
+		Model:   req.Model,
+		Message: api.Message{Role: "assistant", Content: last.Content + string(rune('0'+c.n))},
+		Done:    true,
+	})
+}
+
+func (c *echoClient) Embed(ctx context.Context, req *api.EmbedRequest) (*api.EmbedResponse, error) {
+	return &api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{{1, 2}}}, nil
+}
+
+func chatContent(t *testing.T, cli Client, content string) string {
+	var ret string
+	req := api.ChatRequest{Model: "m", Messages: []api.Message{{Role: "user", Content: content}}}
+	err := cli.Chat(context.Background(), &req, func(resp api.ChatResponse) error {
+		ret = resp.Message.Content
+		return nil
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	return ret
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	defer SetRecording(RecordOff, "")
+
+	err := SetRecording(RecordMode, dir)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	rec := &recorder{inner: &echoClient{}, dir: dir}
+	common.Assert(t, chatContent(t, rec, "a") == "a1", "Unexpected record")
+	common.Assert(t, chatContent(t, rec, "b") == "b2", "Unexpected record")
+	// same request again, recorded in order.
+	common.Assert(t, chatContent(t, rec, "a") == "a3", "Unexpected record")
+	_, err = rec.Embed(context.Background(), &api.EmbedRequest{Model: "e", Input: "x"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	err = SetRecording(ReplayMode, dir)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	cli, err := NewClient()
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, chatContent(t, cli, "a") == "a1", "Unexpected replay")
+	common.Assert(t, chatContent(t, cli, "a") == "a3", "Unexpected replay")
+	common.Assert(t, chatContent(t, cli, "a") == "a3", "Unexpected replay")
+	common.Assert(t, chatContent(t, cli, "b") == "b2", "Unexpected replay")
+
+	resp, err := cli.Embed(context.Background(), &api.EmbedRequest{Model: "e", Input: "x"})
+	common.Assert(t, err == nil && len(resp.Embeddings) == 1, "Unexpected embed replay %v, %v", resp, err)
+
+	req := api.ChatRequest{Model: "m", Messages: []api.Message{{Role: "user", Content: "never recorded"}}}
+	err = cli.Chat(context.Background(), &req, func(api.ChatResponse) error { return nil })
+	common.Assert(t, errors.Is(err, ErrNoRecording), "Expected ErrNoRecording, got %v", err)
+
+	err = SetRecording("bogus", dir)
+	common.Assert(t, err != nil, "Expected error for bad mode")
+
+	// tests without fixtures skip, rather than go live.
+	t.Setenv(RecordEnv, "")
+	err = RecordingForTest(filepath.Join(dir, "missing"))
+	common.Assert(t, errors.Is(err, ErrNoFixtures), "Expected ErrNoFixtures, got %v", err)
+	t.Setenv(RecordEnv, LiveMode)
+	common.Assert(t, envRecordMode() == RecordOff, "Expected live to be off")
+	err = RecordingForTest(filepath.Join(dir, "missing"))
+	mode, _ := Recording()
+	common.Assert(t, err == nil && mode == RecordOff, "Unexpected live mode %s, %v", mode, err)
+}
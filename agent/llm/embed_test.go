@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/agent/chunker"
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestEmbedInput(t *testing.T) {
+	ea := NewEmbedder(DefaultEmbedModel).(*embedder)
+
+	items, err := ea.parseEmbedInput([]byte(`{"data": [{"num1": 1, "num2": 2, "title": "t", "text": "hello"}]}`))
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(items) == 1 && items[0].text == "hello", "Unexpected items %v", items)
+
+	bs, err := ea.output(items, [][]float32{{0.5, 1}})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, string(bs) == `{"data":[{"num1":1,"num2":2,"path":"","title":"t","text":"hello","vector":[0.5,1]}]}`, "Unexpected output %s", bs)
+
+	// string mode rows, as novelChunker and dbWriter use.
+	items, err = ea.parseEmbedInput([]byte(`{"data": [["1", "2", "", "t", "hello"]]}`))
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	bs, err = ea.output(items, [][]float32{{0.5, 1}})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, string(bs) == `{"data":[["1","2","","t","hello","[0.5,1]"]]}`, "Unexpected output %s", bs)
+
+	// wikiChunker rows, content is column 3
+	ea.Config([]byte(`{"text_column": 3}`))
+	items, err = ea.parseEmbedInput([]byte(`{"data": [["Title", "title", "", "content"]]}`))
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, items[0].text == "content", "Unexpected text %s", items[0].text)
+
+	// rows and chunks cannot be mixed in a batch.
+	_, err = ea.parseEmbedInput([]byte(`{"data": [["Title", "title", "", "content"], {"text": "hello"}]}`))
+	common.Assert(t, err != nil, "Expected mixed input error")
+	_, err = ea.parseEmbedInput([]byte(`{"data": [{"text": "hello"}, ["Title", "title", "", "content"]]}`))
+	common.Assert(t, err != nil, "Expected mixed input error")
+}
+
+func TestEmbedNovel(t *testing.T) {
+	useFixtures(t)
+	book := "file://" + common.ProjectPath("data", "AnimalFarm.txt")
+	stra := agent.NewStringArrayAgent([]string{
+		`{"data": {"url": "` + book + `"}}`,
+	})
+
+	ca := chunker.NewNovelChunker()
+	ea := NewEmbedder(DefaultEmbedModel)
+
+	var pipe agent.AgentPipe
+	pipe.AddAgent(stra)
+	pipe.AddAgent(ca)
+	pipe.AddAgent(ea)
+	defer pipe.Close()
+
+	it, err := pipe.Execute(nil, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	nbatch := 0
+	for data, err := range it {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		var output EmbedOutput
+		err = json.Unmarshal(data, &output)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		common.PanicAssert(t, len(output.Data) > 0, "Expected chunks in batch %d", nbatch)
+		t.Logf("Batch %d: %d chunks, dim %d", nbatch, len(output.Data), len(output.Data[0].Vector))
+		nbatch++
+		if nbatch >= 3 {
+			break
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package llm
+
+//
+// Self-consistency: sample a question several times, across temperatures
+// or models, and vote on the normalized answers.
+//
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/matrixorigin/monlp/textu/normalize"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	DefaultSamples = 5
+
+	VoteMajority = "majority"
+	VoteJudge    = "judge"
+)
+
+// Sample is one sampling of the ensemble, unset fields use the chat
+// config.
+type Sample struct {
+	Model   string       `json:"model"`
+	Options *ChatOptions `json:"options"`
+}
+
+// SampleOptions returns the options of n samples, the first at
+// common.LLMTemp, the others at increasing temperature, each with its
+// own seed so that the samples are reproducible.
+func SampleOptions(n int) []*ChatOptions {
+	opts := make([]*ChatOptions, n)
+	for i := range opts {
+		temp := common.LLMTemp
+		if i > 0 {
+			temp = min(common.LLMTemp+0.3*float64(i), 1.2)
+		}
+		seed := i
+		opts[i] = &ChatOptions{Temperature: &temp, Seed: &seed}
+	}
+	return opts
+}
+
+// Vote is an answer and the samples that agree on it.
+type Vote struct {
+	Answer string   `json:"answer"` // first answer as given
+	Norm   string   `json:"norm"`   // normalized answer
+	Count  int      `json:"count"`
+	Models []string `json:"models"`
+}
+
+// Tally groups answers by their normalized form, votes are ordered by
+// count, ties by first occurrence.   Empty answers are not counted.
+func Tally(answers, models []string) []Vote {
+	var votes []Vote
+	idx := make(map[string]int)
+	for i, a := range answers {
+		norm := normalize.Answer(a)
+		if norm == "" {
+			continue
+		}
+		vi, ok := idx[norm]
+		if !ok {
+			vi = len(votes)
+			idx[norm] = vi
+			votes = append(votes, Vote{Answer: a, Norm: norm})
+		}
+		votes[vi].Count++
+		if i < len(models) {
+			votes[vi].Models = append(votes[vi].Models, models[i])
+		}
+	}
+
+	// stable insertion sort, there are only a few votes.
+	for i := 1; i < len(votes); i++ {
+		for j := i; j > 0 && votes[j].Count > votes[j-1].Count; j-- {
+			votes[j], votes[j-1] = votes[j-1], votes[j]
+		}
+	}
+	return votes
+}
+
+// Majority returns the most voted answer and the fraction of the n
+// samples voting for it.
+func Majority(votes []Vote, n int) (string, float64) {
+	if len(votes) == 0 || n == 0 {
+		return "", 0
+	}
+	return votes[0].Answer, float64(votes[0].Count) / float64(n)
+}
+
+type EnsembleConfig struct {
+	// Chat is the chat config of the samples.
+	Chat ChatConfig `json:"chat"`
+	// Samples to run, default is DefaultSamples of Chat.Model, see
+	// SampleOptions.
+	Samples []Sample `json:"samples"`
+	// JudgeModel, if set, selects among the answers when there is no
+	// strict majority.
+	JudgeModel string `json:"judge_model"`
+}
+
+type EnsembleOutput struct {
+	ChatOutput
+	// Confidence is the fraction of all samples, failed ones included,
+	// voting for the answer.
+	Confidence float64 `json:"confidence"`
+	Method     string  `json:"method"`
+	Votes      []Vote  `json:"votes"`
+	// Failed is the number of failed samples.
+	Failed int `json:"failed,omitempty"`
+}
+
+type ensemble struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf     EnsembleConfig
+	chatters map[string]*chatter
+	// transcript, if set, records the exchanges of the inner chatters.
+	transcript Recorder
+}
+
+// NewEnsemble creates an ensemble agent of n samples of model.  It takes
+// a ChatInput and yields an EnsembleOutput, which is also a ChatOutput.
+func NewEnsemble(model, sysprompt string, n int) agent.Agent {
+	ea := &ensemble{}
+	ea.conf.Chat.Model = model
+	ea.conf.Chat.SystemPrompt = api.Message{Role: "system", Content: sysprompt}
+	ea.conf.Chat.MaxRepair = DefaultMaxRepair
+	for _, opts := range SampleOptions(n) {
+		ea.conf.Samples = append(ea.conf.Samples, Sample{Options: opts})
+	}
+	ea.chatters = make(map[string]*chatter)
+	ea.Self = ea
+	return ea
+}
+
+func (e *ensemble) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &e.conf)
+	if err != nil {
+		return err
+	}
+	if err = e.conf.Chat.Options.Validate(); err != nil {
+		return err
+	}
+	if len(e.conf.Samples) == 0 {
+		for _, opts := range SampleOptions(DefaultSamples) {
+			e.conf.Samples = append(e.conf.Samples, Sample{Options: opts})
+		}
+	}
+	for _, s := range e.conf.Samples {
+		if s.Options == nil {
+			continue
+		}
+		if err = s.Options.Validate(); err != nil {
+			return err
+		}
+	}
+	e.chatters = make(map[string]*chatter)
+	return nil
+}
+
+func (e *ensemble) SetValue(name string, value any) error {
+	switch name {
+	case "model":
+		e.conf.Chat.Model = value.(string)
+	case "samples":
+		e.conf.Samples = value.([]Sample)
+	case "judge_model":
+		e.conf.JudgeModel = value.(string)
+	case "transcript":
+		rec, ok := value.(Recorder)
+		if !ok {
+			return fmt.Errorf("invalid transcript %T", value)
+		}
+		e.transcript = rec
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	e.chatters = make(map[string]*chatter)
+	return nil
+}
+
+func (e *ensemble) chatter(model string) (*chatter, error) {
+	if ca, ok := e.chatters[model]; ok {
+		return ca, nil
+	}
+
+	ca := &chatter{conf: e.conf.Chat}
+	ca.conf.Model = model
+	ca.transcript = e.transcript
+	ca.Self = ca
+	if err := ca.renderSystemPrompt(); err != nil {
+		return nil, err
+	}
+	ca.buildRequest()
+	e.chatters[model] = ca
+	return ca, nil
+}
+
+// sample runs one sample, and returns its response.
+func (e *ensemble) sample(s Sample, input ChatInput) (api.ChatResponse, error) {
+	model := s.Model
+	if model == "" {
+		model = e.conf.Chat.Model
+	}
+	ca, err := e.chatter(model)
+	if err != nil {
+		return api.ChatResponse{}, err
+	}
+
+	opts := ChatOptions{}.Merge(s.Options).Merge(input.Options)
+	input.Options = &opts
+	bs, err := json.Marshal(input)
+	if err != nil {
+		return api.ChatResponse{}, err
+	}
+
+	var output ChatOutput
+	var yieldErr error
+	err = ca.ExecuteOne(bs, nil, func(out []byte, err error) bool {
+		if yieldErr = err; err == nil {
+			yieldErr = json.Unmarshal(out, &output)
+		}
+		return true
+	})
+	if err == nil {
+		err = yieldErr
+	}
+	return output.Response, err
+}
+
+func (e *ensemble) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var chatInput ChatInput
+	err := json.Unmarshal(input, &chatInput)
+	if err != nil {
+		return err
+	}
+
+	// a failed sample does not vote, the ensemble fails only if all
+	// samples failed.
+	var resps []api.ChatResponse
+	var answers, models []string
+	var sampleErr error
+	for i, s := range e.conf.Samples {
+		resp, err := e.sample(s, chatInput)
+		if err != nil {
+			slog.Warn("Ensemble sample failed", "sample", i, "model", s.Model, "err", err)
+			sampleErr = fmt.Errorf("ensemble sample %d: %w", i, err)
+			continue
+		}
+		resps = append(resps, resp)
+		answers = append(answers, StripThink(resp.Message.Content))
+		models = append(models, resp.Model)
+	}
+	if len(answers) == 0 {
+		if sampleErr == nil {
+			sampleErr = fmt.Errorf("ensemble has no samples")
+		}
+		return sampleErr
+	}
+
+	var output EnsembleOutput
+	output.Failed = len(e.conf.Samples) - len(answers)
+	output.Votes = Tally(answers, models)
+	answer, conf := Majority(output.Votes, len(e.conf.Samples))
+	output.Method = VoteMajority
+
+	if e.conf.JudgeModel != "" && len(output.Votes) > 1 && output.Votes[0].Count*2 <= len(answers) {
+		question := ""
+		for _, msg := range chatInput.Messages {
+			if msg.Role == "user" {
+				question = msg.Content
+			}
+		}
+		if vi, err := SelectAnswer(e.conf.JudgeModel, question, output.Votes); err != nil {
+			slog.Warn("Ensemble judge failed, use majority", "model", e.conf.JudgeModel, "err", err)
+		} else {
+			answer = output.Votes[vi].Answer
+			conf = float64(output.Votes[vi].Count) / float64(len(e.conf.Samples))
+			output.Method = VoteJudge
+		}
+	}
+	output.Confidence = conf
+
+	// the response of the first sample agreeing with the answer.
+	for i, a := range answers {
+		if a == answer {
+			output.Response = resps[i]
+			output.Response.Message.Content = a
+			break
+		}
+	}
+
+	slog.Debug("Ensemble answered", "answer", answer, "confidence", conf, "method", output.Method, "votes", len(output.Votes))
+	bs, err := json.Marshal(output)
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
+
+// SelectAnswer asks model to pick the best of the voted answers to
+// question, it returns the index of the picked vote.
+func SelectAnswer(model, question string, votes []Vote) (int, error) {
+	prompts, err := DefaultPrompts()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := &strings.Builder{}
+	for i, v := range votes {
+		fmt.Fprintf(buf, "%d. %s (%d votes)\n", i+1, v.Answer, v.Count)
+	}
+	content, err := prompts.Render("ensemble.select", map[string]any{
+		"Question":   question,
+		"Candidates": buf.String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var pick struct {
+		Choice int    `json:"choice"`
+		Reason string `json:"reason"`
+	}
+	sc, err := NewStructuredChat(pick)
+	if err != nil {
+		return 0, err
+	}
+	req := api.ChatRequest{
+		Model:    model,
+		Stream:   new(bool),
+		Messages: []api.Message{{Role: "user", Content: content}},
+		Options:  (&ChatOptions{}).Map(),
+	}
+	if _, err = sc.Chat(context.Background(), &req, &pick); err != nil {
+		return 0, err
+	}
+	if pick.Choice < 1 || pick.Choice > len(votes) {
+		return 0, fmt.Errorf("choice %d out of range", pick.Choice)
+	}
+	return pick.Choice - 1, nil
+}
@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+func TestTally(t *testing.T) {
+	answers := []string{"Jason.", "Heracles", "the jason", "", "JASON", "Heracles"}
+	models := []string{"a", "b", "c", "d", "e", "f"}
+	votes := Tally(answers, models)
+	common.Assert(t, len(votes) == 2, "Expected 2 votes, got %v", votes)
+	common.Assert(t, votes[0].Answer == "Jason." && votes[0].Count == 3, "Unexpected vote %v", votes[0])
+	common.Assert(t, len(votes[0].Models) == 3 && votes[0].Models[2] == "e", "Unexpected models %v", votes[0].Models)
+
+	answer, conf := Majority(votes, len(answers))
+	common.Assert(t, answer == "Jason." && conf == 0.5, "Unexpected majority %s %v", answer, conf)
+
+	// ties are broken by first occurrence.
+	votes = Tally([]string{"b", "a", "a", "b"}, nil)
+	common.Assert(t, votes[0].Norm == "b", "Unexpected tie break %v", votes)
+
+	answer, conf = Majority(nil, 0)
+	common.Assert(t, answer == "" && conf == 0, "Unexpected majority of nothing")
+
+	opts := SampleOptions(3)
+	common.Assert(t, len(opts) == 3 && *opts[0].Temperature == common.LLMTemp, "Unexpected sample options")
+	for i, o := range opts {
+		common.Assert(t, *o.Seed == i && o.Validate() == nil, "Unexpected sample options %d", i)
+	}
+}
+
+func TestEnsembleChat(t *testing.T) {
+	useFixtures(t)
+	qs := ChatInput{
+		Messages: []api.Message{
+			{Role: "user", Content: "Who was the leader of the Argonauts?"},
+		},
+	}
+	qss, err := json.Marshal(&qs)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	ea := NewEnsemble(DefaultModel, "You are a helpful assistant.  You should answer each question in one word.", 3)
+	ea.SetValue("judge_model", DefaultModel)
+
+	var pipe agent.AgentPipe
+	pipe.AddAgent(agent.NewStringArrayAgent([]string{string(qss)}))
+	pipe.AddAgent(ea)
+	defer pipe.Close()
+
+	it, err := pipe.Execute(nil, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	for data, err := range it {
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		var out EnsembleOutput
+		err = json.Unmarshal(data, &out)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, out.Confidence > 0 && out.Confidence <= 1, "Unexpected confidence %v", out.Confidence)
+		t.Logf("Answer %s, confidence %.2f by %s, votes %v", out.Response.Message.Content, out.Confidence, out.Method, out.Votes)
+	}
+}
+
+func TestEnsembleSampleFailure(t *testing.T) {
+	// a fake ollama server, the sample of seed 1 fails.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if seed, _ := req.Options["seed"].(float64); seed == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "sample failed"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(api.ChatResponse{
+			Model:   req.Model,
+			Message: api.Message{Role: "assistant", Content: "Jason"},
+			Done:    true,
+		})
+	}))
+	defer srv.Close()
+	t.Setenv("OLLAMA_HOST", srv.URL)
+	err := SetRecording(RecordOff, "")
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	input := []byte(`{"messages": [{"role": "user", "content": "Who was the leader of the Argonauts?"}]}`)
+	var out EnsembleOutput
+	ea := NewEnsemble(DefaultModel, "Answer in one word.", 3).(*ensemble)
+	err = ea.ExecuteOne(input, nil, func(bs []byte, err error) bool {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		common.PanicAssert(t, json.Unmarshal(bs, &out) == nil, "Unmarshal failed")
+		return true
+	})
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, out.Response.Message.Content == "Jason", "Unexpected answer %s", out.Response.Message.Content)
+	common.Assert(t, len(out.Votes) == 1 && out.Votes[0].Count == 2, "Unexpected votes %v", out.Votes)
+	// the failed sample counts against the confidence.
+	common.Assert(t, out.Failed == 1 && out.Confidence == 2.0/3, "Unexpected failed %d, confidence %v", out.Failed, out.Confidence)
+
+	// all samples fail.
+	ea.SetValue("samples", []Sample{{Options: SampleOptions(2)[1]}})
+	err = ea.ExecuteOne(input, nil, func([]byte, error) bool { return true })
+	common.Assert(t, err != nil, "Expected error when all samples fail")
+}
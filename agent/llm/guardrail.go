@@ -0,0 +1,265 @@
+package llm
+
+//
+// A guardrail agent, wraps a chat agent, redacts PII in prompts and
+// completions and enforces a blocklist.   What is redacted or blocked
+// is recorded in the desc of the output record.
+//
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/textu/redact"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	BlockReject = "reject" // do not ask the model, or drop its answer
+	BlockMask   = "mask"   // mask the blocked terms and go on
+
+	GuardPrompt     = "prompt"
+	GuardCompletion = "completion"
+
+	DefaultRefusal = "Sorry, I cannot help with this request."
+)
+
+type GuardrailConfig struct {
+	// Kinds of the built-in PII rules to apply, empty is all.
+	Kinds []string `json:"kinds"`
+	// Dictionary maps a kind to terms redacted as that kind, for
+	// example person names.
+	Dictionary map[string][]string `json:"dictionary"`
+	// Blocklist are terms not allowed in prompts or completions.
+	Blocklist []string `json:"blocklist"`
+	// Block is what to do with a blocked record, reject or mask.
+	Block string `json:"block"`
+	// Refusal is the answer of a rejected record.
+	Refusal        string `json:"refusal"`
+	SkipPrompt     bool   `json:"skip_prompt"`
+	SkipCompletion bool   `json:"skip_completion"`
+}
+
+// GuardEvent is a redaction in a prompt message or the completion.
+type GuardEvent struct {
+	Stage   string `json:"stage"`
+	Message int    `json:"message"` // index of the prompt message
+	redact.Redaction
+}
+
+// GuardDesc is recorded as "guardrail" in the desc of output records.
+type GuardDesc struct {
+	Redactions []GuardEvent `json:"redactions"`
+	Blocked    []string     `json:"blocked,omitempty"`
+	Rejected   bool         `json:"rejected,omitempty"`
+}
+
+type guardrail struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf     GuardrailConfig
+	inner    agent.Agent
+	redactor *redact.Redactor
+	blocker  *redact.Redactor
+}
+
+// NewGuardrail wraps inner, an agent taking ChatInput and yielding
+// ChatOutput, with PII redaction using the built-in rules.
+func NewGuardrail(inner agent.Agent) agent.Agent {
+	ga := &guardrail{inner: inner}
+	ga.conf.Block = BlockReject
+	ga.conf.Refusal = DefaultRefusal
+	ga.Self = ga
+	ga.build()
+	return ga
+}
+
+func (g *guardrail) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &g.conf)
+	if err != nil {
+		return err
+	}
+	switch g.conf.Block {
+	case "":
+		g.conf.Block = BlockReject
+	case BlockReject, BlockMask:
+	default:
+		return fmt.Errorf("invalid block action: %s", g.conf.Block)
+	}
+	return g.build()
+}
+
+func (g *guardrail) build() error {
+	g.redactor = &redact.Redactor{}
+	for _, rule := range redact.DefaultRules {
+		if len(g.conf.Kinds) == 0 || slices.Contains(g.conf.Kinds, rule.Kind) {
+			g.redactor.Rules = append(g.redactor.Rules, rule)
+		}
+	}
+	// earlier rules win overlapping matches, add the dictionary kinds
+	// in a stable order.
+	kinds := slices.Sorted(maps.Keys(g.conf.Dictionary))
+	for _, kind := range kinds {
+		g.redactor.Rules = append(g.redactor.Rules, redact.Dictionary(kind, g.conf.Dictionary[kind]))
+	}
+	g.blocker = &redact.Redactor{Rules: []redact.Rule{redact.Dictionary("blocked", g.conf.Blocklist)}}
+	return nil
+}
+
+func (g *guardrail) SetValue(name string, value any) error {
+	switch name {
+	case "blocklist":
+		g.conf.Blocklist = value.([]string)
+	case "dictionary":
+		g.conf.Dictionary = value.(map[string][]string)
+	case "block":
+		g.conf.Block = value.(string)
+	default:
+		// pass on to the guarded agent.
+		return g.inner.SetValue(name, value)
+	}
+	return g.build()
+}
+
+func (g *guardrail) Close() error {
+	return g.inner.Close()
+}
+
+// guard redacts and checks the blocklist of s, it returns the new text
+// and if it is blocked.
+func (g *guardrail) guard(desc *GuardDesc, stage string, msg int, s string, skip bool) (string, bool) {
+	if !skip {
+		var found []redact.Redaction
+		s, found = g.redactor.Redact(s)
+		for _, f := range found {
+			desc.Redactions = append(desc.Redactions, GuardEvent{Stage: stage, Message: msg, Redaction: f})
+		}
+	}
+
+	blocked := g.blocker.Find(s)
+	for _, b := range blocked {
+		desc.Blocked = append(desc.Blocked, s[b.Offset:b.Offset+b.Length])
+	}
+	if len(blocked) > 0 && g.conf.Block == BlockMask {
+		s, _ = g.blocker.Redact(s)
+		return s, false
+	}
+	return s, len(blocked) > 0
+}
+
+// withDesc adds desc as "guardrail" to the desc of an output record.
+func withDesc(out []byte, desc *GuardDesc) ([]byte, error) {
+	var rec map[string]json.RawMessage
+	if err := json.Unmarshal(out, &rec); err != nil {
+		return nil, err
+	}
+	d := make(map[string]any)
+	if old, ok := rec["desc"]; ok {
+		if err := json.Unmarshal(old, &d); err != nil {
+			return nil, fmt.Errorf("output desc is not an object: %v", err)
+		}
+	}
+	d["guardrail"] = desc
+
+	bs, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	rec["desc"] = bs
+	return json.Marshal(rec)
+}
+
+// refuse yields the refusal answer.
+func (g *guardrail) refuse(desc *GuardDesc, yield func([]byte, error) bool) error {
+	desc.Rejected = true
+	slog.Warn("Guardrail rejected", "blocked", desc.Blocked)
+
+	var output ChatOutput
+	output.Response.Message = api.Message{Role: "assistant", Content: g.conf.Refusal}
+	output.Response.Done = true
+	bs, err := json.Marshal(output)
+	if err == nil {
+		bs, err = withDesc(bs, desc)
+	}
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
+
+func (g *guardrail) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var chatInput ChatInput
+	err := json.Unmarshal(input, &chatInput)
+	if err != nil {
+		return err
+	}
+
+	desc := &GuardDesc{}
+	blocked := false
+	for i := range chatInput.Messages {
+		var b bool
+		chatInput.Messages[i].Content, b = g.guard(desc, GuardPrompt, i, chatInput.Messages[i].Content, g.conf.SkipPrompt)
+		blocked = blocked || b
+	}
+	if blocked {
+		return g.refuse(desc, yield)
+	}
+
+	guarded, err := json.Marshal(chatInput)
+	if err != nil {
+		return err
+	}
+
+	var yieldErr error
+	err = g.inner.ExecuteOne(guarded, dict, func(out []byte, err error) bool {
+		if err != nil {
+			return yield(nil, err)
+		}
+
+		var output ChatOutput
+		if err = json.Unmarshal(out, &output); err != nil {
+			return yield(nil, err)
+		}
+
+		// each output record has its own completion redactions.
+		odesc := &GuardDesc{Redactions: slices.Clone(desc.Redactions), Blocked: slices.Clone(desc.Blocked)}
+		content, b := g.guard(odesc, GuardCompletion, 0, output.Response.Message.Content, g.conf.SkipCompletion)
+		if b {
+			odesc.Rejected = true
+			content = g.conf.Refusal
+			slog.Warn("Guardrail rejected completion", "blocked", odesc.Blocked)
+		}
+
+		// replace the completion, keeping the other fields of the record.
+		var rec map[string]json.RawMessage
+		if err = json.Unmarshal(out, &rec); err != nil {
+			return yield(nil, err)
+		}
+		output.Response.Message.Content = content
+		if rec["response"], err = json.Marshal(output.Response); err != nil {
+			return yield(nil, err)
+		}
+		if out, err = json.Marshal(rec); err == nil {
+			out, err = withDesc(out, odesc)
+		}
+		if !yield(out, err) {
+			yieldErr = agent.ErrYieldDone
+			return false
+		}
+		return true
+	})
+	if err == nil {
+		err = yieldErr
+	}
+	return err
+}
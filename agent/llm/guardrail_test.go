@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+// echoAgent answers with the last prompt message, it stands in for the
+// chat agent.
+type echoAgent struct {
+	agent.NilKVAgent
+	agent.NilConfigAgent
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	prompts []string
+}
+
+func (e *echoAgent) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	var ci ChatInput
+	if err := json.Unmarshal(input, &ci); err != nil {
+		return err
+	}
+	last := ci.Messages[len(ci.Messages)-1].Content
+	e.prompts = append(e.prompts, last)
+
+	var out ChatOutput
+	out.Response.Model = "echo"
+	out.Response.Message = api.Message{Role: "assistant", Content: "you said: " + last + ", write to admin@example.com"}
+	bs, err := json.Marshal(out)
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
+
+type guardedOutput struct {
+	ChatOutput
+	Desc struct {
+		Guardrail GuardDesc `json:"guardrail"`
+	} `json:"desc"`
+}
+
+func guardAsk(t *testing.T, ga agent.Agent, q string) guardedOutput {
+	input, _ := json.Marshal(ChatInput{Messages: []api.Message{{Role: "user", Content: q}}})
+	var out guardedOutput
+	err := ga.ExecuteOne(input, nil, func(bs []byte, err error) bool {
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		err = json.Unmarshal(bs, &out)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		return true
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	return out
+}
+
+func TestGuardrail(t *testing.T) {
+	echo := &echoAgent{}
+	echo.Self = echo
+	ga := NewGuardrail(echo)
+	err := ga.Config([]byte(`{"dictionary": {"name": ["贾宝玉"]}, "blocklist": ["forbidden city"]}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	out := guardAsk(t, ga, "贾宝玉的电话是13812345678")
+	common.Assert(t, echo.prompts[0] == "[NAME]的电话是[PHONE]", "Prompt not redacted: %s", echo.prompts[0])
+	content := out.Response.Message.Content
+	common.Assert(t, content == "you said: [NAME]的电话是[PHONE], write to [EMAIL]", "Completion not redacted: %s", content)
+	common.Assert(t, out.Response.Model == "echo", "Lost response fields %v", out.Response)
+
+	reds := out.Desc.Guardrail.Redactions
+	common.Assert(t, len(reds) == 3, "Expected 3 redactions, got %v", reds)
+	common.Assert(t, reds[0].Stage == GuardPrompt && reds[0].Kind == "name" && reds[1].Kind == "phone", "Unexpected redactions %v", reds)
+	common.Assert(t, reds[2].Stage == GuardCompletion && reds[2].Kind == "email", "Unexpected redactions %v", reds)
+
+	// blocked prompt is rejected without asking the model.
+	out = guardAsk(t, ga, "Tell me about the Forbidden City")
+	common.Assert(t, len(echo.prompts) == 1, "Blocked prompt reached the model")
+	common.Assert(t, out.Response.Message.Content == DefaultRefusal && out.Desc.Guardrail.Rejected, "Unexpected output %v", out)
+	common.Assert(t, len(out.Desc.Guardrail.Blocked) == 1 && out.Desc.Guardrail.Blocked[0] == "Forbidden City", "Unexpected blocked %v", out.Desc.Guardrail.Blocked)
+
+	// or masked.
+	err = ga.SetValue("block", BlockMask)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	out = guardAsk(t, ga, "Tell me about the Forbidden City")
+	common.Assert(t, strings.HasSuffix(echo.prompts[1], "the [BLOCKED]"), "Blocked term not masked: %s", echo.prompts[1])
+	common.Assert(t, !out.Desc.Guardrail.Rejected, "Unexpected rejection")
+
+	err = ga.Config([]byte(`{"block": "ignore"}`))
+	common.Assert(t, err != nil, "Expected invalid block action error")
+
+	// overlapping terms go to the first kind, by name, every time.
+	for i := 0; i < 20; i++ {
+		ga = NewGuardrail(echo)
+		err = ga.Config([]byte(`{"dictionary": {"surname": ["贾宝"], "person": ["贾宝玉"], "place": ["宝玉"]}}`))
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		guardAsk(t, ga, "贾宝玉")
+		common.Assert(t, echo.prompts[len(echo.prompts)-1] == "[PERSON]", "Unexpected redaction %s", echo.prompts[len(echo.prompts)-1])
+	}
+}
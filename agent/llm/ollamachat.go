@@ -7,6 +7,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/matrixorigin/monlp/agent"
 	"github.com/ollama/ollama/api"
@@ -17,6 +19,15 @@ const DefaultModel = "qwen2.5:14b"
 
 type ChatInput struct {
 	Messages []api.Message `json:"messages"`
+	// Images are image references, file:// urls, data urls or base64,
+	// attached to the last user message.  A vision model is required.
+	Images []string `json:"images,omitempty"`
+	// Options override the configured generation options for this
+	// record only.
+	Options *ChatOptions `json:"options,omitempty"`
+	// Tag is the task tag of the record, used by the router to pick
+	// a model.
+	Tag string `json:"tag,omitempty"`
 }
 
 type ChatOutput struct {
@@ -28,16 +39,36 @@ type ChatConfig struct {
 	SystemPrompt api.Message     `json:"system_prompt"`
 	Format       json.RawMessage `json:"format"`
 	Tools        api.Tools       `json:"tools"`
+	// MaxRepair is the number of times to re-prompt the model when
+	// the response does not conform to Format.
+	MaxRepair int `json:"max_repair"`
+	// SystemTemplate, if set, is the name of a prompt template in the
+	// default prompt registry, rendered with TemplateVars into the
+	// system prompt.
+	SystemTemplate string         `json:"system_template"`
+	TemplateVars   map[string]any `json:"template_vars"`
+	// Options are the generation options, temperature defaults to
+	// common.LLMTemp.
+	Options ChatOptions `json:"options"`
 }
 
 type LLMFunctionCall func(api.ToolCallFunction) (string, error)
 
+// Recorder records the exchanges of a chat, set as the "transcript"
+// value of a chat agent.  A dbagent.Session is a Recorder.  Messages and
+// toolCalls are json, as sent to and returned by the model.
+type Recorder interface {
+	RecordChat(question, answer, model string, messages, toolCalls []byte, latency time.Duration, chatErr error) error
+}
+
 type chatter struct {
 	agent.NilCloseAgent
 	agent.SimpleExecuteAgent
 	conf     ChatConfig
 	req      api.ChatRequest
 	toolcall LLMFunctionCall
+	// transcript, if set, records every exchange.
+	transcript Recorder
 }
 
 func NewChatWithPrompt(model, sysprompt string, tc LLMFunctionCall) agent.Agent {
@@ -45,20 +76,64 @@ func NewChatWithPrompt(model, sysprompt string, tc LLMFunctionCall) agent.Agent
 
 	ca.conf.Model = model
 	ca.conf.SystemPrompt = api.Message{Role: "system", Content: sysprompt}
+	ca.conf.MaxRepair = DefaultMaxRepair
 	ca.toolcall = tc
 	ca.Self = ca
 	ca.buildRequest()
 	return ca
 }
 
+// NewChatWithTemplate creates a chat agent whose system prompt is the
+// template tmpl in the default prompt registry.
+func NewChatWithTemplate(model, tmpl string, vars map[string]any, tc LLMFunctionCall) (agent.Agent, error) {
+	ca := &chatter{}
+
+	ca.conf.Model = model
+	ca.conf.MaxRepair = DefaultMaxRepair
+	ca.conf.SystemTemplate = tmpl
+	ca.conf.TemplateVars = vars
+	ca.toolcall = tc
+	ca.Self = ca
+	if err := ca.renderSystemPrompt(); err != nil {
+		return nil, err
+	}
+	ca.buildRequest()
+	return ca, nil
+}
+
 func (c *chatter) Config(bs []byte) error {
 	// unmarshal config
 	if bs == nil {
 		return nil
 	}
 	err := json.Unmarshal(bs, &c.conf)
+	if err != nil {
+		return err
+	}
+	if err = c.conf.Options.Validate(); err != nil {
+		return err
+	}
+	if err = c.renderSystemPrompt(); err != nil {
+		return err
+	}
 	c.buildRequest()
-	return err
+	return nil
+}
+
+func (c *chatter) renderSystemPrompt() error {
+	if c.conf.SystemTemplate == "" {
+		return nil
+	}
+	prompts, err := DefaultPrompts()
+	if err != nil {
+		return err
+	}
+	content, err := prompts.Render(c.conf.SystemTemplate, c.conf.TemplateVars)
+	if err != nil {
+		return err
+	}
+	c.conf.SystemPrompt = api.Message{Role: "system", Content: content}
+	return nil
 }
 
 func (c *chatter) SetValue(name string, value any) error {
@@ -81,6 +156,21 @@ func (c *chatter) SetValue(name string, value any) error {
 		c.conf.Tools = value.(api.Tools)
 		c.req.Tools = c.conf.Tools
 		return nil
+	} else if name == "transcript" {
+		rec, ok := value.(Recorder)
+		if !ok {
+			return fmt.Errorf("invalid transcript %T", value)
+		}
+		c.transcript = rec
+		return nil
+	} else if name == "options" {
+		opts := value.(ChatOptions)
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+		c.conf.Options = opts
+		c.buildRequest()
+		return nil
 	}
 
 	return fmt.Errorf("unknown name: %s", name)
@@ -88,14 +178,13 @@ func (c *chatter) SetValue(name string, value any) error {
 
 func (c *chatter) buildRequest() {
 	c.req = api.ChatRequest{
-		Model:    c.conf.Model,
-		Messages: nil,
-		Stream:   new(bool), // stream response default to false
-		Format:   c.conf.Format,
-		Tools:    c.conf.Tools,
-		Options: map[string]interface{}{
-			"temperature": 0.0,
-		},
+		Model:     c.conf.Model,
+		Messages:  nil,
+		Stream:    new(bool), // stream response default to false
+		Format:    c.conf.Format,
+		Tools:     c.conf.Tools,
+		Options:   c.conf.Options.Map(),
+		KeepAlive: c.conf.Options.KeepAlive,
 	}
 }
 
@@ -110,7 +199,12 @@ func (c *chatter) ExecuteOne(input []byte, dict map[string]string, yield func([]
 		return err
 	}
 
-	client, err := api.ClientFromEnvironment()
+	opts := c.conf.Options.Merge(chatInput.Options)
+	if err = opts.Apply(&c.req); err != nil {
+		return err
+	}
+
+	client, err := NewClient()
 	if err != nil {
 		return err
 	}
@@ -119,14 +213,22 @@ func (c *chatter) ExecuteOne(input []byte, dict map[string]string, yield func([]
 		c.conf.SystemPrompt,
 	}
 	c.req.Messages = append(c.req.Messages, chatInput.Messages...)
+	if len(chatInput.Images) > 0 {
+		if err = AttachImages(c.req.Messages, chatInput.Images); err != nil {
+			return err
+		}
+	}
 
 	ctx := context.Background()
+	start := time.Now()
 
 	var output ChatOutput
+	var toolCalls []api.ToolCall
 
-	for output.Response.Model == "" {
+	for round := 0; output.Response.Model == ""; {
 		err = client.Chat(ctx, &c.req, func(resp api.ChatResponse) error {
 			if len(resp.Message.ToolCalls) > 0 {
+				toolCalls = append(toolCalls, resp.Message.ToolCalls...)
 				for _, tc := range resp.Message.ToolCalls {
 					if c.toolcall == nil {
 						return fmt.Errorf("toolcall function is not set")
@@ -144,13 +246,52 @@ func (c *chatter) ExecuteOne(input []byte, dict map[string]string, yield func([]
 			return nil
 		})
 		if err != nil {
+			c.record(chatInput.Messages, toolCalls, &output, start, err)
 			return err
 		}
+
+		// validate the final answer if we asked for json, re-prompt
+		// the model with the error if it is malformed.
+		if output.Response.Model != "" && len(c.conf.Format) > 0 && round < c.conf.MaxRepair {
+			if verr := DecodeJson(output.Response.Message.Content, c.conf.Format, nil); verr != nil {
+				c.req.Messages = append(c.req.Messages, output.Response.Message, repairMessage(verr, c.conf.Format))
+				output.Response = api.ChatResponse{}
+				round++
+			}
+		}
 	}
 
+	c.record(chatInput.Messages, toolCalls, &output, start, nil)
+
 	bs, err := json.Marshal(output)
 	if !yield(bs, err) {
 		return agent.ErrYieldDone
 	}
 	return nil
 }
+
+// record writes the exchange to the transcript, if set.  Failing to
+// record is logged, it does not fail the chat.
+func (c *chatter) record(msgs []api.Message, toolCalls []api.ToolCall, output *ChatOutput, start time.Time, chatErr error) {
+	if c.transcript == nil {
+		return
+	}
+
+	var question string
+	for _, m := range msgs {
+		if m.Role == "user" {
+			question = m.Content
+		}
+	}
+	messages, _ := json.Marshal(c.req.Messages)
+	var calls []byte
+	if len(toolCalls) > 0 {
+		calls, _ = json.Marshal(toolCalls)
+	}
+
+	err := c.transcript.RecordChat(question, output.Response.Message.Content, c.req.Model,
+		messages, calls, time.Since(start), chatErr)
+	if err != nil {
+		slog.Warn("chatter failed to record transcript", "model", c.req.Model, "err", err)
+	}
+}
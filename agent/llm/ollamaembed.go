@@ -0,0 +1,248 @@
+package llm
+
+//
+// An embedding agent, turns chunks into vectors.
+//
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/textu/chunk"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	DefaultEmbedModel     = "nomic-embed-text"
+	DefaultEmbedBatchSize = 32
+)
+
+type EmbedConfig struct {
+	Model     string `json:"model"`
+	BatchSize int    `json:"batch_size"`
+	// StringMode outputs [][]string rows, with the vector as the last
+	// column in vecf32 format, so that dbWriter can store them.
+	StringMode bool `json:"string_mode"`
+	// TextColumn is the column of text to embed when input rows are
+	// [][]string.   Default is 4, the text of novelChunker rows.
+	TextColumn *int `json:"text_column"`
+}
+
+// EmbedInput is either the output of novelChunker, chunk.Chunk objects
+// or string mode rows, or of wikiChunker.
+type EmbedInput struct {
+	Data []json.RawMessage `json:"data"`
+}
+
+type EmbeddedChunk struct {
+	chunk.Chunk
+	Vector []float32 `json:"vector"`
+}
+
+type EmbedOutput struct {
+	Data []EmbeddedChunk `json:"data"`
+}
+
+type EmbedStrOutput struct {
+	Data [][]string `json:"data"`
+}
+
+// embedItem is a chunk or a row, and the text to embed.
+type embedItem struct {
+	chunk *chunk.Chunk
+	row   []string
+	text  string
+}
+
+type embedder struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf EmbedConfig
+}
+
+func NewEmbedder(model string) agent.Agent {
+	ea := &embedder{}
+	ea.conf.Model = model
+	ea.conf.BatchSize = DefaultEmbedBatchSize
+	ea.Self = ea
+	return ea
+}
+
+func (e *embedder) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &e.conf)
+	if err != nil {
+		return err
+	}
+	if e.conf.BatchSize <= 0 {
+		e.conf.BatchSize = DefaultEmbedBatchSize
+	}
+	return nil
+}
+
+func (e *embedder) SetValue(name string, value any) error {
+	switch name {
+	case "model":
+		e.conf.Model = value.(string)
+	case "batch_size":
+		e.conf.BatchSize = value.(int)
+	case "string_mode":
+		e.conf.StringMode = value.(bool)
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	return nil
+}
+
+func (e *embedder) textColumn() int {
+	if e.conf.TextColumn == nil {
+		return 4
+	}
+	return *e.conf.TextColumn
+}
+
+// parseEmbedInput parses the input records into embed items.
+func (e *embedder) parseEmbedInput(input []byte) ([]embedItem, error) {
+	var embedInput EmbedInput
+	err := json.Unmarshal(input, &embedInput)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]embedItem, 0, len(embedInput.Data))
+	for _, raw := range embedInput.Data {
+		var item embedItem
+		if len(raw) > 0 && raw[0] == '[' {
+			if err = json.Unmarshal(raw, &item.row); err != nil {
+				return nil, err
+			}
+			col := e.textColumn()
+			if col < 0 || col >= len(item.row) {
+				return nil, fmt.Errorf("text column %d out of range, row has %d columns", col, len(item.row))
+			}
+			item.text = item.row[col]
+		} else {
+			item.chunk = &chunk.Chunk{}
+			if err = json.Unmarshal(raw, item.chunk); err != nil {
+				return nil, err
+			}
+			item.text = item.chunk.Text
+		}
+		if len(items) > 0 && (items[0].row == nil) != (item.row == nil) {
+			return nil, fmt.Errorf("embed input mixes rows and chunks")
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (e *embedder) embed(texts []string) ([][]float32, error) {
+	cli, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req := api.EmbedRequest{
+		Model: e.conf.Model,
+		Input: texts,
+	}
+	resp, err := cli.Embed(context.Background(), &req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embed %d texts, got %d vectors", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, nil
+}
+
+// output marshals a batch of embedded items.
+func (e *embedder) output(items []embedItem, vecs [][]float32) ([]byte, error) {
+	if e.conf.StringMode || (len(items) > 0 && items[0].row != nil) {
+		var output EmbedStrOutput
+		for i, item := range items {
+			row := item.row
+			if row == nil {
+				row = []string{
+					strconv.Itoa(int(item.chunk.Num1)),
+					strconv.Itoa(int(item.chunk.Num2)),
+					item.chunk.Path,
+					item.chunk.Title,
+					item.chunk.Text,
+				}
+			}
+			output.Data = append(output.Data, append(row, formatVec(vecs[i])))
+		}
+		return json.Marshal(output)
+	}
+
+	var output EmbedOutput
+	for i, item := range items {
+		output.Data = append(output.Data, EmbeddedChunk{Chunk: *item.chunk, Vector: vecs[i]})
+	}
+	return json.Marshal(output)
+}
+
+func (e *embedder) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	items, err := e.parseEmbedInput(input)
+	if err != nil {
+		return err
+	}
+
+	// skip empty text, for example, wiki redirect pages.
+	nonEmpty := items[:0]
+	for _, item := range items {
+		if item.text != "" {
+			nonEmpty = append(nonEmpty, item)
+		}
+	}
+	items = nonEmpty
+
+	// embed and yield batch by batch.
+	for start := 0; start < len(items); start += e.conf.BatchSize {
+		end := min(start+e.conf.BatchSize, len(items))
+		batch := items[start:end]
+
+		texts := make([]string, len(batch))
+		for i, item := range batch {
+			texts[i] = item.text
+		}
+
+		slog.Debug("Embedder embed batch", "model", e.conf.Model, "size", len(batch))
+		vecs, err := e.embed(texts)
+		if err != nil {
+			return err
+		}
+
+		bs, err := e.output(batch, vecs)
+		if !yield(bs, err) {
+			return agent.ErrYieldDone
+		}
+	}
+	return nil
+}
+
+// formatVec formats a vector as a vecf32 literal, as dbagent.FormatVecf32
+// does, llm does not depend on dbagent.
+func formatVec(v []float32) string {
+	sb := &strings.Builder{}
+	sb.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
@@ -0,0 +1,251 @@
+package llm
+
+//
+// Image input for vision models, and an agent that captions or OCRs
+// document images into text chunks.
+//
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/textu/chunk"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	DefaultVisionModel = "llama3.2-vision"
+
+	VisionCaption = "caption" // describe the image
+	VisionOCR     = "ocr"     // transcribe the text in the image
+)
+
+// LoadImage loads an image reference, a file:// url, a data url
+// (data:image/png;base64,...) or plain base64.
+func LoadImage(ref string) (api.ImageData, error) {
+	switch {
+	case strings.HasPrefix(ref, "file://"):
+		return os.ReadFile(ref[7:])
+	case strings.HasPrefix(ref, "data:"):
+		meta, data, ok := strings.Cut(ref[5:], ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil, fmt.Errorf("invalid image data url: %.32s", ref)
+		}
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %.32s: %v", ref, err)
+	}
+	return bs, nil
+}
+
+// AttachImages loads the images and attaches them to the last user
+// message in msgs.
+func AttachImages(msgs []api.Message, refs []string) error {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role != "user" {
+			continue
+		}
+		for _, ref := range refs {
+			img, err := LoadImage(ref)
+			if err != nil {
+				return err
+			}
+			msgs[i].Images = append(msgs[i].Images, img)
+		}
+		return nil
+	}
+	return fmt.Errorf("no user message to attach images to")
+}
+
+type VisionConfig struct {
+	Model string `json:"model"`
+	// Mode is caption or ocr.
+	Mode string `json:"mode"`
+	// Prompt overrides the prompt template of the mode.
+	Prompt     string      `json:"prompt"`
+	StringMode bool        `json:"string_mode"`
+	Options    ChatOptions `json:"options"`
+}
+
+// VisionInput is a list of image references.
+type VisionInput struct {
+	Data []string `json:"data"`
+}
+
+type VisionOutput struct {
+	Data []chunk.Chunk `json:"data"`
+}
+
+type VisionStrOutput struct {
+	Data [][]string `json:"data"`
+}
+
+type visioner struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf VisionConfig
+}
+
+// NewImageCaptioner creates an agent that turns each image into chunks.
+// In caption mode an image is one chunk, in ocr mode the transcribed
+// text is chunked by paragraph.   Num1 is the sequence number of the
+// image, Path is the image reference if it is a url.
+func NewImageCaptioner(model, mode string) agent.Agent {
+	va := &visioner{}
+	va.conf.Model = model
+	va.conf.Mode = mode
+	va.Self = va
+	return va
+}
+
+func (v *visioner) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &v.conf)
+	if err != nil {
+		return err
+	}
+	return v.conf.Options.Validate()
+}
+
+func (v *visioner) SetValue(name string, value any) error {
+	switch name {
+	case "model":
+		v.conf.Model = value.(string)
+	case "mode":
+		v.conf.Mode = value.(string)
+	case "prompt":
+		v.conf.Prompt = value.(string)
+	case "string_mode":
+		v.conf.StringMode = value.(bool)
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	return nil
+}
+
+func (v *visioner) prompt() (string, error) {
+	if v.conf.Prompt != "" {
+		return v.conf.Prompt, nil
+	}
+	switch v.conf.Mode {
+	case VisionCaption, VisionOCR:
+	default:
+		return "", fmt.Errorf("unknown vision mode: %s", v.conf.Mode)
+	}
+	prompts, err := DefaultPrompts()
+	if err != nil {
+		return "", err
+	}
+	return prompts.Render("vision."+v.conf.Mode, nil)
+}
+
+// describe runs the vision model on one image.
+func (v *visioner) describe(cli Client, prompt string, img api.ImageData) (string, error) {
+	req := api.ChatRequest{
+		Model: v.conf.Model,
+		Messages: []api.Message{
+			{Role: "user", Content: prompt, Images: []api.ImageData{img}},
+		},
+		Stream:    new(bool),
+		Options:   v.conf.Options.Map(),
+		KeepAlive: v.conf.Options.KeepAlive,
+	}
+
+	var content string
+	err := cli.Chat(context.Background(), &req, func(resp api.ChatResponse) error {
+		content = resp.Message.Content
+		return nil
+	})
+	return StripThink(content), err
+}
+
+// imageChunks turns the text of the seq-th image into chunks.
+func (v *visioner) imageChunks(seq int, ref, text string) []chunk.Chunk {
+	path := ""
+	if strings.HasPrefix(ref, "file://") {
+		path = ref
+	}
+
+	if v.conf.Mode != VisionOCR {
+		return []chunk.Chunk{{Num1: int32(seq), Path: path, Title: v.conf.Mode, Text: text}}
+	}
+
+	var chunks []chunk.Chunk
+	ck, _ := chunk.NewNovelChunker(strings.NewReader(text))
+	for c := range ck.Chunk() {
+		c.Num1 = int32(seq)
+		c.Num2 = int32(len(chunks))
+		c.Path = path
+		c.Title = v.conf.Mode
+		c.Text = strings.TrimSpace(c.Text)
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+func (v *visioner) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var visionInput VisionInput
+	err := json.Unmarshal(input, &visionInput)
+	if err != nil {
+		return err
+	}
+
+	prompt, err := v.prompt()
+	if err != nil {
+		return err
+	}
+	cli, err := NewClient()
+	if err != nil {
+		return err
+	}
+
+	var chunks []chunk.Chunk
+	for seq, ref := range visionInput.Data {
+		img, err := LoadImage(ref)
+		if err != nil {
+			return err
+		}
+		slog.Debug("ImageCaptioner describe", "model", v.conf.Model, "mode", v.conf.Mode, "seq", seq)
+		text, err := v.describe(cli, prompt, img)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, v.imageChunks(seq, ref, text)...)
+	}
+
+	var bs []byte
+	if v.conf.StringMode {
+		var output VisionStrOutput
+		for _, c := range chunks {
+			output.Data = append(output.Data, []string{
+				strconv.Itoa(int(c.Num1)),
+				strconv.Itoa(int(c.Num2)),
+				c.Path,
+				c.Title,
+				c.Text,
+			})
+		}
+		bs, err = json.Marshal(output)
+	} else {
+		bs, err = json.Marshal(VisionOutput{Data: chunks})
+	}
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
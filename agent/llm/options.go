@@ -0,0 +1,142 @@
+package llm
+
+//
+// Model generation options of chat requests.
+//
+import (
+	"fmt"
+
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+// ChatOptions are the generation options of a chat request.   Unset
+// fields are left to the model defaults, except Temperature, which
+// defaults to common.LLMTemp.
+type ChatOptions struct {
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	NumCtx        *int     `json:"num_ctx,omitempty"`
+	NumPredict    *int     `json:"num_predict,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+	// KeepAlive is how long the model stays loaded after the request,
+	// a duration such as "5m", or seconds, negative is forever.
+	KeepAlive *api.Duration `json:"keep_alive,omitempty"`
+}
+
+// Validate checks the options are in range.
+func (o *ChatOptions) Validate() error {
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		return fmt.Errorf("temperature %v out of range [0, 2]", *o.Temperature)
+	}
+	if o.TopP != nil && (*o.TopP <= 0 || *o.TopP > 1) {
+		return fmt.Errorf("top_p %v out of range (0, 1]", *o.TopP)
+	}
+	if o.TopK != nil && *o.TopK < 0 {
+		return fmt.Errorf("top_k %d must not be negative", *o.TopK)
+	}
+	if o.MinP != nil && (*o.MinP < 0 || *o.MinP > 1) {
+		return fmt.Errorf("min_p %v out of range [0, 1]", *o.MinP)
+	}
+	if o.RepeatPenalty != nil && *o.RepeatPenalty < 0 {
+		return fmt.Errorf("repeat_penalty %v must not be negative", *o.RepeatPenalty)
+	}
+	if o.NumCtx != nil && *o.NumCtx <= 0 {
+		return fmt.Errorf("num_ctx %d must be positive", *o.NumCtx)
+	}
+	// num_predict -1 is infinite, -2 is fill the context.
+	if o.NumPredict != nil && *o.NumPredict < -2 {
+		return fmt.Errorf("num_predict %d must be at least -2", *o.NumPredict)
+	}
+	for _, s := range o.Stop {
+		if s == "" {
+			return fmt.Errorf("empty stop sequence")
+		}
+	}
+	return nil
+}
+
+// Merge returns o overridden by the fields set in over.
+func (o ChatOptions) Merge(over *ChatOptions) ChatOptions {
+	if over == nil {
+		return o
+	}
+	if over.Temperature != nil {
+		o.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		o.TopP = over.TopP
+	}
+	if over.TopK != nil {
+		o.TopK = over.TopK
+	}
+	if over.MinP != nil {
+		o.MinP = over.MinP
+	}
+	if over.RepeatPenalty != nil {
+		o.RepeatPenalty = over.RepeatPenalty
+	}
+	if over.Seed != nil {
+		o.Seed = over.Seed
+	}
+	if over.NumCtx != nil {
+		o.NumCtx = over.NumCtx
+	}
+	if over.NumPredict != nil {
+		o.NumPredict = over.NumPredict
+	}
+	if over.Stop != nil {
+		o.Stop = over.Stop
+	}
+	if over.KeepAlive != nil {
+		o.KeepAlive = over.KeepAlive
+	}
+	return o
+}
+
+// Map returns the options as the ollama request options map.
+func (o *ChatOptions) Map() map[string]any {
+	m := map[string]any{"temperature": common.LLMTemp}
+	if o.Temperature != nil {
+		m["temperature"] = *o.Temperature
+	}
+	if o.TopP != nil {
+		m["top_p"] = *o.TopP
+	}
+	if o.TopK != nil {
+		m["top_k"] = *o.TopK
+	}
+	if o.MinP != nil {
+		m["min_p"] = *o.MinP
+	}
+	if o.RepeatPenalty != nil {
+		m["repeat_penalty"] = *o.RepeatPenalty
+	}
+	if o.Seed != nil {
+		m["seed"] = *o.Seed
+	}
+	if o.NumCtx != nil {
+		m["num_ctx"] = *o.NumCtx
+	}
+	if o.NumPredict != nil {
+		m["num_predict"] = *o.NumPredict
+	}
+	if len(o.Stop) > 0 {
+		m["stop"] = o.Stop
+	}
+	return m
+}
+
+// Apply validates the options and sets them on req.
+func (o *ChatOptions) Apply(req *api.ChatRequest) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	req.Options = o.Map()
+	req.KeepAlive = o.KeepAlive
+	return nil
+}
@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+func TestChatOptions(t *testing.T) {
+	var conf ChatConfig
+	err := json.Unmarshal([]byte(`{"model": "qwen2.5:14b", "options": {"temperature": 0.7, "seed": 42, "stop": ["\n\n"], "keep_alive": "10m"}}`), &conf)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	var ci ChatInput
+	err = json.Unmarshal([]byte(`{"messages": [], "options": {"seed": 7, "num_ctx": 8192}}`), &ci)
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	opts := conf.Options.Merge(ci.Options)
+	var req api.ChatRequest
+	err = opts.Apply(&req)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, req.Options["temperature"] == 0.7, "Unexpected temperature %v", req.Options["temperature"])
+	common.Assert(t, req.Options["seed"] == 7 && req.Options["num_ctx"] == 8192, "Unexpected options %v", req.Options)
+	common.Assert(t, req.KeepAlive != nil && req.KeepAlive.Duration == 10*time.Minute, "Unexpected keep_alive %v", req.KeepAlive)
+
+	// the configured options are not changed by the override.
+	common.Assert(t, *conf.Options.Seed == 42 && conf.Options.NumCtx == nil, "Config options changed")
+
+	// temperature defaults to common.LLMTemp, unset options are not sent.
+	m := (&ChatOptions{}).Map()
+	common.Assert(t, len(m) == 1 && m["temperature"] == common.LLMTemp, "Unexpected default options %v", m)
+
+	for _, bad := range []string{
+		`{"temperature": -1}`,
+		`{"top_p": 0}`,
+		`{"top_k": -1}`,
+		`{"num_ctx": 0}`,
+		`{"num_predict": -3}`,
+		`{"stop": [""]}`,
+	} {
+		var o ChatOptions
+		err = json.Unmarshal([]byte(bad), &o)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, o.Validate() != nil, "Expected validation error for %s", bad)
+	}
+
+	ca := NewChatWithPrompt(DefaultModel, "sys", nil)
+	err = ca.Config([]byte(`{"model": "qwen2.5:14b", "options": {"top_p": 2}}`))
+	common.Assert(t, err != nil, "Expected validation error in Config")
+}
@@ -0,0 +1,301 @@
+package llm
+
+//
+// Prompt registry: named, versioned prompt templates with declared
+// variables and golden fixtures.
+//
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// PromptManifest is the file name of the prompt manifest in a prompt
+// directory.   The manifest is a json array of PromptTemplate.
+const PromptManifest = "prompts.json"
+
+// PromptFixture is a golden input/output pair of a prompt template.
+type PromptFixture struct {
+	Vars   map[string]any `json:"vars"`   // input variables
+	Output string         `json:"output"` // expected output, inline
+	Golden string         `json:"golden"` // or, file holding the expected output
+}
+
+// PromptTemplate is a named and versioned prompt template.
+type PromptTemplate struct {
+	Name     string          `json:"name"`
+	Version  int             `json:"version"`
+	Vars     []string        `json:"vars"`     // declared variables
+	Text     string          `json:"text"`     // template text, inline
+	File     string          `json:"file"`     // or, file holding the template text
+	Fixtures []PromptFixture `json:"fixtures"` // golden fixtures
+
+	tmpl *template.Template
+}
+
+// Ref returns the name@version reference of the template.
+func (p *PromptTemplate) Ref() string {
+	return fmt.Sprintf("%s@%d", p.Name, p.Version)
+}
+
+// Render renders the template.  Every declared variable must be supplied.
+func (p *PromptTemplate) Render(vars map[string]any) (string, error) {
+	for _, v := range p.Vars {
+		if _, ok := vars[v]; !ok {
+			return "", fmt.Errorf("prompt %s: variable %s is not supplied", p.Ref(), v)
+		}
+	}
+
+	buf := &strings.Builder{}
+	if err := p.tmpl.Execute(buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parse parses the template text and checks that the template references
+// exactly the declared variables.
+func (p *PromptTemplate) parse() error {
+	var err error
+	p.tmpl, err = template.New(p.Ref()).Option("missingkey=error").Parse(p.Text)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, t := range p.tmpl.Templates() {
+		if t.Tree != nil {
+			templateVars(t.Tree.Root, true, used)
+		}
+	}
+
+	declared := make(map[string]bool)
+	for _, v := range p.Vars {
+		declared[v] = true
+		if !used[v] {
+			return fmt.Errorf("prompt %s: variable %s is declared but not used", p.Ref(), v)
+		}
+	}
+	for v := range used {
+		if !declared[v] {
+			return fmt.Errorf("prompt %s: variable %s is used but not declared", p.Ref(), v)
+		}
+	}
+	return nil
+}
+
+// templateVars collects the top level fields ({{.Foo}} or {{$.Foo}})
+// referenced by node.  Dot is the top level data if top is set, range
+// and with bodies have their own dot.
+func templateVars(node parse.Node, top bool, used map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			templateVars(c, top, used)
+		}
+	case *parse.ActionNode:
+		templateVars(n.Pipe, top, used)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			templateVars(c, top, used)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			templateVars(a, top, used)
+		}
+	case *parse.FieldNode:
+		if top {
+			used[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			used[n.Ident[1]] = true
+		}
+	case *parse.ChainNode:
+		templateVars(n.Node, top, used)
+	case *parse.IfNode:
+		templateVars(n.Pipe, top, used)
+		templateVars(n.List, top, used)
+		templateVars(n.ElseList, top, used)
+	case *parse.RangeNode:
+		templateVars(n.Pipe, top, used)
+		templateVars(n.List, false, used)
+		templateVars(n.ElseList, top, used)
+	case *parse.WithNode:
+		templateVars(n.Pipe, top, used)
+		templateVars(n.List, false, used)
+		templateVars(n.ElseList, top, used)
+	case *parse.TemplateNode:
+		templateVars(n.Pipe, top, used)
+	}
+}
+
+// PromptRegistry holds prompt templates by name, each name may have
+// several versions.
+type PromptRegistry struct {
+	fsys    fs.FS
+	prompts map[string][]*PromptTemplate
+}
+
+// NewPromptRegistry creates an empty registry.
+func NewPromptRegistry() *PromptRegistry {
+	return &PromptRegistry{prompts: make(map[string][]*PromptTemplate)}
+}
+
+// LoadPrompts loads a registry from the manifest in fsys.  Template text
+// and golden files are read from fsys as well.
+func LoadPrompts(fsys fs.FS) (*PromptRegistry, error) {
+	bs, err := fs.ReadFile(fsys, PromptManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompts []*PromptTemplate
+	if err = json.Unmarshal(bs, &prompts); err != nil {
+		return nil, fmt.Errorf("%s: %v", PromptManifest, err)
+	}
+
+	reg := NewPromptRegistry()
+	reg.fsys = fsys
+	for _, p := range prompts {
+		if p.Text == "" && p.File != "" {
+			text, err := fs.ReadFile(fsys, p.File)
+			if err != nil {
+				return nil, err
+			}
+			p.Text = string(text)
+		}
+		if err = reg.Register(p); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
+
+// Register adds a template to the registry.
+func (r *PromptRegistry) Register(p *PromptTemplate) error {
+	if p.Name == "" || strings.Contains(p.Name, "@") {
+		return fmt.Errorf("invalid prompt name: %q", p.Name)
+	}
+	if p.Version <= 0 {
+		p.Version = 1
+	}
+	for _, existing := range r.prompts[p.Name] {
+		if existing.Version == p.Version {
+			return fmt.Errorf("prompt %s already registered", p.Ref())
+		}
+	}
+	if err := p.parse(); err != nil {
+		return err
+	}
+
+	versions := append(r.prompts[p.Name], p)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	r.prompts[p.Name] = versions
+	return nil
+}
+
+// Get returns a template by reference, either "name", which is the
+// latest version, or "name@version".
+func (r *PromptRegistry) Get(ref string) (*PromptTemplate, error) {
+	name, ver, hasVer := strings.Cut(ref, "@")
+	versions := r.prompts[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("unknown prompt: %s", ref)
+	}
+	if !hasVer {
+		return versions[len(versions)-1], nil
+	}
+
+	v, err := strconv.Atoi(ver)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt version: %s", ref)
+	}
+	for _, p := range versions {
+		if p.Version == v {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown prompt: %s", ref)
+}
+
+// Render renders the template referenced by ref.
+func (r *PromptRegistry) Render(ref string, vars map[string]any) (string, error) {
+	p, err := r.Get(ref)
+	if err != nil {
+		return "", err
+	}
+	return p.Render(vars)
+}
+
+// Names returns the names of all registered templates.
+func (r *PromptRegistry) Names() []string {
+	var names []string
+	for name := range r.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckFixtures renders every fixture of every template and compares
+// the output with the golden output.
+func (r *PromptRegistry) CheckFixtures() error {
+	for _, name := range r.Names() {
+		for _, p := range r.prompts[name] {
+			for i, fx := range p.Fixtures {
+				expect := fx.Output
+				if fx.Golden != "" {
+					if r.fsys == nil {
+						return fmt.Errorf("prompt %s fixture %d: no file system for golden file", p.Ref(), i)
+					}
+					bs, err := fs.ReadFile(r.fsys, fx.Golden)
+					if err != nil {
+						return err
+					}
+					expect = string(bs)
+				}
+
+				out, err := p.Render(fx.Vars)
+				if err != nil {
+					return fmt.Errorf("prompt %s fixture %d: %v", p.Ref(), i, err)
+				}
+				if out != expect {
+					return fmt.Errorf("prompt %s fixture %d: output does not match golden output", p.Ref(), i)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//go:embed prompts.json chat_*.txt vision_*.txt ensemble_*.txt summarize_*.txt translate_*.txt
+var defaultPromptFS embed.FS
+
+var (
+	defaultPrompts     *PromptRegistry
+	defaultPromptsErr  error
+	defaultPromptsOnce sync.Once
+)
+
+// DefaultPrompts returns the registry of prompts shipped with the llm
+// package.
+func DefaultPrompts() (*PromptRegistry, error) {
+	defaultPromptsOnce.Do(func() {
+		defaultPrompts, defaultPromptsErr = LoadPrompts(defaultPromptFS)
+	})
+	return defaultPrompts, defaultPromptsErr
+}
@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestDefaultPrompts(t *testing.T) {
+	prompts, err := DefaultPrompts()
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	err = prompts.CheckFixtures()
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+}
+
+func TestPromptRegistry(t *testing.T) {
+	reg := NewPromptRegistry()
+	err := reg.Register(&PromptTemplate{Name: "qa", Version: 1, Vars: []string{"Q"}, Text: "Q: {{.Q}}"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	err = reg.Register(&PromptTemplate{Name: "qa", Version: 2, Vars: []string{"Q", "Style"}, Text: "Answer in {{.Style}}. Q: {{.Q}}"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	// duplicate version, undeclared and unused variables.
+	err = reg.Register(&PromptTemplate{Name: "qa", Version: 2, Vars: []string{"Q"}, Text: "{{.Q}}"})
+	common.Assert(t, err != nil, "Expected duplicate error")
+	err = reg.Register(&PromptTemplate{Name: "bad", Vars: []string{"Q"}, Text: "{{.Q}} {{if .Z}}z{{end}}"})
+	common.Assert(t, err != nil, "Expected undeclared error")
+	err = reg.Register(&PromptTemplate{Name: "bad", Vars: []string{"Q", "Z"}, Text: "{{.Q}}"})
+	common.Assert(t, err != nil, "Expected unused error")
+
+	// fields in range and with are of their dot, $ is the top level.
+	err = reg.Register(&PromptTemplate{Name: "list", Vars: []string{"Items", "Sep"},
+		Text: "{{range .Items}}{{.Name}}{{$.Sep}}{{end}}{{with .Sep}}{{.}}{{end}}"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	err = reg.Register(&PromptTemplate{Name: "bad", Vars: []string{"Items"}, Text: "{{range .Items}}{{$.Sep}}{{end}}"})
+	common.Assert(t, err != nil, "Expected undeclared $ error")
+	out, err := reg.Render("list", map[string]any{"Items": []map[string]string{{"Name": "a"}, {"Name": "b"}}, "Sep": ";"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, out == "a;b;;", "Unexpected output %s", out)
+
+	out, err = reg.Render("qa", map[string]any{"Q": "1+2=", "Style": "one word"})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, out == "Answer in one word. Q: 1+2=", "Unexpected output %s", out)
+
+	out, err = reg.Render("qa@1", map[string]any{"Q": "1+2="})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, out == "Q: 1+2=", "Unexpected output %s", out)
+
+	_, err = reg.Render("qa", map[string]any{"Q": "1+2="})
+	common.Assert(t, err != nil, "Expected missing variable error")
+	_, err = reg.Render("qa@3", nil)
+	common.Assert(t, err != nil, "Expected unknown prompt error")
+}
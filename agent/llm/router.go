@@ -0,0 +1,242 @@
+package llm
+
+//
+// A router agent, picks a model chain per request by rules and falls
+// back to the next model on error or malformed output.
+//
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/ollama/ollama/api"
+)
+
+// RouteRule selects a model chain.  A rule matches a request if all
+// of its set conditions hold.
+type RouteRule struct {
+	Name string `json:"name"`
+	// Tags match the tag of the request, empty matches any tag.
+	Tags []string `json:"tags"`
+	// MinTokens and MaxTokens bound the prompt length, 0 is no bound.
+	MinTokens int `json:"min_tokens"`
+	MaxTokens int `json:"max_tokens"`
+	// Tools matches only requests that need tool calling.
+	Tools bool `json:"tools"`
+	// Models is the fallback chain, tried in order.
+	Models []string `json:"models"`
+}
+
+func (r *RouteRule) match(tag string, ntk int, tools bool) bool {
+	if len(r.Tags) > 0 && !slices.Contains(r.Tags, tag) {
+		return false
+	}
+	if r.MinTokens > 0 && ntk < r.MinTokens {
+		return false
+	}
+	if r.MaxTokens > 0 && ntk > r.MaxTokens {
+		return false
+	}
+	return !r.Tools || tools
+}
+
+type RouterConfig struct {
+	// Chat is the chat config shared by all models, its Model is
+	// ignored.
+	Chat  ChatConfig  `json:"chat"`
+	Rules []RouteRule `json:"rules"`
+	// Fallback is the chain used when no rule matches.
+	Fallback []string `json:"fallback"`
+}
+
+type router struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf     RouterConfig
+	toolcall LLMFunctionCall
+	chatters map[string]*chatter
+	// transcript, if set, records the exchanges of the inner chatters.
+	transcript Recorder
+}
+
+// NewRouter creates a router with a system prompt and the default
+// fallback chain models.
+func NewRouter(sysprompt string, models []string, tc LLMFunctionCall) agent.Agent {
+	ra := &router{}
+	ra.conf.Chat.SystemPrompt = api.Message{Role: "system", Content: sysprompt}
+	ra.conf.Chat.MaxRepair = DefaultMaxRepair
+	ra.conf.Fallback = models
+	ra.toolcall = tc
+	ra.chatters = make(map[string]*chatter)
+	ra.Self = ra
+	return ra
+}
+
+func (r *router) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &r.conf)
+	if err != nil {
+		return err
+	}
+	if err = r.conf.Chat.Options.Validate(); err != nil {
+		return err
+	}
+	for _, rule := range r.conf.Rules {
+		if len(rule.Models) == 0 {
+			return fmt.Errorf("route rule %s has no models", rule.Name)
+		}
+	}
+	r.chatters = make(map[string]*chatter)
+	return nil
+}
+
+func (r *router) SetValue(name string, value any) error {
+	switch name {
+	case "toolcall":
+		tc, ok := value.(func(api.ToolCallFunction) (string, error))
+		if !ok {
+			return fmt.Errorf("invalid toolcall function")
+		}
+		r.toolcall = tc
+	case "tools":
+		r.conf.Chat.Tools = value.(api.Tools)
+	case "format":
+		r.conf.Chat.Format = value.(json.RawMessage)
+	case "rules":
+		r.conf.Rules = value.([]RouteRule)
+	case "fallback":
+		r.conf.Fallback = value.([]string)
+	case "transcript":
+		rec, ok := value.(Recorder)
+		if !ok {
+			return fmt.Errorf("invalid transcript %T", value)
+		}
+		r.transcript = rec
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	r.chatters = make(map[string]*chatter)
+	return nil
+}
+
+// promptTokens counts the tokens of the system prompt and messages.
+func (r *router) promptTokens(input *ChatInput) int {
+	ntk := CountTokens("", r.conf.Chat.SystemPrompt.Content)
+	for _, m := range input.Messages {
+		ntk += CountTokens("", m.Content)
+	}
+	return ntk
+}
+
+// route returns the rule name and model chain for input.  If tools are
+// required, models that do not support tool calling are dropped.
+func (r *router) route(input *ChatInput) (string, []string) {
+	tools := len(r.conf.Chat.Tools) > 0
+	ntk := r.promptTokens(input)
+
+	name, models := "fallback", r.conf.Fallback
+	for _, rule := range r.conf.Rules {
+		if rule.match(input.Tag, ntk, tools) {
+			name, models = rule.Name, rule.Models
+			break
+		}
+	}
+
+	if tools {
+		models = slices.DeleteFunc(slices.Clone(models), func(m string) bool { return !SupportsTools(m) })
+	}
+	return name, models
+}
+
+func (r *router) chatter(model string) (*chatter, error) {
+	if ca, ok := r.chatters[model]; ok {
+		return ca, nil
+	}
+
+	ca := &chatter{conf: r.conf.Chat, toolcall: r.toolcall}
+	ca.conf.Model = model
+	ca.transcript = r.transcript
+	ca.Self = ca
+	if err := ca.renderSystemPrompt(); err != nil {
+		return nil, err
+	}
+	ca.buildRequest()
+	r.chatters[model] = ca
+	return ca, nil
+}
+
+// ask runs input on model, malformed output is an error.
+func (r *router) ask(model string, input []byte) ([]byte, error) {
+	ca, err := r.chatter(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	var yieldErr error
+	err = ca.ExecuteOne(input, nil, func(bs []byte, err error) bool {
+		out, yieldErr = bs, err
+		return true
+	})
+	if err == nil {
+		err = yieldErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var output ChatOutput
+	if err = json.Unmarshal(out, &output); err != nil {
+		return nil, err
+	}
+	content := StripThink(output.Response.Message.Content)
+	if content == "" {
+		return nil, fmt.Errorf("empty response")
+	}
+	if len(r.conf.Chat.Format) > 0 {
+		if err = DecodeJson(content, r.conf.Chat.Format, nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (r *router) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var chatInput ChatInput
+	err := json.Unmarshal(input, &chatInput)
+	if err != nil {
+		return err
+	}
+
+	rule, models := r.route(&chatInput)
+	if len(models) == 0 {
+		return fmt.Errorf("route %s: no model available", rule)
+	}
+
+	var errs []error
+	for _, model := range models {
+		out, err := r.ask(model, input)
+		if err != nil {
+			slog.Warn("Router model failed", "rule", rule, "model", model, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", model, err))
+			continue
+		}
+
+		slog.Info("Router answered", "rule", rule, "model", model, "fallbacks", len(errs))
+		if !yield(out, nil) {
+			return agent.ErrYieldDone
+		}
+		return nil
+	}
+	return fmt.Errorf("route %s, all models failed (%s): %w", rule, strings.Join(models, ", "), errors.Join(errs...))
+}
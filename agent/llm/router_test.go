@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+func TestRouterRoute(t *testing.T) {
+	ra := NewRouter("You are a helpful assistant.", []string{"qwen2.5:14b", "phi4"}, nil).(*router)
+	err := ra.Config([]byte(`{
+		"chat": {"system_prompt": {"role": "system", "content": "You are a helpful assistant."}},
+		"rules": [
+			{"name": "reason", "tags": ["math", "logic"], "models": ["deepseek-r1:32b", "qwen2.5:14b"]},
+			{"name": "long", "min_tokens": 1000, "models": ["qwen2.5:14b"]},
+			{"name": "short", "max_tokens": 100, "models": ["phi4", "qwen2.5:14b"]}
+		],
+		"fallback": ["qwen2.5:14b", "phi4"]
+	}`))
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+
+	input := &ChatInput{Messages: []api.Message{{Role: "user", Content: "1+2="}}, Tag: "math"}
+	rule, models := ra.route(input)
+	common.Assert(t, rule == "reason" && models[0] == "deepseek-r1:32b", "Unexpected route %s %v", rule, models)
+
+	input.Tag = ""
+	rule, models = ra.route(input)
+	common.Assert(t, rule == "short" && models[0] == "phi4", "Unexpected route %s %v", rule, models)
+
+	input.Messages[0].Content = strings.Repeat("the quick brown fox ", 100)
+	rule, _ = ra.route(input)
+	common.Assert(t, rule == "fallback", "Unexpected route %s", rule)
+
+	input.Messages[0].Content = strings.Repeat("the quick brown fox ", 1000)
+	rule, _ = ra.route(input)
+	common.Assert(t, rule == "long", "Unexpected route %s", rule)
+
+	// tool calling drops models without tool support.
+	ra.SetValue("tools", api.Tools{{Type: "function"}})
+	input.Tag = "math"
+	rule, models = ra.route(input)
+	common.Assert(t, rule == "reason" && len(models) == 1 && models[0] == "qwen2.5:14b", "Unexpected route %s %v", rule, models)
+
+	ra = NewRouter("", nil, nil).(*router)
+	err = ra.Config([]byte(`{"rules": [{"name": "empty"}]}`))
+	common.Assert(t, err != nil, "Expected no models error")
+}
+
+func TestRouterFallback(t *testing.T) {
+	// replay from an empty directory, every model fails.
+	err := SetRecording(ReplayMode, t.TempDir())
+	common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+	defer SetRecording(RecordOff, "")
+
+	ra := NewRouter("You are a helpful assistant.", []string{"qwen2.5:14b", "phi4"}, nil)
+	input, _ := json.Marshal(ChatInput{Messages: []api.Message{{Role: "user", Content: "1+2="}}})
+	err = ra.ExecuteOne(input, nil, func([]byte, error) bool { return true })
+	common.Assert(t, err != nil, "Expected all models failed error")
+	common.Assert(t, strings.Contains(err.Error(), "qwen2.5:14b") && strings.Contains(err.Error(), "phi4"), "Unexpected error %v", err)
+}
+
+func TestRouterChat(t *testing.T) {
+	useFixtures(t)
+	qs := []string{}
+	for _, in := range []ChatInput{
+		{Messages: []api.Message{{Role: "user", Content: "What is 17*23?"}}, Tag: "math"},
+		{Messages: []api.Message{{Role: "user", Content: "What is the capital of France?"}}},
+	} {
+		bs, err := json.Marshal(in)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		qs = append(qs, string(bs))
+	}
+
+	ra := NewRouter("You are a helpful assistant.  You should answer each question in one sentence.",
+		[]string{"qwen2.5:14b", "phi4"}, nil)
+	err := ra.SetValue("rules", []RouteRule{
+		{Name: "math", Tags: []string{"math"}, Models: []string{"deepseek-r1:14b", "qwen2.5:14b"}},
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	var pipe agent.AgentPipe
+	pipe.AddAgent(agent.NewStringArrayAgent(qs))
+	pipe.AddAgent(ra)
+	defer pipe.Close()
+
+	it, err := pipe.Execute(nil, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	for data, err := range it {
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		var out ChatOutput
+		err = json.Unmarshal(data, &out)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		t.Logf("Model %s: %s", out.Response.Model, StripThink(out.Response.Message.Content))
+	}
+}
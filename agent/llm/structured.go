@@ -0,0 +1,393 @@
+package llm
+
+//
+// Structured output: ask the model for json conforming to a schema,
+// validate the answer and re-prompt the model if it is malformed.
+//
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// DefaultMaxRepair is the default number of times we re-prompt the model
+// with the validation error before giving up.
+const DefaultMaxRepair = 2
+
+var (
+	ErrNoJson = errors.New("no json found in llm response")
+
+	thinkRegexp = regexp.MustCompile(`(?s)<think>.*?</think>`)
+)
+
+// StripThink removes the <think>...</think> blocks emitted by reasoning
+// models such as deepseek-r1.   An unterminated <think> block swallows
+// the rest of the response.
+func StripThink(s string) string {
+	s = thinkRegexp.ReplaceAllString(s, "")
+	if idx := strings.Index(s, "<think>"); idx >= 0 {
+		s = s[:idx]
+	}
+	return strings.TrimSpace(s)
+}
+
+// ExtractJson returns the json part of a llm response.  It prefers the
+// first fenced code block that holds a json object or array, otherwise
+// it returns the first balanced {...} or [...] in the text.
+func ExtractJson(s string) (string, error) {
+	s = StripThink(s)
+
+	lines := strings.Split(s, "\n")
+	buf := &strings.Builder{}
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inFence {
+				if js, ok := balancedJson(buf.String()); ok {
+					return js, nil
+				}
+				buf.Reset()
+			}
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+
+	if js, ok := balancedJson(s); ok {
+		return js, nil
+	}
+	return "", ErrNoJson
+}
+
+// balancedJson finds the first balanced json object or array in s.
+func balancedJson(s string) (string, bool) {
+	for start := 0; start < len(s); start++ {
+		if s[start] != '{' && s[start] != '[' {
+			continue
+		}
+		if end := matchBracket(s, start); end > 0 && json.Valid([]byte(s[start:end])) {
+			return s[start:end], true
+		}
+	}
+	return "", false
+}
+
+// matchBracket returns the offset just past the bracket closing s[start],
+// or -1.   Brackets inside json strings are skipped.
+func matchBracket(s string, start int) int {
+	depth := 0
+	inStr := false
+	for i := start; i < len(s); i++ {
+		ch := s[i]
+		if inStr {
+			if ch == '\\' {
+				i++
+			} else if ch == '"' {
+				inStr = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inStr = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// Schema returns the json schema of the go value v, following the
+// encoding/json field naming rules.   Fields tagged omitempty are
+// optional, all other fields are required.   Pointer, slice and map
+// fields can be null, as encoding/json writes their nil values.
+func Schema(v any) (json.RawMessage, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot build schema for nil")
+	}
+	return json.Marshal(schemaOf(t))
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fs := schemaOf(f.Type)
+			switch f.Type.Kind() {
+			case reflect.Pointer, reflect.Slice, reflect.Map:
+				if typ, ok := fs["type"]; ok {
+					fs["type"] = []any{typ, "null"}
+				}
+			}
+			props[name] = fs
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": props, "required": required}
+	}
+	// interface and anything else, accept any value.
+	return map[string]any{}
+}
+
+// ValidateJson validates data against schema.   Only the subset of json
+// schema produced by Schema is checked: type, properties, required,
+// items, additionalProperties, enum, minItems and maxItems.
+func ValidateJson(schema json.RawMessage, data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if !isSchema(schema) {
+		return nil
+	}
+
+	var s map[string]any
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	return validate("$", s, v)
+}
+
+// isSchema tells if format is a json schema, instead of "json" or nothing.
+func isSchema(format json.RawMessage) bool {
+	f := strings.TrimSpace(string(format))
+	return len(f) > 0 && f[0] == '{'
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func typeMatch(want any, got string) bool {
+	switch want := want.(type) {
+	case nil:
+		return true
+	case string:
+		return want == got || (want == "number" && got == "integer")
+	case []any:
+		for _, w := range want {
+			if typeMatch(w, got) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validate(path string, s map[string]any, v any) error {
+	got := jsonType(v)
+	if !typeMatch(s["type"], got) {
+		return fmt.Errorf("%s: expect %v, got %s", path, s["type"], got)
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case []any:
+		if n, ok := s["minItems"].(float64); ok && len(v) < int(n) {
+			return fmt.Errorf("%s: expect at least %d items, got %d", path, int(n), len(v))
+		}
+		if n, ok := s["maxItems"].(float64); ok && len(v) > int(n) {
+			return fmt.Errorf("%s: expect at most %d items, got %d", path, int(n), len(v))
+		}
+		if items, ok := s["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validate(fmt.Sprintf("%s[%d]", path, i), items, item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		if req, ok := s["required"].([]any); ok {
+			for _, r := range req {
+				name, _ := r.(string)
+				if _, ok := v[name]; !ok {
+					return fmt.Errorf("%s: missing required field %s", path, name)
+				}
+			}
+		}
+		props, _ := s["properties"].(map[string]any)
+		for k, fv := range v {
+			if ps, ok := props[k].(map[string]any); ok {
+				if err := validate(path+"."+k, ps, fv); err != nil {
+					return err
+				}
+			} else if aps, ok := s["additionalProperties"].(map[string]any); ok {
+				if err := validate(path+"."+k, aps, fv); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeJson extracts the json part of a llm response, validates it
+// against format (a json schema, "json" or empty) and unmarshals it
+// into dest.
+func DecodeJson(content string, format json.RawMessage, dest any) error {
+	js, err := ExtractJson(content)
+	if err != nil {
+		return err
+	}
+	if err = ValidateJson(format, []byte(js)); err != nil {
+		return err
+	}
+	if dest == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(js), dest)
+}
+
+// repairMessage is the user message sent back to the model when its
+// answer does not conform to the schema.
+func repairMessage(err error, format json.RawMessage) api.Message {
+	content := fmt.Sprintf("Your previous answer is not valid: %v.\n"+
+		"Please answer again. The answer must be valid json", err)
+	if isSchema(format) {
+		content += " that conforms to the following json schema.\n" + string(format)
+	}
+	return api.Message{Role: "user", Content: content}
+}
+
+// StructuredChat sends chat requests with ollama format set to a json
+// schema, and re-prompts the model with the validation error up to
+// MaxRepair times.
+type StructuredChat struct {
+	Schema    json.RawMessage
+	MaxRepair int
+}
+
+// NewStructuredChat creates a StructuredChat whose schema is derived from
+// the go type of v.
+func NewStructuredChat(v any) (*StructuredChat, error) {
+	schema, err := Schema(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewStructuredChatWithSchema(schema), nil
+}
+
+// NewStructuredChatWithSchema creates a StructuredChat from a json schema.
+func NewStructuredChatWithSchema(schema json.RawMessage) *StructuredChat {
+	return &StructuredChat{Schema: schema, MaxRepair: DefaultMaxRepair}
+}
+
+// Chat runs req and decodes the answer into dest.  req is not modified,
+// the repair rounds are appended to a copy of its messages.  The last
+// response is returned, even if it could not be decoded.
+func (sc *StructuredChat) Chat(ctx context.Context, req *api.ChatRequest, dest any) (*api.ChatResponse, error) {
+	cli, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	r := *req
+	r.Format = sc.Schema
+	r.Messages = append([]api.Message(nil), req.Messages...)
+
+	var resp api.ChatResponse
+	for round := 0; ; round++ {
+		err = cli.Chat(ctx, &r, func(cr api.ChatResponse) error {
+			resp = cr
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = DecodeJson(resp.Message.Content, sc.Schema, dest)
+		if err == nil || round >= sc.MaxRepair {
+			break
+		}
+
+		slog.Debug("StructuredChat repair", "model", r.Model, "round", round, "err", err)
+		r.Messages = append(r.Messages, resp.Message, repairMessage(err, sc.Schema))
+	}
+
+	if err != nil {
+		return &resp, fmt.Errorf("structured output: %w", err)
+	}
+	return &resp, nil
+}
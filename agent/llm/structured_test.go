@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestExtractJson(t *testing.T) {
+	resp := "<think>\nmaybe {\"title\": \"wrong\"}\n</think>\nHere are the topics.\n```json\n[\n  {\"title\": \"Jason\"},\n  {\"title\": \"Argonauts\"}\n]\n```\n"
+	js, err := ExtractJson(resp)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, js == "[\n  {\"title\": \"Jason\"},\n  {\"title\": \"Argonauts\"}\n]", "Unexpected json %s", js)
+
+	js, err = ExtractJson(`The answer is {"final_answer": "a } in string"} as required.`)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, js == `{"final_answer": "a } in string"}`, "Unexpected json %s", js)
+
+	_, err = ExtractJson("<think>{\"a\": 1}</think> I do not know.")
+	common.Assert(t, err == ErrNoJson, "Expected ErrNoJson, got %v", err)
+
+	// unterminated think block
+	common.Assert(t, StripThink("answer<think>and more") == "answer", "StripThink failed")
+}
+
+func TestSchemaValidate(t *testing.T) {
+	type topic struct {
+		Title string   `json:"title"`
+		Score float64  `json:"score,omitempty"`
+		Tags  []string `json:"tags,omitempty"`
+	}
+
+	schema, err := Schema([]topic{})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	var topics []topic
+	err = DecodeJson(`[{"title": "Jason", "score": 1, "tags": ["myth"]}]`, schema, &topics)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(topics) == 1 && topics[0].Title == "Jason", "Unexpected topics %v", topics)
+
+	err = DecodeJson(`[{"score": 1}]`, schema, &topics)
+	common.Assert(t, err != nil, "Expected missing field error")
+	t.Logf("missing field: %v", err)
+
+	err = DecodeJson(`[{"title": "Jason", "tags": [1]}]`, schema, &topics)
+	common.Assert(t, err != nil, "Expected type error")
+	t.Logf("type error: %v", err)
+
+	err = DecodeJson(`{"title": "Jason"}`, schema, &topics)
+	common.Assert(t, err != nil, "Expected type error")
+
+	// nil pointers and slices are written as null.
+	type answer struct {
+		Answer  string   `json:"answer"`
+		Sources []string `json:"sources"`
+		Topic   *topic   `json:"topic"`
+	}
+	schema, err = Schema(answer{})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	bs, _ := json.Marshal(answer{Answer: "Jason"})
+	var ans answer
+	err = DecodeJson(string(bs), schema, &ans)
+	common.Assert(t, err == nil && ans.Answer == "Jason", "Expected nil, got %v", err)
+	err = DecodeJson(`{"answer": null, "sources": null, "topic": null}`, schema, &ans)
+	common.Assert(t, err != nil, "Expected null string error")
+
+	// format "json" only checks it is valid json.
+	err = DecodeJson(`{"title": 1}`, []byte(`"json"`), nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+}
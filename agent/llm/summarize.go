@@ -0,0 +1,411 @@
+package llm
+
+//
+// Map-reduce summarization of long documents.  Chunks are packed into
+// groups that fit the prompt budget and summarized, then the summaries
+// are summarized, level by level, up to one summary per chapter (Num1),
+// and the chapter summaries up to one summary of the book.
+//
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/textu/chunk"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	SummaryPart    = "part"    // summary of a group of chunks or summaries
+	SummaryChapter = "chapter" // summary of all chunks of a Num1
+	SummaryBook    = "book"    // summary of all chapters
+
+	// BookNum1 is the Num1 of the book summary.
+	BookNum1 = -1
+)
+
+// Summary is a summary at some level.  Level 1 summarizes chunks, level
+// n+1 summarizes summaries of level n.
+type Summary struct {
+	Level int    `json:"level"`
+	Num1  int32  `json:"num1"`
+	Seq   int    `json:"seq"` // sequence number in the level of the chapter
+	Kind  string `json:"kind"`
+	Text  string `json:"text"`
+}
+
+// Summarizer summarizes chunks hierarchically.
+type Summarizer struct {
+	Model string
+	// Query, if set, focuses the summaries on information relevant
+	// to it.
+	Query string
+	// Budget is the number of tokens of text in one summarize request.
+	Budget  int
+	Options ChatOptions
+	// OnSummary, if set, is called with every summary as it is made.
+	OnSummary func(Summary) error
+
+	// summarize summarizes texts, tests replace it.
+	summarize func(texts []string, level int) (string, error)
+}
+
+// NewSummarizer creates a summarizer, the budget is half of the default
+// context window of model.
+func NewSummarizer(model, query string) *Summarizer {
+	s := &Summarizer{Model: model, Query: query}
+	s.Budget = min(ContextWindow(model), 8192) / 2
+	s.summarize = s.chat
+	return s
+}
+
+// chat asks the model to summarize texts.
+func (s *Summarizer) chat(texts []string, level int) (string, error) {
+	prompts, err := DefaultPrompts()
+	if err != nil {
+		return "", err
+	}
+	content, err := prompts.Render("summarize.map", map[string]any{
+		"Question": s.Query,
+		"Text":     strings.Join(texts, "\n\n"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	cli, err := NewClient()
+	if err != nil {
+		return "", err
+	}
+
+	opts := s.Options
+	if opts.NumCtx == nil {
+		numCtx := min(ContextWindow(s.Model), max(DefaultNumCtx, 2*s.Budget))
+		opts.NumCtx = &numCtx
+	}
+	// a summary is at most half the budget, so that the next level can
+	// pack at least two summaries in a group.
+	if opts.NumPredict == nil {
+		numPredict := max(s.Budget/2, 1)
+		opts.NumPredict = &numPredict
+	}
+	req := api.ChatRequest{
+		Model:    s.Model,
+		Stream:   new(bool),
+		Messages: []api.Message{{Role: "user", Content: content}},
+	}
+	if err = opts.Apply(&req); err != nil {
+		return "", err
+	}
+
+	var resp api.ChatResponse
+	err = cli.Chat(context.Background(), &req, func(cr api.ChatResponse) error {
+		resp = cr
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	slog.Debug("Summarizer summarize", "level", level, "texts", len(texts))
+	return StripThink(resp.Message.Content), nil
+}
+
+// pack groups consecutive texts so that each group fits the budget, a
+// text larger than the budget is truncated into a group of its own.
+func (s *Summarizer) pack(texts []string) [][]string {
+	var groups [][]string
+	var group []string
+	used := 0
+	for _, t := range texts {
+		ntk := CountTokens(s.Model, t)
+		if ntk > s.Budget {
+			t = TruncateTokens(s.Model, t, s.Budget)
+			ntk = s.Budget
+		}
+		if len(group) > 0 && used+ntk > s.Budget {
+			groups = append(groups, group)
+			group, used = nil, 0
+		}
+		group = append(group, t)
+		used += ntk
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// pair groups texts two by two, each truncated to half the budget, it
+// is used when summaries do not shrink enough to pack.
+func (s *Summarizer) pair(texts []string) [][]string {
+	var groups [][]string
+	for i := 0; i < len(texts); i += 2 {
+		var group []string
+		for _, t := range texts[i:min(i+2, len(texts))] {
+			group = append(group, TruncateTokens(s.Model, t, max(s.Budget/2, 1)))
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (s *Summarizer) emit(sum Summary) error {
+	if s.OnSummary == nil {
+		return nil
+	}
+	return s.OnSummary(sum)
+}
+
+// reduce summarizes texts level by level until one summary is left, the
+// last summary is of the given kind, the others are parts.
+func (s *Summarizer) reduce(num1 int32, texts []string, kind string) (Summary, error) {
+	for level := 1; ; level++ {
+		groups := s.pack(texts)
+		if level > 1 && len(groups) >= len(texts) {
+			// no progress, force merge so that the number of texts
+			// halves at every level.
+			groups = s.pair(texts)
+		}
+		var next []string
+		for seq, group := range groups {
+			text, err := s.summarize(group, level)
+			if err != nil {
+				return Summary{}, err
+			}
+
+			sum := Summary{Level: level, Num1: num1, Seq: seq, Kind: SummaryPart, Text: text}
+			if len(groups) == 1 {
+				sum.Kind = kind
+			}
+			if err = s.emit(sum); err != nil {
+				return Summary{}, err
+			}
+			if len(groups) == 1 {
+				return sum, nil
+			}
+			next = append(next, text)
+		}
+		texts = next
+	}
+}
+
+// SummarizeText summarizes texts, level by level, into one summary.
+func (s *Summarizer) SummarizeText(texts []string) (string, error) {
+	sum, err := s.reduce(0, texts, SummaryChapter)
+	return sum.Text, err
+}
+
+// Summarize summarizes chunks into chapter summaries, and the chapter
+// summaries into the book summary.   Chunks of a chapter must be
+// consecutive.  All summaries are returned, in the order made.
+func (s *Summarizer) Summarize(chunks []chunk.Chunk) ([]Summary, error) {
+	var all []Summary
+	onSummary := s.OnSummary
+	s.OnSummary = func(sum Summary) error {
+		all = append(all, sum)
+		if onSummary != nil {
+			return onSummary(sum)
+		}
+		return nil
+	}
+	defer func() { s.OnSummary = onSummary }()
+
+	var chapters []string
+	for start := 0; start < len(chunks); {
+		num1 := chunks[start].Num1
+		end := start
+		var texts []string
+		for ; end < len(chunks) && chunks[end].Num1 == num1; end++ {
+			if t := strings.TrimSpace(chunks[end].Text); t != "" {
+				texts = append(texts, t)
+			}
+		}
+		start = end
+		if len(texts) == 0 {
+			continue
+		}
+
+		sum, err := s.reduce(num1, texts, SummaryChapter)
+		if err != nil {
+			return all, fmt.Errorf("summarize chapter %d: %w", num1, err)
+		}
+		chapters = append(chapters, sum.Text)
+	}
+
+	if len(chapters) == 0 {
+		return all, nil
+	}
+	if _, err := s.reduce(BookNum1, chapters, SummaryBook); err != nil {
+		return all, fmt.Errorf("summarize book: %w", err)
+	}
+	return all, nil
+}
+
+type SummarizeConfig struct {
+	Model string `json:"model"`
+	// Query, if set, makes query focused summaries.  The query of an
+	// input record overrides it.
+	Query      string      `json:"query"`
+	Budget     int         `json:"budget"`
+	Options    ChatOptions `json:"options"`
+	StringMode bool        `json:"string_mode"`
+}
+
+// SummarizeInput is the output of novelChunker, chunk objects or string
+// mode rows, with an optional query.
+type SummarizeInput struct {
+	Data  []json.RawMessage `json:"data"`
+	Query string            `json:"query"`
+}
+
+type SummarizeOutput struct {
+	Data []Summary `json:"data"`
+}
+
+// SummarizeStrOutput rows are level, num1, seq, kind, query, text.
+type SummarizeStrOutput struct {
+	Data [][]string `json:"data"`
+}
+
+type summarizer struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf SummarizeConfig
+}
+
+// NewSummarizeAgent creates an agent summarizing the chunks of each input
+// record.   It yields the summaries of each chapter as they are done, and
+// the book summary last, so that dbWriter can store all levels.
+func NewSummarizeAgent(model string) agent.Agent {
+	sa := &summarizer{}
+	sa.conf.Model = model
+	sa.Self = sa
+	return sa
+}
+
+func (s *summarizer) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &s.conf)
+	if err != nil {
+		return err
+	}
+	return s.conf.Options.Validate()
+}
+
+func (s *summarizer) SetValue(name string, value any) error {
+	switch name {
+	case "model":
+		s.conf.Model = value.(string)
+	case "query":
+		s.conf.Query = value.(string)
+	case "budget":
+		s.conf.Budget = value.(int)
+	case "string_mode":
+		s.conf.StringMode = value.(bool)
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	return nil
+}
+
+// parseChunks parses chunk objects, or string mode rows of novelChunker.
+func parseChunks(raws []json.RawMessage) ([]chunk.Chunk, error) {
+	chunks := make([]chunk.Chunk, 0, len(raws))
+	for _, raw := range raws {
+		var c chunk.Chunk
+		if len(raw) > 0 && raw[0] == '[' {
+			var row []string
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return nil, err
+			}
+			if len(row) < 5 {
+				return nil, fmt.Errorf("chunk row has %d columns, expect 5", len(row))
+			}
+			num1, err := strconv.Atoi(row[0])
+			if err != nil {
+				return nil, err
+			}
+			num2, err := strconv.Atoi(row[1])
+			if err != nil {
+				return nil, err
+			}
+			c = chunk.Chunk{Num1: int32(num1), Num2: int32(num2), Path: row[2], Title: row[3], Text: row[4]}
+		} else if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+func (s *summarizer) output(sums []Summary, query string) ([]byte, error) {
+	if !s.conf.StringMode {
+		return json.Marshal(SummarizeOutput{Data: sums})
+	}
+	var output SummarizeStrOutput
+	for _, sum := range sums {
+		output.Data = append(output.Data, []string{
+			strconv.Itoa(sum.Level),
+			strconv.Itoa(int(sum.Num1)),
+			strconv.Itoa(sum.Seq),
+			sum.Kind,
+			query,
+			sum.Text,
+		})
+	}
+	return json.Marshal(output)
+}
+
+func (s *summarizer) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var sumInput SummarizeInput
+	err := json.Unmarshal(input, &sumInput)
+	if err != nil {
+		return err
+	}
+	chunks, err := parseChunks(sumInput.Data)
+	if err != nil {
+		return err
+	}
+
+	query := s.conf.Query
+	if sumInput.Query != "" {
+		query = sumInput.Query
+	}
+	sz := NewSummarizer(s.conf.Model, query)
+	if s.conf.Budget > 0 {
+		sz.Budget = s.conf.Budget
+	}
+	sz.Options = s.conf.Options
+
+	// yield when a chapter or the book is done.
+	var pending []Summary
+	sz.OnSummary = func(sum Summary) error {
+		pending = append(pending, sum)
+		if sum.Kind == SummaryPart {
+			return nil
+		}
+		bs, err := s.output(pending, query)
+		pending = nil
+		if !yield(bs, err) {
+			return agent.ErrYieldDone
+		}
+		return nil
+	}
+
+	_, err = sz.Summarize(chunks)
+	if errors.Is(err, agent.ErrYieldDone) {
+		return agent.ErrYieldDone
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/matrixorigin/monlp/textu/chunk"
+)
+
+func TestSummarizeLevels(t *testing.T) {
+	sz := NewSummarizer(DefaultModel, "")
+	sz.Budget = 12
+	ncall := 0
+	sz.summarize = func(texts []string, level int) (string, error) {
+		ncall++
+		return fmt.Sprintf("L%d(%d)", level, len(texts)), nil
+	}
+
+	word := strings.Repeat("x", 12) // 3 tokens
+	var chunks []chunk.Chunk
+	for i := 0; i < 7; i++ {
+		chunks = append(chunks, chunk.Chunk{Num1: 0, Num2: int32(i), Text: word})
+	}
+	chunks = append(chunks, chunk.Chunk{Num1: 1, Text: word}, chunk.Chunk{Num1: 1, Text: "  "})
+
+	sums, err := sz.Summarize(chunks)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	// chapter 0: 7 chunks of 3 tokens, 2 parts at level 1, one at level 2.
+	// chapter 1: one chunk, book: two chapter summaries.
+	kinds := []string{}
+	for _, s := range sums {
+		kinds = append(kinds, fmt.Sprintf("%d/%d/%d/%s", s.Num1, s.Level, s.Seq, s.Kind))
+	}
+	expect := "0/1/0/part 0/1/1/part 0/2/0/chapter 1/1/0/chapter -1/1/0/book"
+	common.Assert(t, strings.Join(kinds, " ") == expect, "Unexpected summaries %v", kinds)
+	common.Assert(t, ncall == len(sums), "Expected %d calls, got %d", len(sums), ncall)
+	common.Assert(t, sums[2].Text == "L2(2)", "Unexpected chapter summary %s", sums[2].Text)
+
+	// summaries as long as the budget still reduce to one.
+	sz.summarize = func(texts []string, level int) (string, error) {
+		return strings.Repeat("y", 48), nil
+	}
+	long := strings.Repeat("x", 48)
+	sum, err := sz.reduce(0, []string{long, long, long, long, long}, SummaryChapter)
+	common.Assert(t, err == nil && sum.Kind == SummaryChapter, "Unexpected reduce %v, %v", sum, err)
+	common.Assert(t, sum.Level == 4, "Expected 4 levels, got %d", sum.Level)
+
+	// string mode rows of novelChunker.
+	parsed, err := parseChunks([]json.RawMessage{[]byte(`["1", "2", "p", "t", "text"]`), []byte(`{"num1": 3, "text": "obj"}`)})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, parsed[0].Num1 == 1 && parsed[0].Text == "text" && parsed[1].Num1 == 3, "Unexpected chunks %v", parsed)
+	_, err = parseChunks([]json.RawMessage{[]byte(`["1", "2"]`)})
+	common.Assert(t, err != nil, "Expected short row error")
+}
+
+func TestSummarizeNovel(t *testing.T) {
+	useFixtures(t)
+	f, err := os.Open(common.ProjectPath("data", "AnimalFarm.txt"))
+	common.PanicAssert(t, err == nil, "Open failed: %v", err)
+	defer f.Close()
+
+	ck, err := chunk.NewNovelChunker(f)
+	common.PanicAssert(t, err == nil, "NewNovelChunker failed: %v", err)
+
+	// the first two chapters.
+	chunks := slices.Collect(ck.Chunk())
+	n := slices.IndexFunc(chunks, func(c chunk.Chunk) bool { return c.Num1 > 2 })
+	if n >= 0 {
+		chunks = chunks[:n]
+	}
+	input, err := json.Marshal(map[string]any{"data": chunks, "query": "What did Old Major dream of?"})
+	common.PanicAssert(t, err == nil, "Marshal failed: %v", err)
+
+	sa := NewSummarizeAgent(DefaultModel)
+	err = sa.Config([]byte(`{"string_mode": true, "budget": 2000}`))
+	common.PanicAssert(t, err == nil, "Config failed: %v", err)
+
+	var pipe agent.AgentPipe
+	pipe.AddAgent(agent.NewStringArrayAgent([]string{string(input)}))
+	pipe.AddAgent(sa)
+	defer pipe.Close()
+
+	it, err := pipe.Execute(nil, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	for data, err := range it {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		var out SummarizeStrOutput
+		err = json.Unmarshal(data, &out)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		common.PanicAssert(t, len(out.Data) > 0, "Expected summaries")
+		last := out.Data[len(out.Data)-1]
+		t.Logf("%s summary of %s, %d levels: %s", last[3], last[1], len(out.Data), last[5])
+	}
+}
@@ -0,0 +1,182 @@
+package llm
+
+//
+// Approximate, model aware token counting and a priority based packer
+// to fit retrieved information into the context window.
+//
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultNumCtx is the context size ollama uses if num_ctx is not set.
+const DefaultNumCtx = 2048
+
+// ModelInfo is what we know about a model family.
+type ModelInfo struct {
+	Prefix        string  // model name prefix, for example "qwen2.5"
+	ContextWindow int     // max context window, in tokens
+	CharsPerToken float64 // latin characters per token
+	TokensPerCJK  float64 // tokens per CJK character
+	Tools         bool    // ollama supports tool calling for the model
+}
+
+// modelInfos are matched by longest prefix of the model name.
+var modelInfos = []ModelInfo{
+	{Prefix: "qwen2.5", ContextWindow: 32768, CharsPerToken: 4.0, TokensPerCJK: 0.7, Tools: true},
+	{Prefix: "qwen", ContextWindow: 32768, CharsPerToken: 4.0, TokensPerCJK: 0.7},
+	{Prefix: "deepseek-r1", ContextWindow: 131072, CharsPerToken: 4.0, TokensPerCJK: 0.7},
+	{Prefix: "llama3", ContextWindow: 131072, CharsPerToken: 4.2, TokensPerCJK: 1.0, Tools: true},
+	{Prefix: "llama3.2-vision", ContextWindow: 131072, CharsPerToken: 4.2, TokensPerCJK: 1.0},
+	{Prefix: "phi4", ContextWindow: 16384, CharsPerToken: 4.0, TokensPerCJK: 1.2},
+	{Prefix: "mistral", ContextWindow: 32768, CharsPerToken: 3.8, TokensPerCJK: 1.5, Tools: true},
+	{Prefix: "gemma2", ContextWindow: 8192, CharsPerToken: 4.2, TokensPerCJK: 1.0},
+	{Prefix: "nomic-embed-text", ContextWindow: 8192, CharsPerToken: 4.0, TokensPerCJK: 1.5},
+}
+
+var defaultModelInfo = ModelInfo{ContextWindow: DefaultNumCtx, CharsPerToken: 3.5, TokensPerCJK: 1.5}
+
+// LookupModel returns the info of model, or a conservative default.
+func LookupModel(model string) ModelInfo {
+	best := defaultModelInfo
+	for _, mi := range modelInfos {
+		if strings.HasPrefix(model, mi.Prefix) && len(mi.Prefix) > len(best.Prefix) {
+			best = mi
+		}
+	}
+	return best
+}
+
+// SupportsTools tells if model supports tool calling.
+func SupportsTools(model string) bool {
+	return LookupModel(model).Tools
+}
+
+// ContextWindow returns the max context window of model.
+func ContextWindow(model string) int {
+	return LookupModel(model).ContextWindow
+}
+
+func isCJKRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// CountTokens approximates the number of tokens of text for model.  A
+// latin word takes one token per CharsPerToken characters, rounded up,
+// a CJK character TokensPerCJK, and other non space characters, mostly
+// punctuation, one token each.
+func CountTokens(model, text string) int {
+	mi := LookupModel(model)
+
+	var ntk float64
+	word := 0
+	flush := func() {
+		if word > 0 {
+			ntk += float64(int((float64(word) + mi.CharsPerToken - 1) / mi.CharsPerToken))
+			word = 0
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJKRune(r):
+			flush()
+			ntk += mi.TokensPerCJK
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			ntk++
+		}
+	}
+	flush()
+	return int(ntk + 0.5)
+}
+
+// TruncateTokens cuts text so that it fits into n tokens.   It cuts at a
+// paragraph or line boundary if possible.
+func TruncateTokens(model, text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if CountTokens(model, text) <= n {
+		return text
+	}
+
+	// binary search the longest prefix that fits.
+	runes := []rune(text)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if CountTokens(model, string(runes[:mid])) <= n {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	cut := string(runes[:lo])
+	for _, sep := range []string{"\n\n", "\n", ". ", "。"} {
+		if idx := strings.LastIndex(cut, sep); idx > len(cut)/2 {
+			return cut[:idx+len(sep)]
+		}
+	}
+	return cut
+}
+
+// BudgetItem is a piece of text competing for the prompt budget.
+type BudgetItem struct {
+	Key      string // identifies the item for the caller
+	Text     string
+	Priority int // higher priority items are packed first
+	Tokens   int // number of tokens, computed by Pack if 0
+}
+
+// Packer packs items into a token budget of a model.
+type Packer struct {
+	Model  string
+	Budget int
+}
+
+func NewPacker(model string, budget int) *Packer {
+	return &Packer{Model: model, Budget: budget}
+}
+
+// Pack picks items by priority, higher first and ties in input order,
+// skipping those that do not fit into the remaining budget.   The picked
+// items are returned in input order, with the total number of tokens.
+func (p *Packer) Pack(items []BudgetItem) ([]BudgetItem, int) {
+	// count into a copy, items belong to the caller.
+	items = slices.Clone(items)
+	order := make([]int, len(items))
+	for i := range items {
+		order[i] = i
+		if items[i].Tokens == 0 {
+			items[i].Tokens = CountTokens(p.Model, items[i].Text)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return items[order[i]].Priority > items[order[j]].Priority
+	})
+
+	picked := make([]bool, len(items))
+	used := 0
+	for _, i := range order {
+		if used+items[i].Tokens <= p.Budget {
+			picked[i] = true
+			used += items[i].Tokens
+		}
+	}
+
+	var ret []BudgetItem
+	for i, item := range items {
+		if picked[i] {
+			ret = append(ret, item)
+		}
+	}
+	return ret, used
+}
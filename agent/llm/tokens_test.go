@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+)
+
+func TestCountTokens(t *testing.T) {
+	common.Assert(t, LookupModel("qwen2.5:14b").Prefix == "qwen2.5", "Unexpected model info")
+	common.Assert(t, ContextWindow("unknown-model") == DefaultNumCtx, "Unexpected context window")
+
+	n := CountTokens("qwen2.5:14b", "What is the capital of France?")
+	common.Assert(t, n == 9, "Expected 9 tokens, got %d", n)
+	n = CountTokens("llama3.2", "红楼梦")
+	common.Assert(t, n == 3, "Expected 3 tokens, got %d", n)
+	common.Assert(t, CountTokens("phi4", "") == 0, "Expected 0 tokens")
+
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 100)
+	cut := TruncateTokens("phi4", text, 50)
+	common.Assert(t, CountTokens("phi4", cut) <= 50, "Truncated text too long")
+	common.Assert(t, strings.HasSuffix(cut, "\n"), "Expected cut at line boundary")
+}
+
+func TestPacker(t *testing.T) {
+	items := []BudgetItem{
+		{Key: "content", Text: "c1", Priority: 1, Tokens: 40},
+		{Key: "info", Text: "i1", Priority: 3, Tokens: 30},
+		{Key: "summary", Text: "s1", Priority: 2, Tokens: 50},
+		{Key: "info", Text: "i2", Priority: 3, Tokens: 10},
+		{Key: "content", Text: "c2", Priority: 1, Tokens: 5},
+	}
+	picked, used := NewPacker("phi4", 100).Pack(items)
+	common.Assert(t, used == 95, "Expected 95 tokens, got %d", used)
+	var texts []string
+	for _, item := range picked {
+		texts = append(texts, item.Text)
+	}
+	// c1 does not fit after info and summary, c2 does.
+	common.Assert(t, strings.Join(texts, ",") == "i1,s1,i2,c2", "Unexpected picked %v", texts)
+
+	// tokens are counted, but not into the caller's items.
+	items = []BudgetItem{{Key: "info", Text: "the quick brown fox"}}
+	picked, used = NewPacker("phi4", 100).Pack(items)
+	common.Assert(t, len(picked) == 1 && picked[0].Tokens == used && used > 0, "Unexpected picked %v, %d", picked, used)
+	common.Assert(t, items[0].Tokens == 0, "Pack changed the items %v", items)
+}
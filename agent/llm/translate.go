@@ -0,0 +1,412 @@
+package llm
+
+//
+// Translation of chunks.  Paragraphs are batched into requests that fit
+// a token budget, the model returns one translation per paragraph, so
+// that the output is aligned with the input, chunk by chunk.
+//
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/textu/chunk"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	LangEnglish = "English"
+	LangChinese = "Chinese"
+)
+
+// DetectLang returns Chinese if at least a third of the letters of text
+// are CJK, English otherwise.
+func DetectLang(text string) string {
+	cjk, letters := 0, 0
+	for _, r := range text {
+		switch {
+		case isCJKRune(r):
+			cjk++
+			letters++
+		case unicode.IsLetter(r):
+			letters++
+		}
+	}
+	if letters > 0 && 3*cjk >= letters {
+		return LangChinese
+	}
+	return LangEnglish
+}
+
+// otherLang is the default target language of source.
+func otherLang(source string) string {
+	if source == LangChinese {
+		return LangEnglish
+	}
+	return LangChinese
+}
+
+// Translation is a chunk, with its source text, and its translation.
+type Translation struct {
+	chunk.Chunk
+	Source      string `json:"source"` // language of Text
+	Target      string `json:"target"` // language of Translation
+	Translation string `json:"translation"`
+}
+
+// Translator translates chunks in batches.
+type Translator struct {
+	Model string
+	// Source is the language of the chunks, empty detects it per chunk.
+	Source string
+	// Target is the language to translate to, empty is English for
+	// Chinese text and Chinese otherwise.
+	Target string
+	// Budget is the number of tokens of source text in one request.
+	Budget  int
+	Options ChatOptions
+
+	// translate translates texts, tests replace it.
+	translate func(texts []string, source, target string) ([]string, error)
+}
+
+// NewTranslator creates a translator, the budget is a quarter of the
+// default context window of model, leaving room for the translation.
+func NewTranslator(model, target string) *Translator {
+	t := &Translator{Model: model, Target: target}
+	t.Budget = min(ContextWindow(model), 8192) / 4
+	t.translate = t.chat
+	return t
+}
+
+// chat asks the model to translate texts, one translation per text.
+func (t *Translator) chat(texts []string, source, target string) ([]string, error) {
+	prompts, err := DefaultPrompts()
+	if err != nil {
+		return nil, err
+	}
+	paras, err := json.MarshalIndent(texts, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	content, err := prompts.Render("translate.batch", map[string]any{
+		"Source":     source,
+		"Target":     target,
+		"Count":      len(texts),
+		"Paragraphs": string(paras),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Translations []string `json:"translations"`
+	}
+	sc, err := NewStructuredChat(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := t.Options
+	if opts.NumCtx == nil {
+		numCtx := min(ContextWindow(t.Model), max(DefaultNumCtx, 4*t.Budget))
+		opts.NumCtx = &numCtx
+	}
+	req := api.ChatRequest{
+		Model:    t.Model,
+		Stream:   new(bool),
+		Messages: []api.Message{{Role: "user", Content: content}},
+	}
+	if err = opts.Apply(&req); err != nil {
+		return nil, err
+	}
+	if _, err = sc.Chat(context.Background(), &req, &reply); err != nil {
+		return nil, err
+	}
+	slog.Debug("Translator translate", "texts", len(texts), "translations", len(reply.Translations))
+	return reply.Translations, nil
+}
+
+// isSentenceEnd reports if r ends a sentence, latin or CJK.
+func isSentenceEnd(r rune) bool {
+	return strings.ContainsRune(".!?。！？；…", r)
+}
+
+// splitSentences splits text into pieces of whole sentences that fit
+// into budget.  A sentence larger than budget is a piece of its own.
+func splitSentences(model, text string, budget int) []string {
+	var sentences []string
+	start := 0
+	rs := []rune(text)
+	for i, r := range rs {
+		if isSentenceEnd(r) && (i+1 == len(rs) || !isSentenceEnd(rs[i+1])) {
+			sentences = append(sentences, string(rs[start:i+1]))
+			start = i + 1
+		}
+	}
+	if start < len(rs) {
+		sentences = append(sentences, string(rs[start:]))
+	}
+
+	var pieces []string
+	piece := ""
+	for _, s := range sentences {
+		if piece != "" && CountTokens(model, piece+s) > budget {
+			pieces = append(pieces, strings.TrimSpace(piece))
+			piece = ""
+		}
+		piece += s
+	}
+	if strings.TrimSpace(piece) != "" {
+		pieces = append(pieces, strings.TrimSpace(piece))
+	}
+	return pieces
+}
+
+// segment is a piece of the text of a chunk.
+type segment struct {
+	chunk  int // index of the chunk
+	source string
+	text   string
+	ntk    int
+}
+
+// segments splits the chunks into segments that fit the budget.
+func (t *Translator) segments(chunks []chunk.Chunk) []segment {
+	var segs []segment
+	for i, c := range chunks {
+		text := strings.TrimSpace(strings.TrimLeft(c.Text, " 　"))
+		if text == "" {
+			continue
+		}
+		source := t.Source
+		if source == "" {
+			source = DetectLang(text)
+		}
+
+		pieces := []string{text}
+		if CountTokens(t.Model, text) > t.Budget {
+			pieces = splitSentences(t.Model, text, t.Budget)
+		}
+		for _, p := range pieces {
+			segs = append(segs, segment{chunk: i, source: source, text: p, ntk: CountTokens(t.Model, p)})
+		}
+	}
+	return segs
+}
+
+// batch translates segments, a batch whose translations are not aligned
+// is split in halves and retried.
+func (t *Translator) batch(segs []segment, target string) ([]string, error) {
+	texts := make([]string, len(segs))
+	for i, s := range segs {
+		texts[i] = s.text
+	}
+	ret, err := t.translate(texts, segs[0].source, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) == len(segs) {
+		return ret, nil
+	}
+
+	if len(segs) == 1 {
+		// one text, keep whatever the model made of it.
+		return []string{strings.Join(ret, "\n")}, nil
+	}
+	slog.Debug("Translator misaligned batch, splitting", "texts", len(segs), "translations", len(ret))
+	half := len(segs) / 2
+	first, err := t.batch(segs[:half], target)
+	if err != nil {
+		return nil, err
+	}
+	second, err := t.batch(segs[half:], target)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// Translate translates chunks, the translations are in the order of the
+// chunks, a chunk with empty text has an empty translation.
+func (t *Translator) Translate(chunks []chunk.Chunk) ([]Translation, error) {
+	ret := make([]Translation, len(chunks))
+	for i, c := range chunks {
+		ret[i].Chunk = c
+	}
+
+	segs := t.segments(chunks)
+	translated := make([][]string, len(chunks))
+	for start := 0; start < len(segs); {
+		// a batch fits the budget and has one source language.
+		source := segs[start].source
+		target := t.Target
+		if target == "" {
+			target = otherLang(source)
+		}
+		end, used := start, 0
+		for ; end < len(segs) && segs[end].source == source; end++ {
+			if end > start && used+segs[end].ntk > t.Budget {
+				break
+			}
+			used += segs[end].ntk
+		}
+
+		var texts []string
+		if source == target {
+			for _, s := range segs[start:end] {
+				texts = append(texts, s.text)
+			}
+		} else {
+			var err error
+			texts, err = t.batch(segs[start:end], target)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i, s := range segs[start:end] {
+			translated[s.chunk] = append(translated[s.chunk], strings.TrimSpace(texts[i]))
+			ret[s.chunk].Source = source
+			ret[s.chunk].Target = target
+		}
+		start = end
+	}
+
+	for i := range ret {
+		sep := " "
+		if ret[i].Target == LangChinese {
+			sep = ""
+		}
+		ret[i].Translation = strings.Join(translated[i], sep)
+	}
+	return ret, nil
+}
+
+type TranslateConfig struct {
+	Model      string      `json:"model"`
+	Source     string      `json:"source"`
+	Target     string      `json:"target"`
+	Budget     int         `json:"budget"`
+	Options    ChatOptions `json:"options"`
+	StringMode bool        `json:"string_mode"`
+}
+
+// TranslateInput is the output of novelChunker, chunk objects or string
+// mode rows.
+type TranslateInput struct {
+	Data []json.RawMessage `json:"data"`
+}
+
+type TranslateOutput struct {
+	Data []Translation `json:"data"`
+}
+
+// TranslateStrOutput rows are num1, num2, path, title, source language,
+// text, target language, translation.
+type TranslateStrOutput struct {
+	Data [][]string `json:"data"`
+}
+
+type translator struct {
+	agent.NilCloseAgent
+	agent.SimpleExecuteAgent
+	conf TranslateConfig
+}
+
+// NewTranslateAgent creates an agent translating the chunks of each input
+// record.  It yields one record per input record, the translations are
+// aligned with the chunks, so that they can be stored side by side.
+func NewTranslateAgent(model, target string) agent.Agent {
+	ta := &translator{}
+	ta.conf.Model = model
+	ta.conf.Target = target
+	ta.Self = ta
+	return ta
+}
+
+func (t *translator) Config(bs []byte) error {
+	if bs == nil {
+		return nil
+	}
+	err := json.Unmarshal(bs, &t.conf)
+	if err != nil {
+		return err
+	}
+	return t.conf.Options.Validate()
+}
+
+func (t *translator) SetValue(name string, value any) error {
+	switch name {
+	case "model":
+		t.conf.Model = value.(string)
+	case "source":
+		t.conf.Source = value.(string)
+	case "target":
+		t.conf.Target = value.(string)
+	case "budget":
+		t.conf.Budget = value.(int)
+	case "string_mode":
+		t.conf.StringMode = value.(bool)
+	default:
+		return fmt.Errorf("unknown name: %s", name)
+	}
+	return nil
+}
+
+func (t *translator) output(trs []Translation) ([]byte, error) {
+	if !t.conf.StringMode {
+		return json.Marshal(TranslateOutput{Data: trs})
+	}
+	var output TranslateStrOutput
+	for _, tr := range trs {
+		output.Data = append(output.Data, []string{
+			strconv.Itoa(int(tr.Num1)),
+			strconv.Itoa(int(tr.Num2)),
+			tr.Path,
+			tr.Title,
+			tr.Source,
+			tr.Text,
+			tr.Target,
+			tr.Translation,
+		})
+	}
+	return json.Marshal(output)
+}
+
+func (t *translator) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var trInput TranslateInput
+	err := json.Unmarshal(input, &trInput)
+	if err != nil {
+		return err
+	}
+	chunks, err := parseChunks(trInput.Data)
+	if err != nil {
+		return err
+	}
+
+	tr := NewTranslator(t.conf.Model, t.conf.Target)
+	tr.Source = t.conf.Source
+	if t.conf.Budget > 0 {
+		tr.Budget = t.conf.Budget
+	}
+	tr.Options = t.conf.Options
+
+	trs, err := tr.Translate(chunks)
+	if err != nil {
+		return err
+	}
+	bs, err := t.output(trs)
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
+	return nil
+}
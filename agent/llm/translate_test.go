@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matrixorigin/monlp/common"
+	"github.com/matrixorigin/monlp/textu/chunk"
+)
+
+func TestTranslateBatches(t *testing.T) {
+	common.Assert(t, DetectLang("　　话说天下大势，分久必合。") == LangChinese, "Expected Chinese")
+	common.Assert(t, DetectLang("Mr. Jones, of the Manor Farm") == LangEnglish, "Expected English")
+	common.Assert(t, DetectLang("曹雪芹 wrote Dream of the Red Chamber") == LangEnglish, "Expected English")
+
+	pieces := splitSentences(DefaultModel, "One two. Three four! Five six? Seven", 4)
+	common.Assert(t, len(pieces) == 4 && pieces[1] == "Three four!", "Unexpected pieces %q", pieces)
+	pieces = splitSentences(DefaultModel, "甲乙。丙丁！！戊己", 100)
+	common.Assert(t, len(pieces) == 1, "Unexpected pieces %q", pieces)
+
+	tr := NewTranslator(DefaultModel, "")
+	tr.Budget = 13
+	var batches []string
+	tr.translate = func(texts []string, source, target string) ([]string, error) {
+		batches = append(batches, fmt.Sprintf("%s>%s:%d", source, target, len(texts)))
+		var ret []string
+		for _, text := range texts {
+			ret = append(ret, "T("+text+")")
+		}
+		// misaligned, the batch must be split.
+		if len(texts) == 3 {
+			return ret[:2], nil
+		}
+		return ret, nil
+	}
+
+	chunks := []chunk.Chunk{
+		{Num1: 0, Num2: 1, Text: " Alpha beta."},
+		{Num1: 0, Num2: 2, Text: " Gamma delta."},
+		{Num1: 0, Num2: 3, Text: " Epsilon zeta."},
+		{Num1: 1, Num2: 1, Text: "　　甲乙丙丁。"},
+		{Num1: 1, Num2: 2, Text: ""},
+		{Num1: 1, Num2: 3, Text: " One two three four five. Six seven eight nine ten."},
+	}
+	trs, err := tr.Translate(chunks)
+	common.PanicAssert(t, err == nil, "Translate failed: %v", err)
+	common.Assert(t, len(trs) == len(chunks), "Expected %d translations, got %d", len(chunks), len(trs))
+
+	expect := "English>Chinese:3 English>Chinese:1 English>Chinese:2 Chinese>English:1 English>Chinese:1 English>Chinese:1"
+	common.Assert(t, strings.Join(batches, " ") == expect, "Unexpected batches %v", batches)
+	common.Assert(t, trs[1].Num2 == 2 && trs[1].Translation == "T(Gamma delta.)", "Unexpected translation %v", trs[1])
+	common.Assert(t, trs[3].Source == LangChinese && trs[3].Translation == "T(甲乙丙丁。)", "Unexpected translation %v", trs[3])
+	common.Assert(t, trs[4].Translation == "", "Unexpected translation %v", trs[4])
+	common.Assert(t, trs[5].Translation == "T(One two three four five.)T(Six seven eight nine ten.)", "Unexpected translation %v", trs[5])
+
+	// nothing to translate.
+	tr.Target = LangEnglish
+	batches = nil
+	trs, err = tr.Translate(chunks[:1])
+	common.Assert(t, err == nil && len(batches) == 0 && trs[0].Translation == "Alpha beta.", "Unexpected translation %v", trs)
+}
+
+func TestTranslateNovel(t *testing.T) {
+	useFixtures(t)
+	f, err := os.Open(common.ProjectPath("data", "红楼梦.txt"))
+	common.PanicAssert(t, err == nil, "Open failed: %v", err)
+	defer f.Close()
+
+	ck, err := chunk.NewNovelChunker(f)
+	common.PanicAssert(t, err == nil, "NewNovelChunker failed: %v", err)
+	chunks := slices.Collect(ck.Chunk())
+	chunks = chunks[:min(len(chunks), 4)]
+	input, err := json.Marshal(map[string]any{"data": chunks})
+	common.PanicAssert(t, err == nil, "Marshal failed: %v", err)
+
+	ta := NewTranslateAgent(DefaultModel, LangEnglish)
+	err = ta.Config([]byte(`{"string_mode": true, "budget": 500}`))
+	common.PanicAssert(t, err == nil, "Config failed: %v", err)
+
+	err = ta.ExecuteOne(input, nil, func(data []byte, err error) bool {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		var out TranslateStrOutput
+		err = json.Unmarshal(data, &out)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, len(out.Data) == len(chunks), "Expected %d rows, got %d", len(chunks), len(out.Data))
+		for _, row := range out.Data {
+			t.Logf("%s/%s %s | %s", row[0], row[1], row[5], row[7])
+		}
+		return true
+	})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+}
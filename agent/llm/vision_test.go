@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+// writeTestImage writes a red square with a blue bar as png.
+func writeTestImage(t *testing.T) string {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			c := color.RGBA{R: 255, A: 255}
+			if y >= 24 && y < 40 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	fn := filepath.Join(t.TempDir(), "red.png")
+	f, err := os.Create(fn)
+	common.PanicAssert(t, err == nil, "Create failed: %v", err)
+	defer f.Close()
+	err = png.Encode(f, img)
+	common.PanicAssert(t, err == nil, "Encode failed: %v", err)
+	return fn
+}
+
+func TestImageInput(t *testing.T) {
+	fn := writeTestImage(t)
+	bs, err := os.ReadFile(fn)
+	common.PanicAssert(t, err == nil, "ReadFile failed: %v", err)
+	b64 := base64.StdEncoding.EncodeToString(bs)
+
+	for _, ref := range []string{"file://" + fn, "data:image/png;base64," + b64, b64} {
+		img, err := LoadImage(ref)
+		common.Assert(t, err == nil, "Expected nil, got %v", err)
+		common.Assert(t, string(img) == string(bs), "Unexpected image of %.32s", ref)
+	}
+	_, err = LoadImage("data:image/png,notbase64")
+	common.Assert(t, err != nil, "Expected invalid data url error")
+	_, err = LoadImage("file:///no/such/image.png")
+	common.Assert(t, err != nil, "Expected file not found error")
+
+	msgs := []api.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "what is this?"},
+		{Role: "assistant", Content: "a square"},
+	}
+	err = AttachImages(msgs, []string{b64})
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+	common.Assert(t, len(msgs[1].Images) == 1 && len(msgs[2].Images) == 0, "Images attached to wrong message")
+	err = AttachImages(msgs[:1], []string{b64})
+	common.Assert(t, err != nil, "Expected no user message error")
+
+	// images in chat input
+	var ci ChatInput
+	err = json.Unmarshal([]byte(`{"messages": [{"role": "user", "content": "hi"}], "images": ["`+b64+`"]}`), &ci)
+	common.Assert(t, err == nil && len(ci.Images) == 1, "Unexpected chat input %v, %v", ci, err)
+
+	va := NewImageCaptioner(DefaultVisionModel, VisionOCR).(*visioner)
+	chunks := va.imageChunks(2, "file://"+fn, "Chapter 1\n\nIt was a bright cold day\nin April.\n\n\nThe end.")
+	common.Assert(t, len(chunks) == 3, "Expected 3 chunks, got %v", chunks)
+	common.Assert(t, chunks[1].Num1 == 2 && chunks[1].Num2 == 1 && chunks[1].Text == "It was a bright cold day in April.", "Unexpected chunk %v", chunks[1])
+	common.Assert(t, chunks[2].Path == "file://"+fn, "Unexpected path %s", chunks[2].Path)
+
+	va.SetValue("mode", "nosuchmode")
+	_, err = va.prompt()
+	common.Assert(t, err != nil, "Expected unknown mode error")
+}
+
+func TestCaptionImage(t *testing.T) {
+	useFixtures(t)
+	fn := writeTestImage(t)
+	stra := agent.NewStringArrayAgent([]string{
+		`{"data": ["file://` + fn + `"]}`,
+	})
+	va := NewImageCaptioner(DefaultVisionModel, VisionCaption)
+
+	var pipe agent.AgentPipe
+	pipe.AddAgent(stra)
+	pipe.AddAgent(va)
+	defer pipe.Close()
+
+	it, err := pipe.Execute(nil, nil)
+	common.Assert(t, err == nil, "Expected nil, got %v", err)
+
+	for data, err := range it {
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		var output VisionOutput
+		err = json.Unmarshal(data, &output)
+		common.PanicAssert(t, err == nil, "Expected nil, got %v", err)
+		common.PanicAssert(t, len(output.Data) == 1, "Expected 1 chunk, got %d", len(output.Data))
+		t.Logf("Caption: %s", output.Data[0].Text)
+	}
+}
@@ -15,6 +15,14 @@ var (
 	ErrNoPageFound = errors.New("No page found")
 )
 
+// The wikipedia lookups used by WikiX, tests replace them to replay
+// recorded pages.
+var (
+	wikiTitle   = GetWikiTitle
+	wikiText    = GetWikiText
+	wikiContent = GetWikiContent
+)
+
 // WikiQueryResult is the result of a query to the Wikipedia API
 // It is used to parse the JSON response from the API.
 // Just enough for the GetWikiText, not a complete struct
@@ -53,6 +61,15 @@ func GetWikiTitle(old string) (string, error) {
 	return sr[0], nil
 }
 
+// GetWikiContent returns the plain text content of a page.
+func GetWikiContent(title string) (string, error) {
+	page, err := gowiki.GetPage(title, -1, false, true)
+	if err != nil {
+		return "", err
+	}
+	return page.GetContent()
+}
+
 func GetWikiText(title string) (string, error) {
 	args := map[string]string{
 		"action":        "query",
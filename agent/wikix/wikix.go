@@ -6,16 +6,15 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/matrixorigin/monlp/agent"
 	"github.com/matrixorigin/monlp/agent/dbagent"
-	"github.com/matrixorigin/monlp/common"
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/textu/chunk"
 	"github.com/matrixorigin/monlp/textu/extract"
 	"github.com/ollama/ollama/api"
-	gowiki "github.com/trietmn/go-wiki"
 )
 
 // WikiX is the wiki explorer agent.   It take the same input/output
@@ -58,6 +57,15 @@ DO NOT make up information.
 `
 )
 
+const (
+	// DefaultMaxRounds is the number of sub question rounds before WikiX
+	// gives up.
+	DefaultMaxRounds = 2
+	// DefaultNumCtx caps the context size of WikiX requests, larger
+	// context needs a lot more memory.
+	DefaultNumCtx = 8192
+)
+
 type WikixInfo struct {
 	// Original User query
 	UserQuery string `json:"user_query"`
@@ -80,28 +88,65 @@ func (wi *WikixInfo) clear() {
 	*wi = WikixInfo{}
 }
 
-func (wi *WikixInfo) GetTopicsString() string {
+// TopicsString packs the infobox entries, summaries and content
+// paragraphs of topics into a token budget of model.  Infobox entries go first, then summaries, then content, with
+// earlier topics and paragraphs first.
+func (wi *WikixInfo) TopicsString(model string, budget int) string {
+	var items []llm.BudgetItem
+	overhead := llm.CountTokens(model, "<topics>\n</topics>\n")
+	for ti, topic := range wi.Topics {
+		overhead += llm.CountTokens(model, fmt.Sprintf(topicSkeleton, topic.Title))
+		for _, entry := range strings.SplitAfter(topic.WikiInfoBox, "</entry>\n") {
+			if entry != "" {
+				items = append(items, llm.BudgetItem{Key: topicKey(ti, "info"), Text: entry, Priority: 3000 - ti})
+			}
+		}
+		if topic.Summary != "" {
+			items = append(items, llm.BudgetItem{Key: topicKey(ti, "summary"), Text: topic.Summary, Priority: 2000 - ti})
+		}
+		pi := 0
+		for _, para := range strings.Split(topic.Content, "\n") {
+			if strings.TrimSpace(para) != "" {
+				items = append(items, llm.BudgetItem{Key: topicKey(ti, "content"), Text: para + "\n", Priority: 1000 - pi*len(wi.Topics) - ti})
+				pi++
+			}
+		}
+	}
+
+	picked, _ := llm.NewPacker(model, budget-overhead).Pack(items)
+	sections := make(map[string]string)
+	for _, item := range picked {
+		sections[item.Key] += item.Text
+	}
+
 	buf := &strings.Builder{}
 	fmt.Fprintf(buf, "<topics>\n")
-	for _, topic := range wi.Topics {
+	for ti, topic := range wi.Topics {
 		fmt.Fprintf(buf, "<topic>\n")
 		fmt.Fprintf(buf, "<title>%s</title>\n", topic.Title)
 		fmt.Fprintf(buf, "<info>\n")
-		fmt.Fprintf(buf, "%s", topic.WikiInfoBox)
+		fmt.Fprintf(buf, "%s", sections[topicKey(ti, "info")])
 		fmt.Fprintf(buf, "</info>\n")
-		// if we put the content in the xml, it will be too large
-		// and llm will not be able to retrieve the infobox.
-		// maybe it is better to split the content into smaller
-		// chunks.
-		// fmt.Fprintf(buf, "<content>\n")
-		// fmt.Fprintf(buf, "%s", topic.Content)
-		// fmt.Fprintf(buf, "</content>\n")
+		if summary := sections[topicKey(ti, "summary")]; summary != "" {
+			fmt.Fprintf(buf, "<summary>\n%s</summary>\n", summary)
+		}
+		if content := sections[topicKey(ti, "content")]; content != "" {
+			fmt.Fprintf(buf, "<content>\n%s</content>\n", content)
+		}
 		fmt.Fprintf(buf, "</topic>\n")
 	}
 	fmt.Fprintf(buf, "</topics>\n")
 	return buf.String()
 }
 
+// topicSkeleton is a topic without content, to count the tokens of
+// the xml tags.
+const topicSkeleton = "<topic>\n<title>%s</title>\n<info>\n</info>\n<summary>\n</summary>\n<content>\n</content>\n</topic>\n"
+
+func topicKey(ti int, section string) string {
+	return fmt.Sprintf("%d/%s", ti, section)
+}
+
 func (wi *WikixInfo) GetSubqueriesString() string {
 	buf := &strings.Builder{}
 	fmt.Fprintf(buf, "<subqueries>\n")
@@ -130,12 +175,18 @@ type WikiX struct {
 	model     string
 	sysPrompt string
 
-	dir         string
-	topicsTmpl  *template.Template
-	linksTmpl   *template.Template
-	subqTmpl    *template.Template
-	summaryTmpl *template.Template
-	finalTmpl   *template.Template
+	dir       string
+	prompts   *llm.PromptRegistry
+	maxRounds int
+	numCtx    int
+	// samples is the number of samples of the final answer, voted for
+	// self-consistency.
+	samples int
+
+	// transcript, if set, records every answered query, with the llm
+	// messages of all steps in trace.
+	transcript llm.Recorder
+	trace      []api.Message
 }
 
 func NewWikiX(dir string, model, sysprompt string) (*WikiX, error) {
@@ -143,39 +194,19 @@ func NewWikiX(dir string, model, sysprompt string) (*WikiX, error) {
 		dir:       dir,
 		model:     model,
 		sysPrompt: sysprompt,
+		maxRounds: DefaultMaxRounds,
 	}
 	ca.Self = ca
 
 	var err error
 
-	driver, connstr := common.DbConnInfoForTest()
-	ca.db, err = dbagent.OpenDB(driver, connstr)
+	ca.db, err = dbagent.OpenProfile("")
 	if err != nil {
 		return nil, err
 	}
 
-	// read a bunch of prompt templates
-	ca.topicsTmpl, err = ca.loadTemp("wikix_topics.txt")
-	if err != nil {
-		return nil, err
-	}
-
-	ca.linksTmpl, err = ca.loadTemp("wikix_links.txt")
-	if err != nil {
-		return nil, err
-	}
-
-	ca.subqTmpl, err = ca.loadTemp("wikix_subq.txt")
-	if err != nil {
-		return nil, err
-	}
-
-	ca.summaryTmpl, err = ca.loadTemp("wikix_summary.txt")
-	if err != nil {
-		return nil, err
-	}
-
-	ca.finalTmpl, err = ca.loadTemp("wikix_final.txt")
+	// prompt templates, see prompts.json in dir.
+	ca.prompts, err = llm.LoadPrompts(os.DirFS(dir))
 	if err != nil {
 		return nil, err
 	}
@@ -183,17 +214,6 @@ func NewWikiX(dir string, model, sysprompt string) (*WikiX, error) {
 	return ca, err
 }
 
-func (c *WikiX) loadTemp(fn string) (*template.Template, error) {
-	fullFn := filepath.Join(c.dir, fn)
-	bs, err := os.ReadFile(fullFn)
-	if err != nil {
-		return nil, err
-	}
-
-	t, err := template.New(fn).Parse(string(bs))
-	return t, err
-}
-
 func (c *WikiX) SetValue(name string, value any) error {
 	c.info.clear()
 	switch name {
@@ -203,28 +223,164 @@ func (c *WikiX) SetValue(name string, value any) error {
 		c.sysPrompt = value.(string)
 	case "userquery":
 		c.info.UserQuery = value.(string)
+	case "maxrounds":
+		c.maxRounds = value.(int)
+	case "numctx":
+		c.numCtx = value.(int)
+	case "samples":
+		c.samples = value.(int)
+	case "transcript":
+		rec, ok := value.(llm.Recorder)
+		if !ok {
+			return fmt.Errorf("invalid transcript %T", value)
+		}
+		c.transcript = rec
 	default:
 		return fmt.Errorf("unknown name: %s", name)
 	}
 	return nil
 }
 
+// ExecuteOne answers the last user message of a llm.ChatInput, and
+// yields a llm.ChatOutput.
 func (c *WikiX) ExecuteOne(input []byte, dict map[string]string, yield func([]byte, error) bool) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	var chatInput llm.ChatInput
+	err := json.Unmarshal(input, &chatInput)
+	if err != nil {
+		return err
+	}
+
+	var query string
+	for _, msg := range chatInput.Messages {
+		if msg.Role == "user" {
+			query = msg.Content
+		}
+	}
+	if query == "" {
+		return fmt.Errorf("no user query")
+	}
+
+	answer, err := c.Answer(query)
+	if err != nil {
+		return err
+	}
+
+	var output llm.ChatOutput
+	output.Response.Model = c.model
+	output.Response.Message = api.Message{Role: "assistant", Content: answer}
+	output.Response.Done = true
+	bs, err := json.Marshal(output)
+	if !yield(bs, err) {
+		return agent.ErrYieldDone
+	}
 	return nil
 }
 
-func (c *WikiX) chatWithLLM(umsgs []api.Message, fn func(api.ChatResponse) error) error {
-	cli, err := api.ClientFromEnvironment()
+// Answer explores the wikipedia to answer query.   If the retrieved
+// topics are not enough, the query is decomposed into sub questions,
+// which are answered in turn, up to maxRounds rounds.
+func (c *WikiX) Answer(query string) (string, error) {
+	c.trace = nil
+	start := time.Now()
+	answer, err := c.answer(query)
+
+	if c.transcript != nil {
+		messages, _ := json.Marshal(c.trace)
+		rerr := c.transcript.RecordChat(query, answer, c.model, messages, nil, time.Since(start), err)
+		if rerr != nil {
+			slog.Warn("WikiX failed to record transcript", "model", c.model, "err", rerr)
+		}
+	}
+	return answer, err
+}
+
+func (c *WikiX) answer(query string) (string, error) {
+	c.info.clear()
+	c.info.UserQuery = query
+
+	err := c.runTopics()
+	if err != nil && err != ErrNoPageFound {
+		return "", err
+	}
+	if err = c.runSummarize(); err != nil {
+		return "", err
+	}
+
+	for round := 0; ; round++ {
+		if err = c.runFinal(); err != nil {
+			return "", err
+		}
+		if c.info.FinalAnswer != "" || round >= c.maxRounds {
+			break
+		}
+
+		subq, err := c.runSubq()
+		if err != nil {
+			return "", err
+		}
+		if len(subq.SubQuestions) == 0 {
+			break
+		}
+		if err = c.answerSubq(subq.SubQuestions); err != nil {
+			return "", err
+		}
+	}
+	return c.info.FinalAnswer, nil
+}
+
+// answerSubq answers the sub questions one by one, retrieving topics for
+// each of them.   The answers are kept in info.Subqueries.
+func (c *WikiX) answerSubq(questions []string) error {
+	query := c.info.UserQuery
+	defer func() {
+		c.info.UserQuery = query
+		c.info.FinalAnswer = ""
+	}()
+
+	for _, q := range questions {
+		c.info.UserQuery = q
+		c.info.FinalAnswer = ""
+		err := c.runTopics()
+		if err != nil && err != ErrNoPageFound {
+			return err
+		}
+		if err = c.runFinal(); err != nil {
+			return err
+		}
+
+		answer := c.info.FinalAnswer
+		if answer == "" {
+			answer = "NOT ENOUGH INFORMATION"
+		}
+		c.info.Subqueries.SubQuestions = append(c.info.Subqueries.SubQuestions, q)
+		c.info.Subqueries.SubAnswers = append(c.info.Subqueries.SubAnswers, answer)
+	}
+	return nil
+}
+
+func (c *WikiX) chatWithLLM(step string, umsgs []api.Message, dest any) error {
+	return c.sampleLLM(step, umsgs, dest, nil)
+}
+
+// sampleLLM is chatWithLLM with options overriding the defaults.
+func (c *WikiX) sampleLLM(step string, umsgs []api.Message, dest any, opts *llm.ChatOptions) error {
+	sc, err := llm.NewStructuredChat(dest)
 	if err != nil {
 		return err
 	}
 
+	numCtx := c.contextSize()
+	reqOpts := llm.ChatOptions{NumCtx: &numCtx}.Merge(opts)
 	req := api.ChatRequest{
 		Model:  c.model,
 		Stream: new(bool), // stream response default to false
-		Options: map[string]interface{}{
-			"temperature": common.LLMTemp,
-		},
+	}
+	if err = reqOpts.Apply(&req); err != nil {
+		return err
 	}
 
 	sysmsg := api.Message{
@@ -235,62 +391,23 @@ func (c *WikiX) chatWithLLM(umsgs []api.Message, fn func(api.ChatResponse) error
 	req.Messages = append(req.Messages, umsgs...)
 
 	ctx := context.Background()
-	return cli.Chat(ctx, &req, fn)
-}
-
-func (c *WikiX) extractJosnPart(model, data string, dest any) error {
-	if true || model == "phi4" {
-		str := strings.Split(data, "\n")
-		buf := &strings.Builder{}
-		output := false
-		for _, line := range str {
-			if strings.HasPrefix(line, "```") {
-				output = !output
-				continue
-			}
-			if output {
-				buf.WriteString(line)
-				buf.WriteString("\n")
-			}
-		}
-		data = buf.String()
-	} else {
-		str := strings.Split(data, "\n")
-		buf := &strings.Builder{}
-		output := false
-
-		// we expect the final out put will be an valid json object or arrary.
-		// we expect it ALWAYS begin with a new line with "{" or "[".
-		// if strings.HasPrefix(model, "deepseek-r1") {
-		//    filter <think></think>
-		//
-		for _, line := range str {
-			if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") {
-				output = true
-			}
-
-			if output {
-				buf.WriteString(line)
-				buf.WriteString("\n")
-			}
-		}
-		data = buf.String()
+	resp, err := sc.Chat(ctx, &req, dest)
+	c.trace = append(c.trace, umsgs...)
+	if resp != nil {
+		slog.Debug(step, "resp", resp.Message.Content)
+		c.trace = append(c.trace, resp.Message)
 	}
-	err := json.Unmarshal([]byte(data), dest)
-	if err != nil {
-		return fmt.Errorf("extractJosnPart: %v", err)
-	}
-	return nil
+	return err
 }
 
 func (c *WikiX) runTopics() error {
-	buf := &strings.Builder{}
-	err := c.topicsTmpl.Execute(buf, &c.info)
+	content, err := c.prompts.Render("wikix.topics", map[string]any{
+		"UserQuery": c.info.UserQuery,
+	})
 	if err != nil {
 		return err
 	}
 
-	content := buf.String()
 	umsgs := []api.Message{
 		{
 			Role:    "user",
@@ -298,22 +415,19 @@ func (c *WikiX) runTopics() error {
 		},
 	}
 
-	var topics []WikixTopic
-
-	slog.Debug("RunTopic on", "content", content)
-	fn := func(resp api.ChatResponse) error {
-		slog.Debug("LLM Response", "msg", resp.Message.Content)
-		// break content into lines
-		return c.extractJosnPart(c.model, resp.Message.Content, &topics)
+	var topics []struct {
+		Title string `json:"title"`
 	}
 
-	err = c.chatWithLLM(umsgs, fn)
+	slog.Debug("RunTopic on", "content", content)
+	err = c.chatWithLLM("runTopics", umsgs, &topics)
 	if err != nil {
 		return err
 	}
 
 	// build topics in context.
-	for _, topic := range topics {
+	for _, t := range topics {
+		topic := WikixTopic{Title: t.Title}
 		dup := false
 		for _, existing := range c.info.Topics {
 			if existing.Title == topic.Title {
@@ -323,7 +437,7 @@ func (c *WikiX) runTopics() error {
 		}
 
 		if !dup {
-			topic.WikiTitle, err = GetWikiTitle(topic.Title)
+			topic.WikiTitle, err = wikiTitle(topic.Title)
 			if err != nil {
 				return err
 			}
@@ -334,7 +448,7 @@ func (c *WikiX) runTopics() error {
 				}
 			}
 
-			topic.WikiText, err = GetWikiText(topic.WikiTitle)
+			topic.WikiText, err = wikiText(topic.WikiTitle)
 			if err != nil {
 				return err
 			}
@@ -346,11 +460,10 @@ func (c *WikiX) runTopics() error {
 			}
 			topic.WikiInfoBox = buf.String()
 
-			page, err := gowiki.GetPage(topic.WikiTitle, -1, false, true)
+			topic.Content, err = wikiContent(topic.WikiTitle)
 			if err != nil {
 				return err
 			}
-			topic.Content, err = page.GetContent()
 
 			if !dup {
 				c.info.Topics = append(c.info.Topics, topic)
@@ -383,16 +496,32 @@ func shortenString(s string, n int) string {
 }
 
 func (c *WikiX) summarizeArticle(article, query string) (string, error) {
-	buf := &strings.Builder{}
-	err := c.summaryTmpl.Execute(buf, map[string]string{
-		"Article":  article,
+	vars := map[string]any{
+		"Article":  "",
 		"Question": query,
-	})
+	}
+	base, err := c.prompts.Render("wikix.summary", vars)
+	if err != nil {
+		return "", err
+	}
+
+	// the article may not fit into the context window, reduce it with
+	// query focused map-reduce summaries first.
+	budget := c.promptBudget() - llm.CountTokens(c.model, base)
+	if llm.CountTokens(c.model, article) > budget {
+		slog.Info("summarizeArticle map-reduce", "budget", budget, "size", len(article))
+		article, err = c.reduceArticle(article, query, budget)
+		if err != nil {
+			return "", err
+		}
+	}
+	vars["Article"] = llm.TruncateTokens(c.model, article, budget)
+
+	content, err := c.prompts.Render("wikix.summary", vars)
 	if err != nil {
 		return "", err
 	}
 
-	content := buf.String()
 	umsgs := []api.Message{
 		{
 			Role:    "user",
@@ -404,12 +533,7 @@ func (c *WikiX) summarizeArticle(article, query string) (string, error) {
 	ret := &strings.Builder{}
 
 	slog.Debug("summarizeArticle", "article", shortenString(article, 30))
-	fn := func(resp api.ChatResponse) error {
-		slog.Debug("summarizeArticle", "resp", resp.Message.Content)
-		return c.extractJosnPart(c.model, resp.Message.Content, &summaries)
-	}
-
-	err = c.chatWithLLM(umsgs, fn)
+	err = c.chatWithLLM("summarizeArticle", umsgs, &summaries)
 
 	for _, summary := range summaries {
 		ret.WriteString(summary)
@@ -419,14 +543,63 @@ func (c *WikiX) summarizeArticle(article, query string) (string, error) {
 	return result, err
 }
 
+// reduceArticle summarizes the paragraphs of article, focused on query,
+// until it fits into budget.
+func (c *WikiX) reduceArticle(article, query string, budget int) (string, error) {
+	ck, err := chunk.NewNovelChunker(strings.NewReader(article))
+	if err != nil {
+		return "", err
+	}
+	var paras []string
+	for p := range ck.Chunk() {
+		paras = append(paras, strings.TrimSpace(p.Text))
+	}
+
+	sz := llm.NewSummarizer(c.model, query)
+	sz.Budget = budget
+	numCtx := c.contextSize()
+	sz.Options.NumCtx = &numCtx
+	return sz.SummarizeText(paras)
+}
+
+// contextSize is the num_ctx of llm requests.
+func (c *WikiX) contextSize() int {
+	if c.numCtx > 0 {
+		return c.numCtx
+	}
+	return min(llm.ContextWindow(c.model), DefaultNumCtx)
+}
+
+// promptBudget is the number of tokens left for the user prompt, the rest
+// of the context is for the system prompt and the answer.
+func (c *WikiX) promptBudget() int {
+	return c.contextSize()*3/4 - llm.CountTokens(c.model, c.sysPrompt)
+}
+
+// renderInfo renders prompt name with the current exploration, topics
+// are packed into what is left of the prompt budget.
+func (c *WikiX) renderInfo(name string) (string, error) {
+	vars := map[string]any{
+		"UserQuery":  c.info.UserQuery,
+		"Topics":     "",
+		"Subqueries": c.info.GetSubqueriesString(),
+	}
+	base, err := c.prompts.Render(name, vars)
+	if err != nil {
+		return "", err
+	}
+
+	budget := c.promptBudget() - llm.CountTokens(c.model, base)
+	vars["Topics"] = c.info.TopicsString(c.model, budget)
+	return c.prompts.Render(name, vars)
+}
+
 func (c *WikiX) runSubq() (subq WikixSubquery, err error) {
-	buf := &strings.Builder{}
-	err = c.subqTmpl.Execute(buf, &c.info)
+	content, err := c.renderInfo("wikix.subq")
 	if err != nil {
 		return
 	}
 
-	content := buf.String()
 	umsgs := []api.Message{
 		{
 			Role:    "user",
@@ -435,23 +608,20 @@ func (c *WikiX) runSubq() (subq WikixSubquery, err error) {
 	}
 
 	slog.Debug("runSubq", "content", content)
-	fn := func(resp api.ChatResponse) error {
-		slog.Debug("runSubq", "resp", resp.Message.Content)
-		return c.extractJosnPart(c.model, resp.Message.Content, &subq)
+	var q struct {
+		SubQuestions []string `json:"sub_questions"`
 	}
-
-	err = c.chatWithLLM(umsgs, fn)
+	err = c.chatWithLLM("runSubq", umsgs, &q)
+	subq.SubQuestions = q.SubQuestions
 	return
 }
 
 func (c *WikiX) runFinal() error {
-	buf := &strings.Builder{}
-	err := c.finalTmpl.Execute(buf, &c.info)
+	content, err := c.renderInfo("wikix.final")
 	if err != nil {
 		return err
 	}
 
-	content := buf.String()
 	umsgs := []api.Message{
 		{
 			Role:    "user",
@@ -460,16 +630,34 @@ func (c *WikiX) runFinal() error {
 	}
 
 	slog.Debug("runFinal", "content", content)
-	var q WikixSubquery
-
-	fn := func(resp api.ChatResponse) error {
-		slog.Debug("runFinal", "resp", resp.Message.Content)
-		return c.extractJosnPart(c.model, resp.Message.Content, &q)
+	var q struct {
+		FinalAnswer string `json:"final_answer"`
 	}
 
-	err = c.chatWithLLM(umsgs, fn)
-	if err != nil {
-		return err
+	if c.samples <= 1 {
+		err = c.chatWithLLM("runFinal", umsgs, &q)
+		if err != nil {
+			return err
+		}
+	} else {
+		// self-consistency, vote on the final answers of the samples.
+		var answers []string
+		for i, opts := range llm.SampleOptions(c.samples) {
+			q.FinalAnswer = ""
+			if err = c.sampleLLM("runFinal", umsgs, &q, opts); err != nil {
+				slog.Warn("runFinal sample failed", "sample", i, "err", err)
+				continue
+			}
+			answers = append(answers, q.FinalAnswer)
+		}
+		if len(answers) == 0 {
+			return err
+		}
+
+		var conf float64
+		votes := llm.Tally(answers, nil)
+		q.FinalAnswer, conf = llm.Majority(votes, len(answers))
+		slog.Debug("runFinal votes", "answer", q.FinalAnswer, "confidence", conf, "votes", votes)
 	}
 
 	if q.FinalAnswer != "NOT ENOUGH INFORMATION" {
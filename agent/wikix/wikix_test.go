@@ -2,7 +2,14 @@ package wikix
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/matrixorigin/monlp/agent"
@@ -28,6 +35,7 @@ func scanQuestionLines() ([]string, error) {
 }
 
 func TestWikiSimple(t *testing.T) {
+	useFixtures(t)
 	// pass in -llm deepseek-r1:14b to use different models
 	common.ParseFlags([]string{"-vv"})
 	qlines, err := scanQuestionLines()
@@ -40,7 +48,8 @@ func TestWikiSimple(t *testing.T) {
 	}
 
 	stra := agent.NewStringArrayAgent(lines)
-	chat := llm.NewChatWithPrompt(common.LLMModel, "You are a helpful assistant.  Please answer questions in one sentence.", nil)
+	chat, err := llm.NewChatWithTemplate(common.LLMModel, "chat.assistant", map[string]any{"Answer": "one sentence"}, nil)
+	common.Assert(t, err == nil, "NewChatWithTemplate failed: %v", err)
 	var pipe agent.AgentPipe
 	pipe.AddAgent(stra)
 	pipe.AddAgent(chat)
@@ -61,7 +70,38 @@ func TestWikiSimple(t *testing.T) {
 	}
 }
 
+func TestPromptFixtures(t *testing.T) {
+	thisDir := common.ProjectPath("agent", "wikix")
+	prompts, err := llm.LoadPrompts(os.DirFS(thisDir))
+	common.PanicAssert(t, err == nil, "LoadPrompts failed: %v", err)
+	err = prompts.CheckFixtures()
+	common.Assert(t, err == nil, "CheckFixtures failed: %v", err)
+}
+
+func TestTopicsString(t *testing.T) {
+	info := WikixInfo{
+		Topics: []WikixTopic{
+			{
+				Title:       "Michael Freedman",
+				WikiInfoBox: "<entry>\n<name>\nknown_for\n</name>\n<value>\nPoincaré conjecture\n</value>\n</entry>\n",
+				Summary:     "Freedman proved the four-dimensional Poincaré conjecture.\n",
+				Content:     strings.Repeat("Michael Hartley Freedman is an American mathematician.\n\n", 100),
+			},
+		},
+	}
+
+	all := info.TopicsString("qwen2.5:14b", 100000)
+	common.Assert(t, strings.Count(all, "American mathematician") == 100, "Expected all content")
+
+	small := info.TopicsString("qwen2.5:14b", 120)
+	common.Assert(t, strings.Contains(small, "known_for"), "Expected infobox, got %s", small)
+	common.Assert(t, strings.Contains(small, "<summary>"), "Expected summary, got %s", small)
+	common.Assert(t, llm.CountTokens("qwen2.5:14b", small) <= 120, "Topics too large: %s", small)
+	t.Logf("Topics: %s", small)
+}
+
 func TestRunTopics(t *testing.T) {
+	useFixtures(t)
 	common.ParseFlags([]string{"-vvv"})
 	thisDir := common.ProjectPath("agent", "wikix")
 	wix, err := NewWikiX(thisDir, common.LLMModel, SystemPrompt)
@@ -83,6 +123,7 @@ func TestRunTopics(t *testing.T) {
 }
 
 func TestInitSummarization(t *testing.T) {
+	useFixtures(t)
 	common.ParseFlags([]string{"-vv"})
 	thisDir := common.ProjectPath("agent", "wikix")
 	wix, err := NewWikiX(thisDir, common.LLMModel, SystemPrompt)
@@ -110,6 +151,7 @@ func TestInitSummarization(t *testing.T) {
 }
 
 func TestInitFinal(t *testing.T) {
+	useFixtures(t)
 	common.ParseFlags([]string{"-vvv"})
 	thisDir := common.ProjectPath("agent", "wikix")
 	wix, err := NewWikiX(thisDir, common.LLMModel, SystemPrompt)
@@ -129,6 +171,7 @@ func TestInitFinal(t *testing.T) {
 }
 
 func TestRunInitSubq(t *testing.T) {
+	useFixtures(t)
 	common.ParseFlags([]string{"-vvv"})
 	thisDir := common.ProjectPath("agent", "wikix")
 	wikix, err := NewWikiX(thisDir, common.LLMModel, SystemPrompt)
@@ -154,3 +197,77 @@ func TestRunInitSubq(t *testing.T) {
 		}
 	}
 }
+
+// useFixtures records or replays llm interactions in testdata/llm, and
+// the wikipedia lookups in testdata/wiki, as set by MOCHAT_LLM_RECORD, the test is skipped without fixtures.
+func useFixtures(t *testing.T) {
+	err := llm.RecordingForTest(common.ProjectPath("agent", "wikix", "testdata", "llm"))
+	if errors.Is(err, llm.ErrNoFixtures) {
+		t.Skip(err)
+	}
+	common.PanicAssert(t, err == nil, "RecordingForTest failed: %v", err)
+
+	mode, _ := llm.Recording()
+	if mode == llm.RecordOff {
+		return
+	}
+	dir := common.ProjectPath("agent", "wikix", "testdata", "wiki")
+	oldTitle, oldText, oldContent := wikiTitle, wikiText, wikiContent
+	t.Cleanup(func() {
+		wikiTitle, wikiText, wikiContent = oldTitle, oldText, oldContent
+	})
+	wikiTitle = wikiFixture(mode, dir, "title", oldTitle)
+	wikiText = wikiFixture(mode, dir, "text", oldText)
+	wikiContent = wikiFixture(mode, dir, "content", oldContent)
+}
+
+// wikiPage is a recorded wikipedia lookup.
+type wikiPage struct {
+	Arg   string `json:"arg"`
+	Value string `json:"value"`
+	Err   string `json:"err,omitempty"`
+}
+
+// wikiFixture wraps a wikipedia lookup, to record it to dir, or replay it
+// from dir without network.
+func wikiFixture(mode, dir, kind string, fn func(string) (string, error)) func(string) (string, error) {
+	return func(arg string) (string, error) {
+		h := sha256.Sum256([]byte(kind + ":" + arg))
+		path := filepath.Join(dir, kind+"-"+hex.EncodeToString(h[:8])+".json")
+		var page wikiPage
+		if mode == llm.ReplayMode {
+			bs, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("no recorded wiki %s of %s: %w", kind, arg, err)
+			}
+			if err = json.Unmarshal(bs, &page); err != nil {
+				return "", err
+			}
+		} else {
+			page.Arg = arg
+			v, err := fn(arg)
+			page.Value = v
+			if err != nil {
+				page.Err = err.Error()
+			}
+			bs, err := json.MarshalIndent(page, "", "  ")
+			if err != nil {
+				return "", err
+			}
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				return "", err
+			}
+			if err = os.WriteFile(path, bs, 0644); err != nil {
+				return "", err
+			}
+		}
+
+		switch page.Err {
+		case "":
+			return page.Value, nil
+		case ErrNoPageFound.Error():
+			return page.Value, ErrNoPageFound
+		}
+		return page.Value, errors.New(page.Err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/matrixorigin/monlp/agent"
+	"github.com/matrixorigin/monlp/agent/dbagent"
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/cmd/u"
+	"github.com/matrixorigin/monlp/common"
+	"github.com/ollama/ollama/api"
+)
+
+// chat is the conversation of the shell, recorded as one session.
+var chat struct {
+	agent agent.Agent
+	msgs  []api.Message
+}
+
+func initChat(c *ishell.Context) error {
+	var err error
+	chat.agent, err = llm.NewChatWithTemplate(common.LLMModel, "chat.assistant",
+		map[string]any{"Answer": "a few sentences"}, nil)
+	if err != nil {
+		return err
+	}
+
+	// chat without transcript if the database is not available.
+	var ss *dbagent.Session
+	ts, err := u.Transcripts()
+	if err == nil {
+		ss, err = ts.NewSession("mochat", common.LLMModel)
+	}
+	if err != nil {
+		slog.Warn("mochat transcript disabled", "err", err)
+		c.Println("Transcript disabled:", err)
+	} else {
+		chat.agent.SetValue("transcript", ss)
+	}
+	return nil
+}
+
+func chatCmd(c *ishell.Context) {
+	q := strings.TrimSpace(strings.Join(c.Args, " "))
+	if q == "" {
+		c.Println("Hello, this is mochat.")
+		return
+	}
+
+	if chat.agent == nil {
+		if err := initChat(c); err != nil {
+			c.Println(err)
+			return
+		}
+	}
+
+	msgs := append(chat.msgs, api.Message{Role: "user", Content: q})
+	input, err := json.Marshal(llm.ChatInput{Messages: msgs})
+	if err != nil {
+		c.Println(err)
+		return
+	}
+
+	var output llm.ChatOutput
+	err = chat.agent.ExecuteOne(input, nil, func(bs []byte, err error) bool {
+		if err == nil {
+			err = json.Unmarshal(bs, &output)
+		}
+		if err != nil {
+			c.Println(err)
+		}
+		return true
+	})
+	if err != nil {
+		c.Println(err)
+		return
+	}
+
+	chat.msgs = append(msgs, output.Response.Message)
+	c.Println(llm.StripThink(output.Response.Message.Content))
+}
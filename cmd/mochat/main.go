@@ -18,13 +18,16 @@ func main() {
 
 	u.AddCmd(sh, "echo")
 	u.AddCmd(sh, "sql")
+	u.AddCmd(sh, "transcript")
+	u.AddCmd(sh, "fts")
+	u.AddCmd(sh, "eval")
+	u.AddCmd(sh, "migrate")
+	u.CheckSchema()
 
 	sh.AddCmd(&ishell.Cmd{
 		Name: ".",
 		Help: "chat with mochat",
-		Func: func(c *ishell.Context) {
-			c.Println("Hello, this is mochat.")
-		},
+		Func: chatCmd,
 	})
 
 	sh.Run()
@@ -0,0 +1,83 @@
+package u
+
+import (
+	"os"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/matrixorigin/monlp/agent/eval"
+	"github.com/matrixorigin/monlp/agent/llm"
+	"github.com/matrixorigin/monlp/common"
+)
+
+// evalDataset finds a dataset file, a path, or a file of agent/eval.
+func evalDataset(name string) string {
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	return common.ProjectPath("agent", "eval", name)
+}
+
+// EvalCmd runs a dataset against models and prints the comparison report,
+// .eval dataset [model1,model2,...] [chat | wikix | all]
+func EvalCmd(c *ishell.Context) {
+	if len(c.Args) < 1 || len(c.Args) > 3 {
+		c.Println("Usage: .eval dataset [model1,model2,...] [chat | wikix | all]")
+		return
+	}
+	models := eval.Models(common.LLMModel)
+	if len(c.Args) > 1 {
+		models = eval.Models(c.Args[1])
+	}
+	runners := "all"
+	if len(c.Args) > 2 {
+		runners = c.Args[2]
+	}
+	if runners != "chat" && runners != "wikix" && runners != "all" {
+		c.Println("Unknown runner", runners)
+		return
+	}
+
+	ds, err := eval.LoadDataset(evalDataset(c.Args[0]))
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	judge, err := eval.NewJudge(llm.DefaultModel)
+	if err != nil {
+		c.Println(err)
+		return
+	}
+
+	rep := eval.Report{Dataset: ds.Name}
+	for _, model := range models {
+		var rs []eval.Runner
+		if runners != "wikix" {
+			r, err := eval.NewChatRunner(model)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			rs = append(rs, r)
+		}
+		if runners != "chat" {
+			r, err := eval.NewWikiXRunner(common.ProjectPath("agent", "wikix"), model)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			rs = append(rs, r)
+		}
+		for _, r := range rs {
+			c.Printf("Evaluating %s/%s on %d questions ...\n", r.Name(), model, len(ds.Questions))
+			results := eval.Evaluate(ds, r, judge)
+			for _, res := range results {
+				if res.Err != "" {
+					c.Printf("  %s: %s\n", res.Id, strings.TrimSpace(res.Err))
+				}
+			}
+			rep.Add(results...)
+		}
+	}
+	c.Println(rep.String())
+}
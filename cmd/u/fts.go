@@ -0,0 +1,109 @@
+package u
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/matrixorigin/monlp/agent/dbagent"
+	"github.com/matrixorigin/monlp/textu/chunk"
+)
+
+// ftsSources are the queries of the documents to index, rows of key,
+// title and text, or of a chunk, path, num1, num2, title and text.
+var ftsSources = map[string]string{
+	"wiki":  "select k, title, content from wikipages where redirect is null or redirect = '' order by id",
+	"novel": "select url, num1, num2, title, content from testnovel order by url, num1, num2",
+}
+
+// ftsPage is the number of documents indexed in a transaction.
+const ftsPage = 1000
+
+// FtsIndexCmd builds a full-text index,
+// .ftsindex name wiki | novel | select key, title, text from ...
+func FtsIndexCmd(c *ishell.Context) {
+	if len(c.Args) < 2 {
+		c.Println("Usage: .ftsindex name wiki | novel | select key, title, text from ...")
+		return
+	}
+	qry, ok := ftsSources[c.Args[1]]
+	if !ok {
+		qry = strings.Join(c.Args[1:], " ")
+	}
+
+	if err := openDB(nil); err != nil {
+		c.Println(err)
+		return
+	}
+	ix, err := dbagent.OpenFTSIndex(db, c.Args[0])
+	if err != nil {
+		c.Println(err)
+		return
+	}
+
+	total := 0
+	for offset := 0; ; offset += ftsPage {
+		rows, err := db.Query(qry+" limit ? offset ?", ftsPage, offset)
+		if err != nil {
+			c.Println(err)
+			return
+		}
+		docs := make([]dbagent.FTSDoc, 0, len(rows))
+		for _, row := range rows {
+			var doc dbagent.FTSDoc
+			switch len(row) {
+			case 3:
+				doc.Key, doc.Title, doc.Text = row[0], row[1], row[2]
+			case 5:
+				n1, _ := strconv.Atoi(row[1])
+				n2, _ := strconv.Atoi(row[2])
+				doc.Chunk = chunk.Chunk{Path: row[0], Num1: int32(n1), Num2: int32(n2), Title: row[3], Text: row[4]}
+			default:
+				c.Println("Expected 3 or 5 columns, got", len(row))
+				return
+			}
+			docs = append(docs, doc)
+		}
+		if err = ix.Add(docs); err != nil {
+			c.Println(err)
+			return
+		}
+		total += len(docs)
+		if len(rows) < ftsPage {
+			break
+		}
+		c.Printf("Indexed %d documents.\n", total)
+	}
+	c.Printf("Indexed %d documents in %s.\n", total, c.Args[0])
+}
+
+// SearchCmd searches a full-text index, .search name query
+func SearchCmd(c *ishell.Context) {
+	if len(c.Args) < 2 {
+		c.Println(`Usage: .search name words "a phrase"`)
+		return
+	}
+
+	if err := openDB(nil); err != nil {
+		c.Println(err)
+		return
+	}
+	ix, err := dbagent.OpenFTSIndex(db, c.Args[0])
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	// the args of ishell drop the quotes of phrases, raw args keep them.
+	q := strings.Join(c.RawArgs[2:], " ")
+	hits, err := ix.Search(q, dbagent.DefaultSearchLimit)
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	if len(hits) == 0 {
+		c.Println("No document found")
+	}
+	for i, h := range hits {
+		c.Printf("%2d. %6.3f  %s  %s\n", i+1, h.Score, h.Key, h.Title)
+	}
+}
@@ -0,0 +1,79 @@
+package u
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/matrixorigin/monlp/agent/dbagent"
+)
+
+// Migrator returns the schema migrator of the mochat database.
+func Migrator() (*dbagent.Migrator, error) {
+	if err := openDB(nil); err != nil {
+		return nil, err
+	}
+	return dbagent.NewMigrator(db)
+}
+
+// CheckSchema checks at startup that the database schema is up to date,
+// it only warns, so that commands not using the database still work.
+func CheckSchema() {
+	m, err := Migrator()
+	if err == nil {
+		err = m.Check()
+	}
+	if errors.Is(err, dbagent.ErrSchemaOutdated) {
+		slog.Warn("Database schema is outdated, run .migrate up", "err", err)
+	} else if err != nil {
+		slog.Warn("Cannot check database schema", "err", err)
+	}
+}
+
+// MigrateCmd migrates the database schema,
+// .migrate [status | up [version] | down version]
+func MigrateCmd(c *ishell.Context) {
+	m, err := Migrator()
+	if err != nil {
+		c.Println(err)
+		return
+	}
+
+	cmd := "status"
+	if len(c.Args) > 0 {
+		cmd = c.Args[0]
+	}
+	version := 0
+	if len(c.Args) > 1 {
+		if version, err = strconv.Atoi(c.Args[1]); err != nil {
+			c.Println("Invalid version", c.Args[1])
+			return
+		}
+	}
+
+	var done []dbagent.Migration
+	switch {
+	case cmd == "status":
+	case cmd == "up":
+		done, err = m.Up(version)
+	case cmd == "down" && len(c.Args) == 2:
+		done, err = m.Down(version)
+	default:
+		c.Println("Usage: .migrate [status | up [version] | down version]")
+		return
+	}
+	for _, mg := range done {
+		c.Printf("%s %04d_%s\n", cmd, mg.Version, mg.Name)
+	}
+	if err != nil {
+		c.Println(err)
+	}
+
+	cur, err := m.Version()
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	c.Printf("Schema version %d, latest %d\n", cur, m.Latest())
+}
@@ -1,7 +1,6 @@
 package u
 
 import (
-	"path"
 	"strings"
 
 	"github.com/abiosoft/ishell/v2"
@@ -13,17 +12,6 @@ var (
 	db *dbagent.MoDB
 )
 
-func ConnStr() string {
-	var connstr string
-	switch common.SqlDriver {
-	case "sqlite", "sqlite3", "dslite", "dslite3":
-		connstr = path.Join(common.WorkingDir, "monlp.db")
-	default:
-		connstr = dbagent.ConnStr("localhost", "6001", "dump", "111", "monlp")
-	}
-	return connstr
-}
-
 func IdDef(col string) string {
 	switch common.SqlDriver {
 	case "sqlite", "sqlite3", "dslite", "dslite3":
@@ -39,8 +27,7 @@ func openDB(args []string) error {
 	}
 
 	var err error
-	connstr := ConnStr()
-	db, err = dbagent.OpenDB(common.SqlDriver, connstr)
+	db, err = dbagent.OpenProfile("")
 	return err
 }
 
@@ -0,0 +1,128 @@
+package u
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/matrixorigin/monlp/agent/dbagent"
+)
+
+var (
+	transcripts *dbagent.TranscriptStore
+)
+
+// Transcripts returns the transcript store in the mochat database.
+func Transcripts() (*dbagent.TranscriptStore, error) {
+	if transcripts != nil {
+		return transcripts, nil
+	}
+	if err := openDB(nil); err != nil {
+		return nil, err
+	}
+
+	var err error
+	transcripts, err = dbagent.OpenTranscriptStore(db)
+	return transcripts, err
+}
+
+// SessionsCmd lists the latest sessions, .sessions [limit]
+func SessionsCmd(c *ishell.Context) {
+	limit := 20
+	if len(c.Args) > 0 {
+		n, err := strconv.Atoi(c.Args[0])
+		if err != nil {
+			c.Println("Invalid limit", c.Args[0])
+			return
+		}
+		limit = n
+	}
+
+	ts, err := Transcripts()
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	sessions, err := ts.Sessions(limit)
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	for _, s := range sessions {
+		c.Printf("%s  %-8s %-20s %s  %d exchanges\n", s.Id, s.App, s.Model, s.Started.Format("2006-01-02 15:04:05"), s.Exchanges)
+	}
+}
+
+// ReplayCmd prints the conversation of a session, .replay session
+func ReplayCmd(c *ishell.Context) {
+	if len(c.Args) != 1 {
+		c.Println("Usage: .replay session")
+		return
+	}
+
+	ts, err := Transcripts()
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	exs, err := ts.Exchanges(c.Args[0])
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	if len(exs) == 0 {
+		c.Println("No exchange in session", c.Args[0])
+		return
+	}
+
+	for _, ex := range exs {
+		c.Printf("[%d] %s (%s, %v)\n", ex.Seq, ex.Created.Format("15:04:05"), ex.Model, ex.Latency)
+		c.Println("Q:", ex.Question)
+		if len(ex.ToolCalls) > 0 {
+			c.Println("Tools:", string(ex.ToolCalls))
+		}
+		if ex.Err != "" {
+			c.Println("Error:", ex.Err)
+		} else {
+			c.Println("A:", strings.TrimSpace(ex.Answer))
+		}
+		c.Println()
+	}
+}
+
+// ExportCmd exports a session as json lines, .export session [file]
+func ExportCmd(c *ishell.Context) {
+	if len(c.Args) < 1 || len(c.Args) > 2 {
+		c.Println("Usage: .export session [file]")
+		return
+	}
+
+	ts, err := Transcripts()
+	if err != nil {
+		c.Println(err)
+		return
+	}
+
+	if len(c.Args) == 1 {
+		sb := &strings.Builder{}
+		if err = ts.Export(c.Args[0], sb); err != nil {
+			c.Println(err)
+			return
+		}
+		c.Print(sb.String())
+		return
+	}
+
+	f, err := os.Create(c.Args[1])
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	defer f.Close()
+	if err = ts.Export(c.Args[0], f); err != nil {
+		c.Println(err)
+		return
+	}
+	c.Println("Exported to", c.Args[1])
+}
@@ -23,6 +23,49 @@ func AddCmd(sh *ishell.Shell, name string) {
 			Func: SqlCmd,
 		})
 
+	case "transcript":
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".sessions",
+			Help: "list chat sessions",
+			Func: SessionsCmd,
+		})
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".replay",
+			Help: "replay a chat session",
+			Func: ReplayCmd,
+		})
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".export",
+			Help: "export a chat session as json lines",
+			Func: ExportCmd,
+		})
+
+	case "migrate":
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".migrate",
+			Help: "migrate the database schema, status, up [version] or down version",
+			Func: MigrateCmd,
+		})
+
+	case "fts":
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".ftsindex",
+			Help: "build a full-text index, of wiki, novel or a query",
+			Func: FtsIndexCmd,
+		})
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".search",
+			Help: "search a full-text index",
+			Func: SearchCmd,
+		})
+
+	case "eval":
+		sh.AddCmd(&ishell.Cmd{
+			Name: ".eval",
+			Help: "evaluate models on a dataset, dataset [models] [chat | wikix | all]",
+			Func: EvalCmd,
+		})
+
 	default:
 		sh.Println("Unknown command", name)
 	}
@@ -8,6 +8,7 @@ import (
 	"github.com/matrixorigin/monlp/agent"
 	"github.com/matrixorigin/monlp/agent/chunker"
 	"github.com/matrixorigin/monlp/agent/dbagent"
+	"github.com/matrixorigin/monlp/agent/wikix"
 	"github.com/matrixorigin/monlp/cmd/u"
 	"github.com/matrixorigin/monlp/common"
 	"github.com/matrixorigin/monlp/textu/chunk"
@@ -40,9 +41,14 @@ func wikiStatsCmd(c *ishell.Context) {
 }
 
 func wikiLoadCmd(c *ishell.Context) {
-	// First run the table creation command
-	connstr := u.ConnStr()
-	conf := dbagent.Config{Driver: common.SqlDriver, ConnStr: connstr, Table: "wikipages"}
+	// The tables are created by migrations, clear them.
+	m, err := u.Migrator()
+	common.PanicAssert(nil, err == nil, "Expected nil, got %v", err)
+	_, err = m.Up(0)
+	common.PanicAssert(nil, err == nil, "Expected nil, got %v", err)
+
+	// the agents share the connection pool of the db profile.
+	conf := dbagent.Config{Table: "wikipages"}
 	config, err := json.Marshal(conf)
 	common.PanicAssert(nil, err == nil, "Expected nil, got %v", err)
 
@@ -50,25 +56,9 @@ func wikiLoadCmd(c *ishell.Context) {
 	err = qa.Config(config)
 	common.PanicAssert(nil, err == nil, "Expected nil, got %v", err)
 
-	iddef := u.IdDef("id")
 	stra := agent.NewStringArrayAgent([]string{
-		`{"mode": "exec", "data": "drop table if exists wikipages"}`,
-		`{"mode": "exec", "data": "create table wikipages (` +
-			iddef + `, ` +
-			`title varchar(1000) not null, ` +
-			`k varchar(1000) not null,` +
-			`redirect varchar(1000), ` +
-			`content text)"}`,
-		`{"mode": "exec", "data": "create index wikipages_k_idx on wikipages (k)"}`,
-		`{"mode": "exec", "data": "create index wikipages_title_idx on wikipages (title)"}`,
-		`{"mode": "exec", "data": "drop table if exists wikilinks"}`,
-		`{"mode": "exec", "data": "create table wikilinks (` +
-			`tfrom varchar(1000) not null, ` +
-			`idfrom int not null, ` +
-			`tto varchar(1000) not null, ` +
-			`offset int)"}`,
-		`{"mode": "exec", "data": "create index wikilinks_kfrom_idx on wikilinks (tfrom, tto)"}`,
-		`{"mode": "exec", "data": "create index wikilinks_kto_idx on wikilinks (tto, tfrom)"}`,
+		`{"mode": "exec", "data": "delete from wikipages"}`,
+		`{"mode": "exec", "data": "delete from wikilinks"}`,
 	})
 
 	var pipe agent.AgentPipe
@@ -93,8 +83,6 @@ func wikiLoadCmd(c *ishell.Context) {
 
 	wa := dbagent.NewDbWriter()
 	waconf := dbagent.Config{
-		Driver:    common.SqlDriver,
-		ConnStr:   connstr,
 		Table:     "wikipages",
 		QTemplate: "insert into wikipages(title, k, redirect, content) values (?, ?, ?, ?)",
 	}
@@ -118,3 +106,42 @@ func wikiLoadCmd(c *ishell.Context) {
 		c.Printf("Load wikipages nbatch %d, pages %d.\n", nbatch, nbatch*batchSz)
 	}
 }
+
+var wix *wikix.WikiX
+
+// wikixCmd answers a question by exploring wikipedia, the exchanges of
+// the shell are recorded as one session.
+func wikixCmd(c *ishell.Context) {
+	q := strings.TrimSpace(strings.Join(c.Args, " "))
+	if q == "" {
+		c.Println("Hello, this is wikiexplorer.")
+		return
+	}
+
+	if wix == nil {
+		var err error
+		wix, err = wikix.NewWikiX(common.ProjectPath("agent", "wikix"), common.LLMModel, wikix.SystemPrompt)
+		if err != nil {
+			c.Println(err)
+			return
+		}
+
+		ts, err := u.Transcripts()
+		var ss *dbagent.Session
+		if err == nil {
+			ss, err = ts.NewSession("wikix", common.LLMModel)
+		}
+		if err != nil {
+			c.Println("Transcript disabled:", err)
+		} else {
+			wix.SetValue("transcript", ss)
+		}
+	}
+
+	answer, err := wix.Answer(q)
+	if err != nil {
+		c.Println(err)
+		return
+	}
+	c.Println(answer)
+}
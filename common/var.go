@@ -2,7 +2,6 @@ package common
 
 import (
 	"flag"
-	"fmt"
 	"log/slog"
 	"os"
 	"path"
@@ -16,6 +15,8 @@ var (
 	WorkingDir string
 	// Sql database
 	SqlDriver string
+	// Connection profile of the database, see dbagent.LoadProfile
+	DbProfile string
 	// Level of verbosity 0-3
 	Verbose int
 	// LLM Model
@@ -37,20 +38,25 @@ func decideFlagValue(envName, flagValue, dflt string) string {
 	return dflt
 }
 
+// ParseFlags parses args, with a new flag set each time so that tests can
+// call it more than once.
 func ParseFlags(args []string) {
-	fWD := flag.String("d", "", "Working directory")
-	sqlDr := flag.String("db", "", "Sql driver")
-	llm := flag.String("llm", "", "LLM model")
-	llmTemp := flag.Float64("temp", 0.0, "LLM temperature")
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fWD := fs.String("d", "", "Working directory")
+	sqlDr := fs.String("db", "", "Sql driver")
+	dbProfile := fs.String("dbprofile", "", "Database connection profile")
+	llm := fs.String("llm", "", "LLM model")
+	llmTemp := fs.Float64("temp", 0.0, "LLM temperature")
 
-	v1 := flag.Bool("v", false, "Verbose")
-	v2 := flag.Bool("vv", false, "Verbose2")
-	v3 := flag.Bool("vvv", false, "Verbose3")
+	v1 := fs.Bool("v", false, "Verbose")
+	v2 := fs.Bool("vv", false, "Verbose2")
+	v3 := fs.Bool("vvv", false, "Verbose3")
 
-	flag.CommandLine.Parse(args)
+	fs.Parse(args)
 
 	WorkingDir = decideFlagValue("MOCHAT_WORKING_DIR", *fWD, "")
 	SqlDriver = decideFlagValue("MOCHAT_SQL_DRIVER", *sqlDr, "mysql")
+	DbProfile = decideFlagValue("MOCHAT_DB_PROFILE", *dbProfile, "")
 	LLMModel = decideFlagValue("MOCHAT_LLM_MODEL", *llm, "deepseek-r1:32b")
 	LLMTemp = *llmTemp
 
@@ -99,18 +105,3 @@ func setupLogger() {
 	logger := slog.New(slog.NewJSONHandler(lf, opts))
 	slog.SetDefault(logger)
 }
-
-func DbConnInfoForTest() (string, string) {
-	var driver string
-	var connstr string
-	switch SqlDriver {
-	case "sqlite", "sqlite3", "dslite", "dslite3":
-		driver = "sqlite3"
-		connstr = path.Join(WorkingDir, "monlp.db")
-	default:
-		driver = "mysql"
-		connstr = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
-			"dump", "111", "localhost", "6001", "monlp")
-	}
-	return driver, connstr
-}
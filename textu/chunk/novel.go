@@ -64,6 +64,7 @@ func (c *NovelChunker) handleLine(yield func(Chunk) bool) {
 
 func (c *NovelChunker) Chunk() iter.Seq[Chunk] {
 	return func(yield func(Chunk) bool) {
+		c.done = false
 		for c.scan.Scan() {
 			line := c.scan.Text()
 			if line != "" {
@@ -78,8 +79,9 @@ func (c *NovelChunker) Chunk() iter.Seq[Chunk] {
 				c.emptyLine++
 			}
 
+			// the consumer stopped, do not yield the rest.
 			if c.done {
-				break
+				return
 			}
 		}
 		c.handleLine(yield)
@@ -61,6 +61,35 @@ Paragraph 3.2
 	}
 }
 
+func TestNovelChunkerBreak(t *testing.T) {
+	input := "Paragraph 0.1\n\nParagraph 0.2\n\n\nParagraph 1.1\n\nParagraph 1.2\n"
+	chunker, err := NewNovelChunker(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to create paragraph chunker: %v", err)
+	}
+
+	// breaking out of the loop must not yield again.
+	nchunk := 0
+	for chunk := range chunker.Chunk() {
+		nchunk++
+		if chunk.Num1 > 0 {
+			break
+		}
+	}
+	if nchunk != 3 {
+		t.Errorf("Expected 3 chunks, got %d", nchunk)
+	}
+
+	// and the rest can still be read.
+	nchunk = 0
+	for range chunker.Chunk() {
+		nchunk++
+	}
+	if nchunk != 1 {
+		t.Errorf("Expected 1 chunk left, got %d", nchunk)
+	}
+}
+
 func getFileReader(t *testing.T, filename string) *os.File {
 	_, fn, _, _ := runtime.Caller(0)
 	dir := path.Dir(fn)
@@ -15,9 +15,8 @@ type WikiPageData struct {
 }
 
 func TestGenData(t *testing.T) {
-	driver, connstr := common.DbConnInfoForTest()
-	db, err := dbagent.OpenDB(driver, connstr)
-	common.Assert(t, err == nil, "OpenDB failed: %v", err)
+	db, err := dbagent.OpenProfile("")
+	common.Assert(t, err == nil, "OpenProfile failed: %v", err)
 
 	wf, err := common.CreateFileForTest("data", "wiki2pages.txt")
 	common.Assert(t, err == nil, "OpenDB failed: %v", err)
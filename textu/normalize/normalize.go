@@ -0,0 +1,74 @@
+// Package normalize normalizes short answers for comparison, for example,
+// exact match and F1 scoring, or majority voting among samples.
+package normalize
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+var articles = map[string]bool{
+	"a":   true,
+	"an":  true,
+	"the": true,
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Tokens returns the normalized tokens of s.  Text is folded to half
+// width and lower case, punctuation is dropped, latin words are tokens
+// except the articles a, an and the, and each CJK character is a token.
+func Tokens(s string) []string {
+	s = strings.ToLower(width.Fold.String(s))
+
+	var tokens []string
+	word := &strings.Builder{}
+	flush := func() {
+		if word.Len() > 0 {
+			if w := word.String(); !articles[w] {
+				tokens = append(tokens, w)
+			}
+			word.Reset()
+		}
+	}
+
+	for _, r := range s {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		case r == '.' && word.Len() > 0 && unicode.IsDigit(lastRune(word.String())):
+			// keep decimal point in numbers, 1.644934
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	// trailing dot of a number at the end of sentence.
+	for i, tk := range tokens {
+		tokens[i] = strings.TrimRight(tk, ".")
+	}
+	return tokens
+}
+
+// Answer returns the normalized form of an answer, the tokens joined
+// by a space.
+func Answer(s string) string {
+	return strings.Join(Tokens(s), " ")
+}
+
+func lastRune(s string) rune {
+	var last rune
+	for _, r := range s {
+		last = r
+	}
+	return last
+}
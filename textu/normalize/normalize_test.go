@@ -0,0 +1,22 @@
+package normalize
+
+import (
+	"testing"
+)
+
+func TestAnswer(t *testing.T) {
+	cases := [][2]string{
+		{"Paris", "paris"},
+		{"  The  Blue sky. ", "blue sky"},
+		{"It is 1.644934.", "it is 1.644934"},
+		{"Numen Lumen!", "numen lumen"},
+		{"ＡＢＣ１２３", "abc123"},
+		{"红楼梦，曹雪芹。", "红 楼 梦 曹 雪 芹"},
+		{"an apple a day", "apple day"},
+	}
+	for _, c := range cases {
+		if got := Answer(c[0]); got != c[1] {
+			t.Errorf("Answer(%q) = %q, want %q", c[0], got, c[1])
+		}
+	}
+}
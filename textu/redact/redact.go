@@ -0,0 +1,235 @@
+// Package redact detects and redacts personally identifiable information,
+// emails, phone numbers, ID numbers and the like, in latin and CJK text.
+package redact
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	Email      = "email"
+	Phone      = "phone"
+	CNID       = "cn_id"       // mainland China resident identity card number
+	SSN        = "ssn"         // US social security number
+	CreditCard = "credit_card" // payment card number, luhn checked
+	IPv4       = "ipv4"
+)
+
+// Redaction is a detected span of the original text.  The text itself
+// is not kept, so that redactions can be logged and stored.
+type Redaction struct {
+	Kind   string `json:"kind"`
+	Offset int    `json:"offset"` // byte offset in the original text
+	Length int    `json:"length"` // byte length in the original text
+}
+
+// Rule detects one kind of PII by regexp, Check, if set, validates a
+// match, for example by checksum.  A Bounded match must not be followed
+// by a digit.
+type Rule struct {
+	Kind    string
+	Re      *regexp.Regexp
+	Check   func(string) bool
+	Bounded bool
+}
+
+// Numbers must not be adjacent to other digits.  \b does not work for
+// CJK text, "电话13812345678" has no word boundary before the number.
+// The digit after a number is checked by Find, not matched, so that the
+// separator of 2 adjacent numbers is left to the second one.
+const noDigitBefore = `(?:^|[^0-9])`
+
+// DefaultRules are the built-in rules.
+var DefaultRules = []Rule{
+	{Kind: Email, Re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{Kind: CNID, Re: regexp.MustCompile(noDigitBefore + `([1-9]\d{5}(?:19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx])`), Check: checkCNID, Bounded: true},
+	{Kind: CreditCard, Re: regexp.MustCompile(noDigitBefore + `((?:\d[ \-]?){12,18}\d)`), Check: checkLuhn, Bounded: true},
+	{Kind: SSN, Re: regexp.MustCompile(noDigitBefore + `(\d{3}-\d{2}-\d{4})`), Bounded: true},
+	{Kind: Phone, Re: regexp.MustCompile(noDigitBefore + `((?:\+?86[ \-]?)?1[3-9]\d{9})`), Bounded: true},
+	{Kind: Phone, Re: regexp.MustCompile(noDigitBefore + `((?:\+\d{1,3}[ \-.]?)?(?:\(\d{2,4}\) ?|\d{2,4}[\-.])\d{3,4}[\-.]\d{3,4})`), Bounded: true},
+	{Kind: Phone, Re: regexp.MustCompile(noDigitBefore + `(0\d{2,3}-\d{7,8})`), Bounded: true},
+	{Kind: IPv4, Re: regexp.MustCompile(noDigitBefore + `((?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d))`), Bounded: true},
+}
+
+var cnidWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+// checkCNID validates the check digit of a CN ID number.
+func checkCNID(s string) bool {
+	sum := 0
+	for i, w := range cnidWeights {
+		sum += int(s[i]-'0') * w
+	}
+	return "10X98765432"[sum%11] == s[17] || (s[17] == 'x' && sum%11 == 2)
+}
+
+// checkLuhn validates a card number by the luhn algorithm.
+func checkLuhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Dictionary builds a case insensitive rule matching any of terms.  Latin
+// terms match whole words, CJK terms match anywhere, as there are no
+// word boundaries in CJK text.
+func Dictionary(kind string, terms []string) Rule {
+	var alts []string
+	for _, t := range terms {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		q := regexp.QuoteMeta(t)
+		rs := []rune(t)
+		if !isCJK(rs[0]) {
+			q = `\b` + q
+		}
+		if !isCJK(rs[len(rs)-1]) {
+			q = q + `\b`
+		}
+		alts = append(alts, q)
+	}
+	// longer terms first, so that the longest term wins.
+	sort.SliceStable(alts, func(i, j int) bool { return len(alts[i]) > len(alts[j]) })
+
+	if len(alts) == 0 {
+		return Rule{Kind: kind}
+	}
+	return Rule{Kind: kind, Re: regexp.MustCompile(`(?i)(` + strings.Join(alts, "|") + `)`)}
+}
+
+// Placeholder is the replacement text of a redacted kind.
+func Placeholder(kind string) string {
+	return "[" + strings.ToUpper(kind) + "]"
+}
+
+// Redactor redacts text by a list of rules.
+type Redactor struct {
+	Rules []Rule
+}
+
+// New creates a redactor with the default rules, followed by rules.
+func New(rules ...Rule) *Redactor {
+	return &Redactor{Rules: append(append([]Rule(nil), DefaultRules...), rules...)}
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// spans returns the spans of the matches of rule in s, of the first group
+// if any.   A match of a bounded rule followed by a digit is retried
+// shorter, so that "4111111111111111 5500000000000004" are 2 numbers.
+func (rule *Rule) spans(s string) [][2]int {
+	var spans [][2]int
+	if !rule.Bounded {
+		for _, m := range rule.Re.FindAllStringSubmatchIndex(s, -1) {
+			if len(m) >= 4 && m[2] >= 0 {
+				spans = append(spans, [2]int{m[2], m[3]})
+			} else {
+				spans = append(spans, [2]int{m[0], m[1]})
+			}
+		}
+		return spans
+	}
+
+	for pos := 0; pos < len(s); {
+		limit, next := len(s), -1
+		for limit > pos {
+			m := rule.Re.FindStringSubmatchIndex(s[pos:limit])
+			if m == nil {
+				break
+			}
+			start, end := pos+m[2], pos+m[3]
+			if next < 0 {
+				next = end
+			}
+			if end == len(s) || !isDigit(s[end]) {
+				spans = append(spans, [2]int{start, end})
+				next = end
+				break
+			}
+			limit = end - 1
+		}
+		if next < 0 {
+			break
+		}
+		// do not restart inside a number, the ^ of noDigitBefore would
+		// match there.
+		for next < len(s) && isDigit(s[next-1]) && isDigit(s[next]) {
+			next++
+		}
+		pos = next
+	}
+	return spans
+}
+
+// Find returns the redactions of s, ordered by offset.  Overlapping
+// matches are resolved in favor of the earlier rule.
+func (r *Redactor) Find(s string) []Redaction {
+	var found []Redaction
+	overlaps := func(start, end int) bool {
+		for _, f := range found {
+			if start < f.Offset+f.Length && f.Offset < end {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, rule := range r.Rules {
+		if rule.Re == nil {
+			continue
+		}
+		for _, sp := range rule.spans(s) {
+			start, end := sp[0], sp[1]
+			if rule.Check != nil && !rule.Check(s[start:end]) {
+				continue
+			}
+			if overlaps(start, end) {
+				continue
+			}
+			found = append(found, Redaction{Kind: rule.Kind, Offset: start, Length: end - start})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Offset < found[j].Offset })
+	return found
+}
+
+// Redact replaces the PII in s with placeholders.
+func (r *Redactor) Redact(s string) (string, []Redaction) {
+	found := r.Find(s)
+	if len(found) == 0 {
+		return s, nil
+	}
+
+	buf := &strings.Builder{}
+	last := 0
+	for _, f := range found {
+		buf.WriteString(s[last:f.Offset])
+		buf.WriteString(Placeholder(f.Kind))
+		last = f.Offset + f.Length
+	}
+	buf.WriteString(s[last:])
+	return buf.String(), found
+}
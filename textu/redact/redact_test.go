@@ -0,0 +1,66 @@
+package redact
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := New()
+	cases := [][2]string{
+		{"mail me at jason.argo@example.com now", "mail me at [EMAIL] now"},
+		{"电话13812345678，邮箱test@qq.com", "电话[PHONE]，邮箱[EMAIL]"},
+		{"身份证号11010519491231002X。", "身份证号[CN_ID]。"},
+		{"id 110105194912310021 has a bad check digit", "id 110105194912310021 has a bad check digit"},
+		{"card 4111 1111 1111 1111 expires", "card [CREDIT_CARD] expires"},
+		{"card 4111 1111 1111 1112 is not valid", "card 4111 1111 1111 1112 is not valid"},
+		{"SSN 078-05-1120.", "SSN [SSN]."},
+		{"call (555) 123-4567 or +1 555-123-4567", "call [PHONE] or [PHONE]"},
+		{"北京 010-12345678", "北京 [PHONE]"},
+		{"from 192.168.1.20 to 10.0.0.1", "from [IPV4] to [IPV4]"},
+		{"the year 1949 and 3.14159", "the year 1949 and 3.14159"},
+	}
+	for _, c := range cases {
+		if got, _ := r.Redact(c[0]); got != c[1] {
+			t.Errorf("Redact(%q) = %q, want %q", c[0], got, c[1])
+		}
+	}
+}
+
+func TestRedactAdjacent(t *testing.T) {
+	r := New()
+	cases := [][2]string{
+		{"call 13812345678,13912345678 now", "call [PHONE],[PHONE] now"},
+		{"电话13812345678或13912345678", "电话[PHONE]或[PHONE]"},
+		{"(555) 123-4567,(555) 765-4321", "[PHONE],[PHONE]"},
+		{"010-12345678/021-87654321", "[PHONE]/[PHONE]"},
+		{"ips 10.0.0.1,10.0.0.2", "ips [IPV4],[IPV4]"},
+		{"ssn 078-05-1120 123-45-6780", "ssn [SSN] [SSN]"},
+		{"11010519491231002X,11010519491231002X", "[CN_ID],[CN_ID]"},
+		{"4111 1111 1111 1111,5500 0000 0000 0004", "[CREDIT_CARD],[CREDIT_CARD]"},
+		{"4111111111111111 5500000000000004", "[CREDIT_CARD] [CREDIT_CARD]"},
+		// a number in a longer digit run is not redacted.
+		{"139123456789 and 10.0.0.256", "139123456789 and 10.0.0.256"},
+	}
+	for _, c := range cases {
+		if got, _ := r.Redact(c[0]); got != c[1] {
+			t.Errorf("Redact(%q) = %q, want %q", c[0], got, c[1])
+		}
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	r := New(Dictionary("name", []string{"Jason", "贾宝玉", "Medea"}))
+	s := "Jason met MEDEA, and 贾宝玉见了林黛玉; Jasonville is a town."
+	got, found := r.Redact(s)
+	want := "[NAME] met [NAME], and [NAME]见了林黛玉; Jasonville is a town."
+	if got != want {
+		t.Errorf("Redact(%q) = %q, want %q", s, got, want)
+	}
+	if len(found) != 3 || found[2].Offset != len("Jason met MEDEA, and ") || found[2].Length != len("贾宝玉") {
+		t.Errorf("unexpected redactions %v", found)
+	}
+
+	if rule := Dictionary("empty", nil); rule.Re != nil {
+		t.Errorf("empty dictionary should not match")
+	}
+}
@@ -140,14 +140,23 @@ func (t *SimpleTokenizer) outputLatin(pos int, yield func(Token) bool) {
 	if pos <= t.begin+MAX_TOKEN_SIZE {
 		bs = t.input[t.begin:pos]
 	} else {
-		if t.input[t.begin+MAX_TOKEN_SIZE-1] <= 127 {
-			bs = t.input[t.begin : t.begin+MAX_TOKEN_SIZE]
-		} else {
-			bs = t.input[t.begin : pos+MAX_TOKEN_SIZE-1]
+		// truncate at a rune boundary
+		end := t.begin + MAX_TOKEN_SIZE
+		for end > t.begin && !utf8.RuneStart(t.input[end]) {
+			end--
 		}
+		bs = t.input[t.begin:end]
 	}
 
+	// lower case may take more bytes, truncate again.
 	ls := strings.ToLower(string(bs))
+	if len(ls) > MAX_TOKEN_SIZE {
+		end := MAX_TOKEN_SIZE
+		for end > 0 && !utf8.RuneStart(ls[end]) {
+			end--
+		}
+		ls = ls[:end]
+	}
 	token := Token{}
 	token.TokenBytes[0] = byte(len(ls))
 	copy(token.TokenBytes[1:], []byte(ls))
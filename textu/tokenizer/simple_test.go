@@ -93,6 +93,15 @@ func TestLatin(t *testing.T) {
 		makeToken("long", 5),
 		makeToken("word", 6),
 	})
+	// long words are truncated at a rune boundary.
+	checkTokenize(t, "aaaaaaaaaaaaaaaaaaaaaaééééééééééé rest", []Token{
+		makeToken("aaaaaaaaaaaaaaaaaaaaaa", 0),
+		makeToken("rest", 1),
+	})
+	// and lower case taking more bytes too.
+	checkTokenize(t, "aaaaaaaaaaaaaaaaaaaaaİ", []Token{
+		makeToken("aaaaaaaaaaaaaaaaaaaaai", 0),
+	})
 }
 
 func TestCJK(t *testing.T) {